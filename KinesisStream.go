@@ -4,51 +4,171 @@ import (
   "fmt"
   "github.com/aws/aws-sdk-go/aws"
   "github.com/aws/aws-sdk-go/service/kinesis"
+  "sort"
+  "strings"
   "time"
   "errors"
 )
 
+// KinesisAPI is the part of the Kinesis service spur uses. It's met by
+// *kinesis.Kinesis, and by FakeKinesis for running without AWS.
+type KinesisAPI interface {
+  CreateStream(*kinesis.CreateStreamInput) (*kinesis.CreateStreamOutput, error)
+  DeleteStream(*kinesis.DeleteStreamInput) (*kinesis.DeleteStreamOutput, error)
+  DescribeStream(*kinesis.DescribeStreamInput) (*kinesis.DescribeStreamOutput, error)
+  ListStreams(*kinesis.ListStreamsInput) (*kinesis.ListStreamsOutput, error)
+  GetShardIterator(*kinesis.GetShardIteratorInput) (*kinesis.GetShardIteratorOutput, error)
+  GetRecords(*kinesis.GetRecordsInput) (*kinesis.GetRecordsOutput, error)
+  PutRecord(*kinesis.PutRecordInput) (*kinesis.PutRecordOutput, error)
+  PutRecords(*kinesis.PutRecordsInput) (*kinesis.PutRecordsOutput, error)
+  SplitShard(*kinesis.SplitShardInput) (*kinesis.SplitShardOutput, error)
+  MergeShards(*kinesis.MergeShardsInput) (*kinesis.MergeShardsOutput, error)
+  UpdateShardCount(*kinesis.UpdateShardCountInput) (*kinesis.UpdateShardCountOutput, error)
+  IncreaseStreamRetentionPeriod(*kinesis.IncreaseStreamRetentionPeriodInput) (*kinesis.IncreaseStreamRetentionPeriodOutput, error)
+  DecreaseStreamRetentionPeriod(*kinesis.DecreaseStreamRetentionPeriodInput) (*kinesis.DecreaseStreamRetentionPeriodOutput, error)
+  AddTagsToStream(*kinesis.AddTagsToStreamInput) (*kinesis.AddTagsToStreamOutput, error)
+  RemoveTagsFromStream(*kinesis.RemoveTagsFromStreamInput) (*kinesis.RemoveTagsFromStreamOutput, error)
+  ListTagsForStream(*kinesis.ListTagsForStreamInput) (*kinesis.ListTagsForStreamOutput, error)
+  StartStreamEncryption(*kinesis.StartStreamEncryptionInput) (*kinesis.StartStreamEncryptionOutput, error)
+  StopStreamEncryption(*kinesis.StopStreamEncryptionInput) (*kinesis.StopStreamEncryptionOutput, error)
+}
+
 type KinesisStream struct {
-  Service               *kinesis.Kinesis
+  Service               KinesisAPI
   Name                  string
   Partition             string
   ShardIteratorType     string
   ShardID               string
   NextShardIteratorName string
+
+  // Starting position for the AT_SEQUENCE_NUMBER, AFTER_SEQUENCE_NUMBER
+  // and AT_TIMESTAMP shard iterator types.
+  StartingSequenceNumber string
+  StartingTimestamp      time.Time
+
+  // Set once GetRecords has read to the end of a shard that was closed
+  // by a split or merge. See FollowChildShards.
+  ShardClosed bool
+
+  // Decides the partition key for each line written, the static Partition when nil.
+  Partitioner PartitionStrategy
+
+  // Wraps each line written, the log envelope when nil.
+  Envelope Envelope
+
+  // When set, reading resumes after the sequence number checkpointed for
+  // the shard, instead of the starting position. See CheckpointRecords.
+  Checkpoint *Checkpoint
+
+  // How records read are written: text, jsonl or csv.
+  Output string
 }
 
 type KinesisStreamGroup struct {
   Streams               map[string]*KinesisStream
   CurrentStream         *KinesisStream
-  Service               *kinesis.Kinesis
+  Service               KinesisAPI
   Region                string
+
+  // What streams in the group are set to until they're set otherwise.
+  Defaults StreamSettings
+}
+
+// StreamSettings are how spur reads and writes a stream, by the names
+// in streamSettingNames.
+type StreamSettings struct {
+  Partition         string
+  PartitionStrategy string
+  ShardID           string
+  IteratorType      string
+  Output            string
+}
+
+var streamSettingNames = []string{"partition", "shard", "iterator", "output", "partition-strategy"}
+
+// Get a setting by name.
+func (ss StreamSettings) Get(name string) string {
+  switch name {
+    case "partition": return ss.Partition
+    case "partition-strategy": return ss.PartitionStrategy
+    case "shard": return ss.ShardID
+    case "iterator": return ss.IteratorType
+    case "output": return ss.Output
+  }
+  return ""
 }
 
 func NewStream(config *aws.Config, name, partition, iteratorType, shardID string) *KinesisStream {
-  svc := kinesis.New(config)
-  return &KinesisStream{svc, name, partition, iteratorType, shardID, ""}
+  return NewStreamWithService(newKinesisService(config), name, partition, iteratorType, shardID)
+}
+
+func NewStreamWithService(svc KinesisAPI, name, partition, iteratorType, shardID string) *KinesisStream {
+  return &KinesisStream{Service: svc, Name: name, Partition: partition, ShardIteratorType: iteratorType, ShardID: shardID}
 }
 
 func NewStreamGroup(config *aws.Config) (g *KinesisStreamGroup, err error){
-  g = &KinesisStreamGroup{Streams: make(map[string]*KinesisStream), Service: kinesis.New(config), Region: config.Region}
+  return NewStreamGroupWithService(newKinesisService(config), config.Region)
+}
+
+func NewStreamGroupWithService(svc KinesisAPI, region string) (g *KinesisStreamGroup, err error){
+  g = &KinesisStreamGroup{Streams: make(map[string]*KinesisStream), Service: svc, Region: region}
   err = g.init()
   return g, err
 }
 
 func (g *KinesisStreamGroup) init() (err error){
-  streams, err := g.ListStreams()
+  names, err := g.StreamNames("")
   if err != nil {return err}
 
-  for _, description := range streams {
-    g.Streams[description.Name] = &KinesisStream{Service: g.Service, Name: description.Name}
+  for _, name := range names {
+    g.Streams[name] = g.newStream(name)
+  }
+
+  return nil
+}
+
+// SetDefaults sets the group's default settings, and gives them to the
+// streams in the group that have none.
+func (g *KinesisStreamGroup) SetDefaults(defaults StreamSettings) (err error) {
+  g.Defaults = defaults
+  for _, s := range g.Streams {
+    if err = s.setDefaults(defaults); err != nil {
+      return err
+    }
   }
+  return nil
+}
+
+func (g *KinesisStreamGroup) newStream(name string) *KinesisStream {
+  s := &KinesisStream{Service: g.Service, Name: name}
+  // The defaults were checked when they were set.
+  s.setDefaults(g.Defaults)
+  return s
+}
 
+func (s *KinesisStream) setDefaults(defaults StreamSettings) error {
+  for _, name := range streamSettingNames {
+    if s.Setting(name) == "" && defaults.Get(name) != "" {
+      if err := s.Set(name, defaults.Get(name)); err != nil {
+        return err
+      }
+    }
+  }
   return nil
 }
 
-func (g *KinesisStreamGroup) CreateKinesisStream(name string, shards int64) (*KinesisStream, error) {
+// CreateKinesisStream creates a stream, then once it's ACTIVE configures
+// its retention, tags and encryption if the config has any. The stream is
+// returned along with the error when only configuring it failed.
+func (g *KinesisStreamGroup) CreateKinesisStream(name string, shards int64, config StreamConfig) (*KinesisStream, error) {
   _, err := g.Service.CreateStream(&kinesis.CreateStreamInput{StreamName: &name, ShardCount: &shards})
-  g.Streams[name] = &KinesisStream{Service: g.Service, Name: name}
+  if err != nil {
+    return nil, err
+  }
+  g.Streams[name] = g.newStream(name)
+  if err = g.Streams[name].Configure(config); err != nil {
+    err = fmt.Errorf("Created stream %s, but couldn't configure it: %s", name, err)
+  }
   return g.Streams[name], err
 }
 
@@ -64,7 +184,10 @@ func (g *KinesisStreamGroup) GetStream(name string) (stream *KinesisStream, err
 func (g *KinesisStreamGroup) DeleteKinesisStream(name string) (*KinesisStream, error) {
   stream, err := g.GetStream(name)
   if err == nil {
-    delete(g.Streams, name)
+    // A dry run leaves the stream where it is.
+    if !isDryRun(g.Service) {
+      delete(g.Streams, name)
+    }
     _, err = stream.Service.DeleteStream(&kinesis.DeleteStreamInput{StreamName: &name})
   }
   return stream, err
@@ -78,42 +201,6 @@ func (g* KinesisStreamGroup) SetCurrentStream(name string) (err error) {
   return err
 }
 
-type StreamDescription struct {
-  Name        string
-  Description *kinesis.StreamDescription
-}
-
-func (g *KinesisStreamGroup) ListStreams() (streams []*StreamDescription, err error) {
-
-  for {
-
-    output, err := g.Service.ListStreams(&kinesis.ListStreamsInput{})
-    if err != nil {
-      return streams, err
-    }
-
-    var name string
-    for _, name := range output.StreamNames {
-      descript, err := g.Service.DescribeStream(&kinesis.DescribeStreamInput{StreamName: name})
-      if err != nil {
-        return streams, err
-      }
-      streams = append(streams, &StreamDescription{Name: *name, Description: descript.StreamDescription})
-    }
-
-    if *output.HasMoreStreams {
-      output, err = g.Service.ListStreams(&kinesis.ListStreamsInput{ExclusiveStartStreamName: &name})
-      if err != nil {
-        return streams, err
-      }
-    } else {
-      break
-    }
-  }
-
-  return streams, nil
-}
-
 func (g *KinesisStreamGroup) String() string {
   return fmt.Sprintf("In %s there are %d streams, current: %s",g.Region, len(g.Streams), g.CurrentStream.Name)
 }
@@ -127,20 +214,66 @@ func (g *KinesisStreamGroup) Description() (s string) {
 }
 
 func (s *KinesisStream) PutLogLine(line string) (*kinesis.PutRecordOutput, error) {
-  logString := fmt.Sprintf("[ %s ] %s", time.Now().UTC().Format(time.RFC1123Z), line)
-  logData := []byte(logString)
+  logData := s.wrap(line, nil)
+  partitionKey, explicitHashKey := s.partitionKey(line)
   record := &kinesis.PutRecordInput{
-    Data:         logData,
-    PartitionKey: aws.String(s.Partition),
-    StreamName:   aws.String(s.Name),
+    Data:            logData,
+    PartitionKey:    aws.String(partitionKey),
+    ExplicitHashKey: explicitHashKey,
+    StreamName:      aws.String(s.Name),
   }
 
   // resp, err := s.Service.PutRecord(record)
   return s.Service.PutRecord(record)
 }
 
+// Work out where line goes with the Partitioner, if there is one.
+func (s *KinesisStream) partitionKey(line string) (partitionKey string, explicitHashKey *string) {
+  if s.Partitioner == nil {
+    return s.Partition, nil
+  }
+  partitionKey, hashKey := s.Partitioner.PartitionKey(line)
+  if hashKey != "" {
+    explicitHashKey = aws.String(hashKey)
+  }
+  return partitionKey, explicitHashKey
+}
+
+// Wrap the line in the stream's envelope, by default prefixing it with
+// the current time, log style.
+func (s *KinesisStream) wrap(line string, source *LineSource) []byte {
+  if s.Envelope == nil {
+    return logEnvelope("").Wrap(line, source)
+  }
+  return s.Envelope.Wrap(line, source)
+}
+
+// SetStartingPosition sets where the next read starts. The sequence number
+// is used by AT_SEQUENCE_NUMBER and AFTER_SEQUENCE_NUMBER, the timestamp
+// by AT_TIMESTAMP.
+func (s *KinesisStream) SetStartingPosition(iteratorType, sequenceNumber string, timestamp time.Time) (err error) {
+  switch iteratorType {
+    case "AT_SEQUENCE_NUMBER", "AFTER_SEQUENCE_NUMBER":
+      if sequenceNumber == "" {
+        return fmt.Errorf("%s needs a sequence number to start from", iteratorType)
+      }
+    case "AT_TIMESTAMP":
+      if timestamp.IsZero() {
+        return fmt.Errorf("%s needs a time to start from", iteratorType)
+      }
+    case "TRIM_HORIZON", "LATEST":
+    default:
+      return fmt.Errorf("Unknown shard iterator type: \"%s\"", iteratorType)
+  }
+  s.ShardIteratorType = iteratorType
+  s.StartingSequenceNumber = sequenceNumber
+  s.StartingTimestamp = timestamp
+  return nil
+}
+
 func (s *KinesisStream) ReadReset() {
   s.NextShardIteratorName = ""
+  s.ShardClosed = false
 }
 
 func (s *KinesisStream) GetRecords() (output *kinesis.GetRecordsOutput, err error) {
@@ -148,8 +281,8 @@ func (s *KinesisStream) GetRecords() (output *kinesis.GetRecordsOutput, err erro
   // The read records funciton needs a ShardIterator to determine
   // which records to read. This first one can either be told
   // to start with the oldest available (TRIM_HORIZON), the latest
-  // LATEST, or relative to an actual point AT_SEQUENCE_NUMBER", "AFTER_SEQUENCE_NUMBER"
-  // Have to add more UI to get the sequence AT/AFTER Sequence number adeed in.
+  // LATEST, relative to an actual point AT_SEQUENCE_NUMBER, AFTER_SEQUENCE_NUMBER,
+  // or at a point in time AT_TIMESTAMP. See SetStartingPosition.
 
   // First time through, we need to get a Shard Iterator
   if s.NextShardIteratorName == "" {
@@ -165,19 +298,103 @@ func (s *KinesisStream) GetRecords() (output *kinesis.GetRecordsOutput, err erro
   }
 
   output, err = s.Service.GetRecords(params)
-  s.NextShardIteratorName = *output.NextShardIterator
+  if err != nil {
+    return output, err
+  }
+
+  // Once a closed shard has been read to the end there is no next iterator,
+  // the rest of the data is in its children.
+  if output.NextShardIterator == nil {
+    s.NextShardIteratorName = ""
+    s.ShardClosed = true
+  } else {
+    s.NextShardIteratorName = *output.NextShardIterator
+  }
 
   return output, err
 
 }
 
+// CheckpointRecords marks records read from the stream's shard as processed
+// and saves the checkpoint, if there is one.
+func (s *KinesisStream) CheckpointRecords(records []*kinesis.Record) error {
+  if s.Checkpoint == nil || len(records) == 0 {
+    return nil
+  }
+  s.Checkpoint.Mark(s.ShardID, *records[len(records)-1].SequenceNumber)
+  return s.Checkpoint.Save()
+}
+
+// GetChildShards returns the shards created when this stream's shard
+// was split or merged, ordered by shard ID.
+func (s *KinesisStream) GetChildShards() (children []*kinesis.Shard, err error) {
+  shards, err := s.GetShards()
+  if err != nil {
+    return children, err
+  }
+  for _, shard := range shards {
+    if isChildShard(shard, s.ShardID) {
+      children = append(children, shard)
+    }
+  }
+  sort.Sort(byShardID(children))
+  return children, nil
+}
+
+// FollowChildShards moves reading on from a closed shard to its children.
+// Children are read from the start. A merge leaves one child, which the
+// stream switches to. A split leaves more than one, they need reading at
+// once and are returned for the caller to read, e.g. with ReadShards.
+func (s *KinesisStream) FollowChildShards() (others []string, err error) {
+  children, err := s.GetChildShards()
+  if err != nil || len(children) == 0 {
+    return nil, err
+  }
+  if err = s.SetStartingPosition("TRIM_HORIZON", "", time.Time{}); err != nil {
+    return nil, err
+  }
+  if len(children) > 1 {
+    for _, child := range children {
+      others = append(others, *child.ShardID)
+    }
+    return others, nil
+  }
+  s.ShardID = *children[0].ShardID
+  s.ReadReset()
+  return nil, nil
+}
+
+func isChildShard(shard *kinesis.Shard, parentID string) bool {
+  return (shard.ParentShardID != nil && *shard.ParentShardID == parentID) ||
+    (shard.AdjacentParentShardID != nil && *shard.AdjacentParentShardID == parentID)
+}
+
+type byShardID []*kinesis.Shard
+
+func (b byShardID) Len() int           { return len(b) }
+func (b byShardID) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byShardID) Less(i, j int) bool { return *b[i].ShardID < *b[j].ShardID }
+
 func (s *KinesisStream) getFirstShardIteratorName() error {
 
+  iteratorType, sequenceNumber := s.ShardIteratorType, s.StartingSequenceNumber
+  if s.Checkpoint != nil {
+    if last, ok := s.Checkpoint.SequenceNumber(s.ShardID); ok {
+      iteratorType, sequenceNumber = "AFTER_SEQUENCE_NUMBER", last
+    }
+  }
+
   params := &kinesis.GetShardIteratorInput{
     ShardID:           aws.String(s.ShardID),
-    ShardIteratorType: aws.String(s.ShardIteratorType),
+    ShardIteratorType: aws.String(iteratorType),
     StreamName:        aws.String(s.Name),
   }
+  switch iteratorType {
+    case "AT_SEQUENCE_NUMBER", "AFTER_SEQUENCE_NUMBER":
+      params.StartingSequenceNumber = aws.String(sequenceNumber)
+    case "AT_TIMESTAMP":
+      params.Timestamp = aws.Time(s.StartingTimestamp)
+  }
   output, err := s.Service.GetShardIterator(params)
   if err == nil {
     s.NextShardIteratorName = *output.ShardIterator
@@ -192,31 +409,134 @@ func (s *KinesisStream) String() (string) {
 func (s *KinesisStream) Description() string {
   return fmt.Sprintf("Name: \"%s\"\n", s.Name) +
     fmt.Sprintf("Partition: \"%s\"\n", s.Partition) +
+    fmt.Sprintf("PartitionStrategy: \"%s\"\n", s.partitionStrategyName()) +
+    fmt.Sprintf("Envelope: \"%s\"\n", s.envelopeName()) +
     fmt.Sprintf("ShardIteratorType: \"%s\"\n", s.ShardIteratorType) +
+    s.startingPositionDescription() +
     fmt.Sprintf("ShardID: \"%s\"\n", s.ShardID) +
+    fmt.Sprintf("Output: \"%s\"\n", s.outputName()) +
     fmt.Sprintf("NextShardIteratorName: \"%s\"\n", s.NextShardIteratorName)
 }
 
 
+// Setting is the value of one of the streamSettingNames.
+func (s *KinesisStream) Setting(name string) string {
+  switch name {
+    case "partition": return s.Partition
+    case "partition-strategy":
+      if s.Partitioner == nil {
+        return ""
+      }
+      return s.Partitioner.String()
+    case "shard": return s.ShardID
+    case "iterator": return s.ShardIteratorType
+    case "output": return s.Output
+  }
+  return ""
+}
+
+// Set one of the streamSettingNames, checking the value.
+func (s *KinesisStream) Set(name, value string) (err error) {
+  switch name {
+    case "partition":
+      s.Partition = value
+      // The strategy falls back to the partition, so it needs remaking.
+      if s.Partitioner != nil {
+        s.Partitioner, err = NewPartitionStrategy(s.Partitioner.String(), value, s)
+      }
+    case "partition-strategy":
+      s.Partitioner, err = NewPartitionStrategy(value, s.Partition, s)
+    case "shard":
+      s.ShardID = value
+    case "iterator":
+      if value != "" && !contains(iteratorTypes, value) {
+        return fmt.Errorf("The iterator must be one of %s, not %s", strings.Join(iteratorTypes, ", "), value)
+      }
+      s.ShardIteratorType = value
+    case "output":
+      if value != "" && !contains(outputFormats, value) {
+        return fmt.Errorf("The output must be one of %s, not %s", strings.Join(outputFormats, ", "), value)
+      }
+      s.Output = value
+    default:
+      return fmt.Errorf("Unknown setting \"%s\", use one of: %s", name, strings.Join(streamSettingNames, ", "))
+  }
+  return err
+}
+
+func (s *KinesisStream) partitionStrategyName() string {
+  if s.Partitioner == nil {
+    return "static"
+  }
+  return s.Partitioner.String()
+}
+
+func (s *KinesisStream) outputName() string {
+  if s.Output == "" {
+    return "text"
+  }
+  return s.Output
+}
+
+func (s *KinesisStream) envelopeName() string {
+  if s.Envelope == nil {
+    return "log"
+  }
+  return s.Envelope.String()
+}
+
+func (s *KinesisStream) startingPositionDescription() string {
+  switch s.ShardIteratorType {
+    case "AT_SEQUENCE_NUMBER", "AFTER_SEQUENCE_NUMBER":
+      return fmt.Sprintf("StartingSequenceNumber: \"%s\"\n", s.StartingSequenceNumber)
+    case "AT_TIMESTAMP":
+      return fmt.Sprintf("StartingTimestamp: \"%s\"\n", s.StartingTimestamp.Format(time.RFC3339))
+  }
+  return ""
+}
 
 func (s* KinesisStream) GetAWSDescription() (*kinesis.StreamDescription, error) {
   res, err := s.Service.DescribeStream(&kinesis.DescribeStreamInput{StreamName: &s.Name})
-  return res.StreamDescription, err
-}
-
-func (s* KinesisStream) WaitForStateChange(delaySeconds, periodSeconds int, currentState string, cb func(string, error)) {
-  go func() {
-    time.Sleep(time.Second * time.Duration(delaySeconds))
-    var e error
-    var status string
-    for sd, e := s.GetAWSDescription(); e == nil;  {
-      if *sd.StreamStatus != currentState {
-        status = *sd.StreamStatus
-        break;
-      }
-      time.Sleep(time.Second * time.Duration(periodSeconds))
-      sd, e = s.GetAWSDescription()
+  if err != nil {
+    return nil, err
+  }
+  return res.StreamDescription, nil
+}
+
+// GetShards returns every shard in the stream, open and closed.
+func (s *KinesisStream) GetShards() (shards []*kinesis.Shard, err error) {
+  params := &kinesis.DescribeStreamInput{StreamName: aws.String(s.Name)}
+  for {
+    res, err := s.Service.DescribeStream(params)
+    if err != nil {
+      return shards, err
+    }
+    shards = append(shards, res.StreamDescription.Shards...)
+    if len(res.StreamDescription.Shards) == 0 || !*res.StreamDescription.HasMoreShards {
+      break
+    }
+    params.ExclusiveStartShardID = shards[len(shards)-1].ShardID
+  }
+  return shards, nil
+}
+
+// GetOpenShards returns the shards still accepting records. Closed
+// shards have an ending sequence number.
+func (s *KinesisStream) GetOpenShards() (open []*kinesis.Shard, err error) {
+  shards, err := s.GetShards()
+  for _, shard := range shards {
+    if shard.SequenceNumberRange == nil || shard.SequenceNumberRange.EndingSequenceNumber == nil {
+      open = append(open, shard)
     }
-    cb(status, e)
-  }()
+  }
+  return open, err
+}
+
+// ForShard returns a copy of the stream set up to read from shardID
+// from the same starting position.
+func (s *KinesisStream) ForShard(shardID string) *KinesisStream {
+  shard := *s
+  shard.ShardID = shardID
+  shard.NextShardIteratorName = ""
+  return &shard
 }
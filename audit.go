@@ -0,0 +1,268 @@
+package main
+
+import (
+  "bufio"
+  "encoding/json"
+  "fmt"
+  "github.com/aws/aws-sdk-go/aws"
+  "github.com/aws/aws-sdk-go/service/kinesis"
+  "github.com/aws/aws-sdk-go/service/sts"
+  "io"
+  "os"
+  "os/user"
+  "path/filepath"
+  "reflect"
+  "sync"
+  "time"
+)
+
+// The audit log, ~/.spur/audit.log.
+func auditFile() string {
+  home, err := os.UserHomeDir()
+  if err != nil {
+    home = "."
+  }
+  return filepath.Join(home, ".spur", "audit.log")
+}
+
+// AuditEntry is one call that changed a stream, a line of JSON in the
+// audit log. Arguments and Result are the call's input and output as
+// they go over the wire, Error is set instead of Result when it failed.
+type AuditEntry struct {
+  Time      time.Time   `json:"time"`
+  User      string      `json:"user"`
+  Identity  string      `json:"identity,omitempty"`
+  Region    string      `json:"region,omitempty"`
+  Operation string      `json:"operation"`
+  Stream    string      `json:"stream,omitempty"`
+  Arguments interface{} `json:"arguments,omitempty"`
+  Result    interface{} `json:"result,omitempty"`
+  Error     string      `json:"error,omitempty"`
+}
+
+func (e AuditEntry) String() string {
+  args, _ := json.Marshal(e.Arguments)
+  result := "OK"
+  if e.Error != "" {
+    result = "Failed: " + e.Error
+  }
+  return fmt.Sprintf("%s %s (%s) %s %s %s %s %s", e.Time.Format(time.RFC3339), e.User, e.Identity, e.Region,
+    e.Operation, e.Stream, args, result)
+}
+
+// AuditLog appends entries to a file, which it makes along with its
+// directory if need be. Several spurs can share a log.
+type AuditLog struct {
+  File string
+  mu   sync.Mutex
+}
+
+func (l *AuditLog) Append(e AuditEntry) error {
+  data, err := json.Marshal(e)
+  if err != nil {
+    return err
+  }
+  l.mu.Lock()
+  defer l.mu.Unlock()
+  if err = os.MkdirAll(filepath.Dir(l.File), 0700); err != nil {
+    return err
+  }
+  f, err := os.OpenFile(l.File, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+  if err != nil {
+    return err
+  }
+  // One write a line, so lines from different spurs don't interleave.
+  _, err = f.Write(append(data, '\n'))
+  if cerr := f.Close(); err == nil {
+    err = cerr
+  }
+  return err
+}
+
+// AuditQuery picks entries by stream and time, the zero value picks them all.
+type AuditQuery struct {
+  Stream       string
+  Since, Until time.Time
+}
+
+func (q AuditQuery) matches(e AuditEntry) bool {
+  return (q.Stream == "" || e.Stream == q.Stream) &&
+    (q.Since.IsZero() || !e.Time.Before(q.Since)) &&
+    (q.Until.IsZero() || e.Time.Before(q.Until))
+}
+
+// Query reads the entries that match, oldest first. There are none
+// until something has been logged.
+func (l *AuditLog) Query(q AuditQuery) (entries []AuditEntry, err error) {
+  f, err := os.Open(l.File)
+  if os.IsNotExist(err) {
+    return nil, nil
+  }
+  if err != nil {
+    return nil, err
+  }
+  defer f.Close()
+  scanner := bufio.NewScanner(f)
+  scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+  for n := 1; scanner.Scan(); n++ {
+    var e AuditEntry
+    if err = json.Unmarshal(scanner.Bytes(), &e); err != nil {
+      return entries, fmt.Errorf("%s:%d: %s", l.File, n, err)
+    }
+    if q.matches(e) {
+      entries = append(entries, e)
+    }
+  }
+  return entries, scanner.Err()
+}
+
+func writeAudit(w io.Writer, entries []AuditEntry, jsonl bool) {
+  for _, e := range entries {
+    if jsonl {
+      data, _ := json.Marshal(e)
+      fmt.Fprintf(w, "%s\n", data)
+    } else {
+      fmt.Fprintln(w, e)
+    }
+  }
+}
+
+// How long to wait for STS to say who the credentials belong to.
+var identityTimeout = 5 * time.Second
+
+// The ARN the credentials belong to, asked of STS, or the endpoint when
+// there's one, as STS won't be there on an emulator. Gives up after
+// identityTimeout so the call being logged isn't held up.
+func callerIdentity(config *aws.Config) string {
+  if config.Endpoint != "" {
+    return "endpoint " + config.Endpoint
+  }
+  c := *config
+  c.MaxRetries = 0
+  identity := make(chan string, 1)
+  go func() {
+    output, err := sts.New(&c).GetCallerIdentity(&sts.GetCallerIdentityInput{})
+    switch {
+      case err != nil: identity <- fmt.Sprintf("unknown: %s", err)
+      case output == nil || output.ARN == nil: identity <- "unknown"
+      default: identity <- *output.ARN
+    }
+  }()
+  select {
+    case arn := <-identity:
+      return arn
+    case <-time.After(identityTimeout):
+      return "unknown: STS didn't answer in " + identityTimeout.String()
+  }
+}
+
+// The local user making the calls, $USER when there isn't one, as under
+// some crons.
+func auditUser() string {
+  if u, err := user.Current(); err == nil && u.Username != "" {
+    return u.Username
+  }
+  return os.Getenv("USER")
+}
+
+// AuditKinesis logs the calls that create, delete, reshard, or change
+// the retention, tags or encryption of streams. Reading and writing
+// records aren't logged.
+type AuditKinesis struct {
+  KinesisAPI
+  Log    *AuditLog
+  Region string
+
+  // Who the calls are made as, asked for once on the first call.
+  Identity     func() string
+  identity     string
+  identityOnce sync.Once
+}
+
+func NewAuditKinesis(svc KinesisAPI, log *AuditLog, config *aws.Config) *AuditKinesis {
+  return &AuditKinesis{KinesisAPI: svc, Log: log, Region: config.Region,
+    Identity: func() string { return callerIdentity(config) }}
+}
+
+func (a *AuditKinesis) record(operation string, input, output interface{}, err error) {
+  a.identityOnce.Do(func() { a.identity = a.Identity() })
+  e := AuditEntry{Time: time.Now(), User: auditUser(), Identity: a.identity, Region: a.Region,
+    Operation: operation, Arguments: toWire(reflect.ValueOf(input))}
+  if args, ok := e.Arguments.(map[string]interface{}); ok {
+    e.Stream, _ = args["StreamName"].(string)
+  }
+  if err != nil {
+    e.Error = err.Error()
+  } else {
+    e.Result = toWire(reflect.ValueOf(output))
+  }
+  if err = a.Log.Append(e); err != nil {
+    fmt.Fprintf(os.Stderr, "Couldn't write %s to the audit log: %s\n", operation, err)
+  }
+}
+
+func (a *AuditKinesis) CreateStream(input *kinesis.CreateStreamInput) (*kinesis.CreateStreamOutput, error) {
+  output, err := a.KinesisAPI.CreateStream(input)
+  a.record("CreateStream", input, output, err)
+  return output, err
+}
+
+func (a *AuditKinesis) DeleteStream(input *kinesis.DeleteStreamInput) (*kinesis.DeleteStreamOutput, error) {
+  output, err := a.KinesisAPI.DeleteStream(input)
+  a.record("DeleteStream", input, output, err)
+  return output, err
+}
+
+func (a *AuditKinesis) SplitShard(input *kinesis.SplitShardInput) (*kinesis.SplitShardOutput, error) {
+  output, err := a.KinesisAPI.SplitShard(input)
+  a.record("SplitShard", input, output, err)
+  return output, err
+}
+
+func (a *AuditKinesis) MergeShards(input *kinesis.MergeShardsInput) (*kinesis.MergeShardsOutput, error) {
+  output, err := a.KinesisAPI.MergeShards(input)
+  a.record("MergeShards", input, output, err)
+  return output, err
+}
+
+func (a *AuditKinesis) UpdateShardCount(input *kinesis.UpdateShardCountInput) (*kinesis.UpdateShardCountOutput, error) {
+  output, err := a.KinesisAPI.UpdateShardCount(input)
+  a.record("UpdateShardCount", input, output, err)
+  return output, err
+}
+
+func (a *AuditKinesis) IncreaseStreamRetentionPeriod(input *kinesis.IncreaseStreamRetentionPeriodInput) (*kinesis.IncreaseStreamRetentionPeriodOutput, error) {
+  output, err := a.KinesisAPI.IncreaseStreamRetentionPeriod(input)
+  a.record("IncreaseStreamRetentionPeriod", input, output, err)
+  return output, err
+}
+
+func (a *AuditKinesis) DecreaseStreamRetentionPeriod(input *kinesis.DecreaseStreamRetentionPeriodInput) (*kinesis.DecreaseStreamRetentionPeriodOutput, error) {
+  output, err := a.KinesisAPI.DecreaseStreamRetentionPeriod(input)
+  a.record("DecreaseStreamRetentionPeriod", input, output, err)
+  return output, err
+}
+
+func (a *AuditKinesis) AddTagsToStream(input *kinesis.AddTagsToStreamInput) (*kinesis.AddTagsToStreamOutput, error) {
+  output, err := a.KinesisAPI.AddTagsToStream(input)
+  a.record("AddTagsToStream", input, output, err)
+  return output, err
+}
+
+func (a *AuditKinesis) RemoveTagsFromStream(input *kinesis.RemoveTagsFromStreamInput) (*kinesis.RemoveTagsFromStreamOutput, error) {
+  output, err := a.KinesisAPI.RemoveTagsFromStream(input)
+  a.record("RemoveTagsFromStream", input, output, err)
+  return output, err
+}
+
+func (a *AuditKinesis) StartStreamEncryption(input *kinesis.StartStreamEncryptionInput) (*kinesis.StartStreamEncryptionOutput, error) {
+  output, err := a.KinesisAPI.StartStreamEncryption(input)
+  a.record("StartStreamEncryption", input, output, err)
+  return output, err
+}
+
+func (a *AuditKinesis) StopStreamEncryption(input *kinesis.StopStreamEncryptionInput) (*kinesis.StopStreamEncryptionOutput, error) {
+  output, err := a.KinesisAPI.StopStreamEncryption(input)
+  a.record("StopStreamEncryption", input, output, err)
+  return output, err
+}
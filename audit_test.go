@@ -0,0 +1,91 @@
+package main
+
+import (
+  "bytes"
+  "io/ioutil"
+  "os"
+  "os/user"
+  "path/filepath"
+  "strings"
+  "testing"
+  "time"
+  "github.com/aws/aws-sdk-go/aws"
+  "github.com/aws/aws-sdk-go/service/kinesis"
+  . "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAudit(t *testing.T) {
+
+  Convey("Given a fake Kinesis that logs to an audit log", t, func() {
+    dir, _ := ioutil.TempDir("", "spur-audit")
+    defer os.RemoveAll(dir)
+    log := &AuditLog{File: filepath.Join(dir, ".spur", "audit.log")}
+    fake, s := newFakeStream("clicks", 1)
+    lookups := 0
+    svc := &AuditKinesis{KinesisAPI: fake, Log: log, Region: "us-west-1",
+      Identity: func() string { lookups++; return "arn:aws:iam::123456789012:user/ops" }}
+    s.Service = svc
+    g := streamGroupFor(svc, s)
+    saved := statusPollInterval
+    statusPollInterval = 5 * time.Millisecond
+    defer func() { statusPollInterval = saved }()
+
+    start := time.Now()
+    captureStdout(func() {
+      So(DoICommand("create orders --shards 2 --retention 48h --tag team=data", g), ShouldBeNil)
+      So(DoICommand("shards split shardId-000000000000 --even", g), ShouldBeNil)
+      So(DoICommand("iterate 3 hello", g), ShouldBeNil)
+      So(DoICommand("delete orders --yes", g), ShouldBeNil)
+    })
+    entries, err := log.Query(AuditQuery{})
+    So(err, ShouldBeNil)
+
+    Convey("Each change should be logged with who made it, where, and how it went", func() {
+      var operations []string
+      for _, e := range entries {
+        operations = append(operations, e.Operation)
+      }
+      So(operations, ShouldResemble, []string{"CreateStream", "AddTagsToStream", "IncreaseStreamRetentionPeriod", "SplitShard", "DeleteStream"})
+      e := entries[0]
+      So(e.Stream, ShouldEqual, "orders")
+      u, _ := user.Current()
+      So(e.User, ShouldEqual, u.Username)
+      So(e.Identity, ShouldEqual, "arn:aws:iam::123456789012:user/ops")
+      So(e.Region, ShouldEqual, "us-west-1")
+      So(e.Arguments, ShouldResemble, map[string]interface{}{"StreamName": "orders", "ShardCount": float64(2)})
+      So(e.Error, ShouldBeEmpty)
+      So(lookups, ShouldEqual, 1)
+    })
+
+    Convey("Calls to an endpoint should be logged as made there, without asking STS", func() {
+      So(callerIdentity(&aws.Config{Region: "us-west-1", Endpoint: "http://localhost:4567"}), ShouldEqual, "endpoint http://localhost:4567")
+    })
+
+    Convey("Failed calls should be logged with their error", func() {
+      _, err := svc.DeleteStream(&kinesis.DeleteStreamInput{StreamName: aws.String("nosuch")})
+      So(err, ShouldNotBeNil)
+      found, _ := log.Query(AuditQuery{Stream: "nosuch"})
+      So(found, ShouldHaveLength, 1)
+      So(found[0].Error, ShouldEqual, err.Error())
+      So(found[0].Result, ShouldBeNil)
+    })
+
+    Convey("Entries should be found by stream and time", func() {
+      found, _ := log.Query(AuditQuery{Stream: "clicks"})
+      So(found, ShouldHaveLength, 1)
+      So(found[0].Operation, ShouldEqual, "SplitShard")
+      found, _ = log.Query(AuditQuery{Since: start, Until: time.Now()})
+      So(found, ShouldHaveLength, len(entries))
+      found, _ = log.Query(AuditQuery{Until: start})
+      So(found, ShouldBeEmpty)
+    })
+
+    Convey("Entries should show as text or JSON lines", func() {
+      var text, jsonl bytes.Buffer
+      writeAudit(&text, entries[:1], false)
+      writeAudit(&jsonl, entries[:1], true)
+      So(text.String(), ShouldContainSubstring, `(arn:aws:iam::123456789012:user/ops) us-west-1 CreateStream orders {"ShardCount":2,"StreamName":"orders"} OK`)
+      So(strings.HasPrefix(jsonl.String(), `{"time":`), ShouldBeTrue)
+    })
+  })
+}
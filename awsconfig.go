@@ -0,0 +1,277 @@
+package main
+
+import (
+  "bufio"
+  "fmt"
+  "github.com/aws/aws-sdk-go/aws"
+  "github.com/aws/aws-sdk-go/aws/credentials"
+  "github.com/aws/aws-sdk-go/service/sts"
+  "os"
+  "path/filepath"
+  "strconv"
+  "strings"
+  "time"
+)
+
+// Used when neither the flags, the environment nor the profile name a region.
+const defaultRegion = "us-west-1"
+
+// AWSOptions are the command line choices for reaching AWS,
+// empty strings fall back on the environment and ~/.aws/config.
+type AWSOptions struct {
+  Profile     string
+  Region      string
+  EndpointURL string
+}
+
+// AWSProfiles holds the profiles from ~/.aws/config and ~/.aws/credentials
+// (or $AWS_CONFIG_FILE and $AWS_SHARED_CREDENTIALS_FILE).
+type AWSProfiles struct {
+  CredentialsFile string
+  Config          map[string]map[string]string
+  Credentials     map[string]map[string]string
+}
+
+func LoadAWSProfiles() (*AWSProfiles, error) {
+  home, _ := os.UserHomeDir()
+  configFile := os.Getenv("AWS_CONFIG_FILE")
+  if configFile == "" {
+    configFile = filepath.Join(home, ".aws", "config")
+  }
+  p := &AWSProfiles{CredentialsFile: os.Getenv("AWS_SHARED_CREDENTIALS_FILE")}
+  if p.CredentialsFile == "" {
+    p.CredentialsFile = filepath.Join(home, ".aws", "credentials")
+  }
+
+  sections, err := readINI(configFile)
+  if err != nil {
+    return nil, err
+  }
+  // The config file names its sections [profile name], except for [default].
+  p.Config = make(map[string]map[string]string)
+  for name, values := range sections {
+    p.Config[strings.TrimSpace(strings.TrimPrefix(name, "profile "))] = values
+  }
+  p.Credentials, err = readINI(p.CredentialsFile)
+  return p, err
+}
+
+// Read the sections of an ini file, a missing file has none.
+func readINI(path string) (map[string]map[string]string, error) {
+  sections := make(map[string]map[string]string)
+  f, err := os.Open(path)
+  if os.IsNotExist(err) {
+    return sections, nil
+  }
+  if err != nil {
+    return nil, err
+  }
+  defer f.Close()
+
+  var section map[string]string
+  scanner := bufio.NewScanner(f)
+  for n := 1; scanner.Scan(); n++ {
+    raw := strings.TrimSuffix(scanner.Text(), "\r")
+    line := strings.TrimSpace(raw)
+    switch {
+      case line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";"):
+      // Indented lines are nested settings (e.g. under s3 =), we don't use them.
+      case section != nil && strings.TrimLeft(raw, " \t") != raw && !strings.HasPrefix(line, "["):
+      case strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]"):
+        name := strings.TrimSpace(line[1 : len(line)-1])
+        if sections[name] == nil {
+          sections[name] = make(map[string]string)
+        }
+        section = sections[name]
+      case strings.Contains(line, "=") && section != nil:
+        parts := strings.SplitN(line, "=", 2)
+        section[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+      default:
+        return nil, fmt.Errorf("%s:%d: can't make sense of %q", path, n, line)
+    }
+  }
+  return sections, scanner.Err()
+}
+
+// Look up a profile setting, the credentials file wins over the config file.
+func (p *AWSProfiles) get(profile, key string) string {
+  if v := p.Credentials[profile][key]; v != "" {
+    return v
+  }
+  return p.Config[profile][key]
+}
+
+func (p *AWSProfiles) exists(profile string) bool {
+  return p.Config[profile] != nil || p.Credentials[profile] != nil
+}
+
+// Profile picks --profile, then $AWS_PROFILE, then default.
+func (o AWSOptions) profile() (name string, explicit bool) {
+  for _, name := range []string{o.Profile, os.Getenv("AWS_PROFILE"), os.Getenv("AWS_DEFAULT_PROFILE")} {
+    if name != "" {
+      return name, true
+    }
+  }
+  return "default", false
+}
+
+// Region picks --region, then $AWS_REGION, then the profile's region
+// (or its source profile's), then us-west-1.
+func (o AWSOptions) region(p *AWSProfiles, profile string) string {
+  for _, region := range []string{o.Region, os.Getenv("AWS_REGION"), os.Getenv("AWS_DEFAULT_REGION")} {
+    if region != "" {
+      return region
+    }
+  }
+  for seen := map[string]bool{}; profile != "" && !seen[profile]; profile = p.get(profile, "source_profile") {
+    seen[profile] = true
+    if region := p.get(profile, "region"); region != "" {
+      return region
+    }
+  }
+  return defaultRegion
+}
+
+// Endpoint picks --endpoint-url, then $AWS_ENDPOINT_URL_KINESIS, then $AWS_ENDPOINT_URL.
+func (o AWSOptions) endpoint() string {
+  for _, url := range []string{o.EndpointURL, os.Getenv("AWS_ENDPOINT_URL_KINESIS"), os.Getenv("AWS_ENDPOINT_URL")} {
+    if url != "" {
+      return url
+    }
+  }
+  return ""
+}
+
+// LoadAWSConfig works out the region, endpoint and credentials to use.
+// Profiles that declare a role_arn assume that role using the credentials
+// of their source_profile, which may itself assume a role.
+func LoadAWSConfig(o AWSOptions) (*aws.Config, error) {
+  p, err := LoadAWSProfiles()
+  if err != nil {
+    return nil, err
+  }
+  profile, explicit := o.profile()
+  if explicit && !p.exists(profile) {
+    return nil, fmt.Errorf("No profile named %s in the AWS config or credentials files.", profile)
+  }
+
+  config := &aws.Config{Region: o.region(p, profile), Endpoint: o.endpoint()}
+  if strings.HasPrefix(config.Endpoint, "http://") {
+    config.DisableSSL = true
+  }
+
+  // Without a profile to use, leave the SDK to find credentials as it always has.
+  if explicit || p.get(profile, "role_arn") != "" {
+    config.Credentials, err = p.credentials(profile, config, map[string]bool{})
+    if err != nil {
+      return nil, err
+    }
+  }
+  return aws.DefaultConfig.Merge(config), nil
+}
+
+// The credentials for a profile, following source_profile through role chains.
+func (p *AWSProfiles) credentials(profile string, config *aws.Config, seen map[string]bool) (*credentials.Credentials, error) {
+  if seen[profile] {
+    return nil, fmt.Errorf("The AWS profile %s is its own source_profile, through a chain of roles.", profile)
+  }
+  seen[profile] = true
+  if !p.exists(profile) {
+    return nil, fmt.Errorf("No profile named %s in the AWS config or credentials files.", profile)
+  }
+
+  roleARN := p.get(profile, "role_arn")
+  if roleARN == "" {
+    if p.Credentials[profile]["aws_access_key_id"] != "" {
+      return credentials.NewSharedCredentials(p.CredentialsFile, profile), nil
+    }
+    if id := p.Config[profile]["aws_access_key_id"]; id != "" {
+      return credentials.NewStaticCredentials(id, p.Config[profile]["aws_secret_access_key"], p.Config[profile]["aws_session_token"]), nil
+    }
+    return nil, fmt.Errorf("The AWS profile %s has no aws_access_key_id and no role_arn.", profile)
+  }
+
+  role := &assumeRoleProvider{
+    RoleARN: roleARN,
+    SessionName: p.get(profile, "role_session_name"),
+    ExternalID: p.get(profile, "external_id"),
+    MFASerial: p.get(profile, "mfa_serial"),
+    Duration: time.Hour,
+    Region: config.Region,
+  }
+  if role.SessionName == "" {
+    role.SessionName = fmt.Sprintf("spur-%d", time.Now().Unix())
+  }
+  if seconds := p.get(profile, "duration_seconds"); seconds != "" {
+    n, err := strconv.Atoi(seconds)
+    if err != nil {
+      return nil, fmt.Errorf("The AWS profile %s has a bad duration_seconds: %s", profile, seconds)
+    }
+    role.Duration = time.Duration(n) * time.Second
+  }
+
+  switch source, credentialSource := p.get(profile, "source_profile"), p.get(profile, "credential_source"); {
+    case source != "":
+      var err error
+      if role.Source, err = p.credentials(source, config, seen); err != nil {
+        return nil, err
+      }
+    case credentialSource == "Environment":
+      role.Source = credentials.NewEnvCredentials()
+    default:
+      return nil, fmt.Errorf("The AWS profile %s has a role_arn but no source_profile or credential_source = Environment.", profile)
+  }
+  return credentials.NewCredentials(role), nil
+}
+
+// assumeRoleProvider gets temporary credentials for a role with STS,
+// using the Source credentials, and gets new ones when they run out.
+type assumeRoleProvider struct {
+  RoleARN     string
+  SessionName string
+  ExternalID  string
+  MFASerial   string
+  Duration    time.Duration
+  Region      string
+  Source      *credentials.Credentials
+  expiration  time.Time
+}
+
+func (r *assumeRoleProvider) Retrieve() (credentials.Value, error) {
+  svc := sts.New(&aws.Config{Credentials: r.Source, Region: r.Region})
+  input := &sts.AssumeRoleInput{
+    RoleARN: aws.String(r.RoleARN),
+    RoleSessionName: aws.String(r.SessionName),
+    DurationSeconds: aws.Long(int64(r.Duration / time.Second)),
+  }
+  if r.ExternalID != "" {
+    input.ExternalID = aws.String(r.ExternalID)
+  }
+  if r.MFASerial != "" {
+    fmt.Printf("MFA code for %s: ", r.MFASerial)
+    code, err := bufio.NewReader(os.Stdin).ReadString('\n')
+    if err != nil {
+      return credentials.Value{}, err
+    }
+    input.SerialNumber, input.TokenCode = aws.String(r.MFASerial), aws.String(strings.TrimSpace(code))
+  }
+
+  output, err := svc.AssumeRole(input)
+  if err != nil {
+    return credentials.Value{}, fmt.Errorf("Couldn't assume role %s: %s", r.RoleARN, err)
+  }
+  c := output.Credentials
+  if c.Expiration != nil {
+    // Renew a little early rather than have a call fail.
+    r.expiration = c.Expiration.Add(-time.Minute)
+  }
+  return credentials.Value{
+    AccessKeyID: stringValue(c.AccessKeyID),
+    SecretAccessKey: stringValue(c.SecretAccessKey),
+    SessionToken: stringValue(c.SessionToken),
+  }, nil
+}
+
+func (r *assumeRoleProvider) IsExpired() bool {
+  return time.Now().After(r.expiration)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "testing"
+  . "github.com/smartystreets/goconvey/convey"
+)
+
+const testAWSConfig = `
+[default]
+region = us-east-2
+
+[profile ops]
+region = eu-west-1
+s3 =
+  max_concurrent_requests = 20
+
+[profile deploy]
+role_arn = arn:aws:iam::123456789012:role/deploy
+source_profile = ops
+
+[profile audit]
+role_arn = arn:aws:iam::123456789012:role/audit
+source_profile = deploy
+region = ap-southeast-2
+
+[profile loop]
+role_arn = arn:aws:iam::123456789012:role/loop
+source_profile = loop
+
+[profile orphan]
+role_arn = arn:aws:iam::123456789012:role/orphan
+`
+
+const testAWSCredentials = `
+[default]
+aws_access_key_id = AKIDDEFAULT
+aws_secret_access_key = secret
+
+[ops]
+aws_access_key_id = AKIDOPS
+aws_secret_access_key = secret
+`
+
+func TestAWSConfig(t *testing.T) {
+
+  Convey("Given AWS config and credentials files", t, func() {
+    dir, _ := ioutil.TempDir("", "spur-aws")
+    defer os.RemoveAll(dir)
+    ioutil.WriteFile(filepath.Join(dir, "config"), []byte(testAWSConfig), 0600)
+    ioutil.WriteFile(filepath.Join(dir, "credentials"), []byte(testAWSCredentials), 0600)
+    env := map[string]string{
+      "AWS_CONFIG_FILE": filepath.Join(dir, "config"),
+      "AWS_SHARED_CREDENTIALS_FILE": filepath.Join(dir, "credentials"),
+      "AWS_PROFILE": "", "AWS_DEFAULT_PROFILE": "", "AWS_REGION": "", "AWS_DEFAULT_REGION": "",
+      "AWS_ENDPOINT_URL": "", "AWS_ENDPOINT_URL_KINESIS": "",
+    }
+    for name, value := range env {
+      old, set := os.LookupEnv(name)
+      os.Setenv(name, value)
+      if set {
+        defer os.Setenv(name, old)
+      } else {
+        defer os.Unsetenv(name)
+      }
+    }
+
+    Convey("The region should come from the flag, the environment, the profile, then its source", func() {
+      config, err := LoadAWSConfig(AWSOptions{})
+      So(err, ShouldBeNil)
+      So(config.Region, ShouldEqual, "us-east-2")
+
+      config, _ = LoadAWSConfig(AWSOptions{Profile: "ops"})
+      So(config.Region, ShouldEqual, "eu-west-1")
+      config, _ = LoadAWSConfig(AWSOptions{Profile: "deploy"})
+      So(config.Region, ShouldEqual, "eu-west-1")
+      config, _ = LoadAWSConfig(AWSOptions{Profile: "audit"})
+      So(config.Region, ShouldEqual, "ap-southeast-2")
+
+      os.Setenv("AWS_PROFILE", "ops")
+      config, _ = LoadAWSConfig(AWSOptions{})
+      So(config.Region, ShouldEqual, "eu-west-1")
+      os.Setenv("AWS_REGION", "sa-east-1")
+      config, _ = LoadAWSConfig(AWSOptions{})
+      So(config.Region, ShouldEqual, "sa-east-1")
+      config, _ = LoadAWSConfig(AWSOptions{Region: "us-west-2"})
+      So(config.Region, ShouldEqual, "us-west-2")
+    })
+
+    Convey("Windows line endings and trailing spaces shouldn't hide settings", func() {
+      config := "[profile crlf]\r\nregion = eu-north-1  \r\nrole_arn = arn:aws:iam::123456789012:role/crlf\t\r\n" +
+        "source_profile = ops \r\ns3 =\r\n  region = nested\r\n"
+      ioutil.WriteFile(filepath.Join(dir, "config"), []byte(config), 0600)
+      p, err := LoadAWSProfiles()
+      So(err, ShouldBeNil)
+      So(p.get("crlf", "region"), ShouldEqual, "eu-north-1")
+      So(p.get("crlf", "role_arn"), ShouldEqual, "arn:aws:iam::123456789012:role/crlf")
+      So(p.get("crlf", "source_profile"), ShouldEqual, "ops")
+    })
+
+    Convey("Profiles should resolve to credentials through chains of roles", func() {
+      config, err := LoadAWSConfig(AWSOptions{Profile: "audit"})
+      So(err, ShouldBeNil)
+      So(config.Credentials, ShouldNotBeNil)
+
+      _, err = LoadAWSConfig(AWSOptions{Profile: "loop"})
+      So(err, ShouldNotBeNil)
+      _, err = LoadAWSConfig(AWSOptions{Profile: "orphan"})
+      So(err, ShouldNotBeNil)
+      _, err = LoadAWSConfig(AWSOptions{Profile: "nobody"})
+      So(err, ShouldNotBeNil)
+    })
+
+    Convey("An http endpoint should turn off SSL", func() {
+      config, err := LoadAWSConfig(AWSOptions{EndpointURL: "http://localhost:4567"})
+      So(err, ShouldBeNil)
+      So(config.Endpoint, ShouldEqual, "http://localhost:4567")
+      So(config.DisableSSL, ShouldBeTrue)
+
+      os.Setenv("AWS_ENDPOINT_URL", "https://kinesis.example.com")
+      config, _ = LoadAWSConfig(AWSOptions{})
+      So(config.Endpoint, ShouldEqual, "https://kinesis.example.com")
+      So(config.DisableSSL, ShouldBeFalse)
+    })
+  })
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+  "encoding/json"
+  "fmt"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "sort"
+  "strings"
+  "sync"
+  "time"
+)
+
+// Checkpoints are kept as one JSON file each in this directory.
+var checkpointDir = filepath.Join(spurDir(), "checkpoints")
+
+// Save at most this often when marking records one at a time.
+const checkpointSaveInterval = time.Second
+
+// A Checkpoint records the last sequence number processed on each shard
+// of a stream so the next read can resume just after it.
+type Checkpoint struct {
+  Name    string            `json:"name"`
+  Stream  string            `json:"stream"`
+  Shards  map[string]string `json:"shards"`
+  Updated time.Time         `json:"updated"`
+
+  mu    sync.Mutex
+  dirty bool
+  saved time.Time
+}
+
+// LoadCheckpoint reads the named checkpoint, a new empty one if it doesn't exist yet.
+func LoadCheckpoint(name string) (c *Checkpoint, err error) {
+  if name == "" || strings.ContainsAny(name, `/\`) {
+    return nil, fmt.Errorf("Bad checkpoint name: \"%s\"", name)
+  }
+  c = &Checkpoint{Name: name, Shards: make(map[string]string)}
+  data, err := ioutil.ReadFile(checkpointPath(name))
+  if os.IsNotExist(err) {
+    return c, nil
+  }
+  if err == nil {
+    err = json.Unmarshal(data, c)
+  }
+  if c.Shards == nil {
+    c.Shards = make(map[string]string)
+  }
+  return c, err
+}
+
+// ListCheckpoints returns every saved checkpoint, by name.
+func ListCheckpoints() (checkpoints []*Checkpoint, err error) {
+  files, err := filepath.Glob(filepath.Join(checkpointDir, "*.json"))
+  if err != nil {
+    return nil, err
+  }
+  sort.Strings(files)
+  for _, file := range files {
+    c, err := LoadCheckpoint(strings.TrimSuffix(filepath.Base(file), ".json"))
+    if err != nil {
+      return checkpoints, err
+    }
+    checkpoints = append(checkpoints, c)
+  }
+  return checkpoints, nil
+}
+
+func checkpointPath(name string) string {
+  return filepath.Join(checkpointDir, name+".json")
+}
+
+// Use ties the checkpoint to a stream, a checkpoint can't be shared between streams.
+func (c *Checkpoint) Use(stream string) error {
+  c.mu.Lock()
+  defer c.mu.Unlock()
+  if c.Stream != "" && c.Stream != stream {
+    return fmt.Errorf("Checkpoint \"%s\" belongs to stream \"%s\", not \"%s\"", c.Name, c.Stream, stream)
+  }
+  c.Stream = stream
+  return nil
+}
+
+// SequenceNumber returns the last sequence number processed on the shard.
+func (c *Checkpoint) SequenceNumber(shardID string) (seq string, ok bool) {
+  c.mu.Lock()
+  defer c.mu.Unlock()
+  seq, ok = c.Shards[shardID]
+  return seq, ok
+}
+
+// Mark records sequenceNumber as processed on the shard.
+func (c *Checkpoint) Mark(shardID, sequenceNumber string) {
+  c.mu.Lock()
+  defer c.mu.Unlock()
+  c.Shards[shardID] = sequenceNumber
+  c.Updated = time.Now().UTC()
+  c.dirty = true
+}
+
+// Save writes the checkpoint if anything has been marked since the last save.
+func (c *Checkpoint) Save() error {
+  c.mu.Lock()
+  defer c.mu.Unlock()
+  if !c.dirty {
+    return nil
+  }
+  return c.write()
+}
+
+// SaveEvery saves the checkpoint if it hasn't been saved for interval.
+func (c *Checkpoint) SaveEvery(interval time.Duration) error {
+  c.mu.Lock()
+  defer c.mu.Unlock()
+  if !c.dirty || time.Since(c.saved) < interval {
+    return nil
+  }
+  return c.write()
+}
+
+// Write to a temporary file and rename so a crash never leaves half a checkpoint.
+func (c *Checkpoint) write() error {
+  data, err := json.MarshalIndent(c, "", "  ")
+  if err != nil {
+    return err
+  }
+  if err = os.MkdirAll(checkpointDir, 0755); err != nil {
+    return err
+  }
+  tmp := checkpointPath(c.Name) + ".tmp"
+  if err = ioutil.WriteFile(tmp, data, 0644); err != nil {
+    return err
+  }
+  if err = os.Rename(tmp, checkpointPath(c.Name)); err != nil {
+    return err
+  }
+  c.dirty = false
+  c.saved = time.Now()
+  return nil
+}
+
+// Reset forgets the position on a shard, or on every shard when shardID is empty.
+func (c *Checkpoint) Reset(shardID string) error {
+  c.mu.Lock()
+  defer c.mu.Unlock()
+  if shardID == "" {
+    c.Shards = make(map[string]string)
+    c.dirty = false
+    err := os.Remove(checkpointPath(c.Name))
+    if os.IsNotExist(err) {
+      err = nil
+    }
+    return err
+  }
+  if _, ok := c.Shards[shardID]; !ok {
+    return fmt.Errorf("Checkpoint \"%s\" has no position for shard \"%s\"", c.Name, shardID)
+  }
+  delete(c.Shards, shardID)
+  return c.write()
+}
+
+func (c *Checkpoint) String() string {
+  c.mu.Lock()
+  defer c.mu.Unlock()
+  return fmt.Sprintf("%s - (%s) %d shards, updated %s", c.Name, c.Stream, len(c.Shards), c.Updated.Format(time.RFC3339))
+}
+
+func (c *Checkpoint) Description() string {
+  c.mu.Lock()
+  defer c.mu.Unlock()
+  s := fmt.Sprintf("Name: \"%s\"\n", c.Name) +
+    fmt.Sprintf("Stream: \"%s\"\n", c.Stream) +
+    fmt.Sprintf("Updated: \"%s\"\n", c.Updated.Format(time.RFC3339))
+  shards := []string{}
+  for shard := range c.Shards {
+    shards = append(shards, shard)
+  }
+  sort.Strings(shards)
+  for _, shard := range shards {
+    s += fmt.Sprintf("%s: \"%s\"\n", shard, c.Shards[shard])
+  }
+  return s
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+  "context"
+  "errors"
+  "fmt"
+  "io/ioutil"
+  "os"
+  "testing"
+  "time"
+  "github.com/aws/aws-sdk-go/aws"
+  "github.com/aws/aws-sdk-go/service/kinesis"
+  . "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCheckpoint(t *testing.T) {
+
+  Convey("Given an empty checkpoint directory", t, func() {
+    dir, err := ioutil.TempDir("", "spur-checkpoints")
+    So(err, ShouldBeNil)
+    oldDir := checkpointDir
+    checkpointDir = dir
+    Reset(func() {
+      checkpointDir = oldDir
+      os.RemoveAll(dir)
+    })
+
+    Convey("A new checkpoint should have no positions", func() {
+      c, err := LoadCheckpoint("batch")
+      So(err, ShouldBeNil)
+      _, ok := c.SequenceNumber("shardId-000000000000")
+      So(ok, ShouldBeFalse)
+    })
+
+    Convey("A saved checkpoint should load with its positions", func() {
+      c, _ := LoadCheckpoint("batch")
+      So(c.Use("clicks"), ShouldBeNil)
+      c.Mark("shardId-000000000000", "4955")
+      So(c.Save(), ShouldBeNil)
+
+      loaded, err := LoadCheckpoint("batch")
+      So(err, ShouldBeNil)
+      seq, ok := loaded.SequenceNumber("shardId-000000000000")
+      So(ok, ShouldBeTrue)
+      So(seq, ShouldEqual, "4955")
+
+      Convey("It can't be used on another stream", func() {
+        So(loaded.Use("orders"), ShouldNotBeNil)
+      })
+
+      Convey("Resetting should remove it", func() {
+        So(loaded.Reset(""), ShouldBeNil)
+        list, err := ListCheckpoints()
+        So(err, ShouldBeNil)
+        So(list, ShouldBeEmpty)
+      })
+    })
+
+    Convey("Given a checkpoint part way through a shard that has since been split", func() {
+      svc, s := newFakeStream("clicks", 1)
+      var sequenceNumbers []string
+      for i := 0; i < 3; i++ {
+        out, _ := s.PutLogLine(fmt.Sprintf("before %d", i))
+        sequenceNumbers = append(sequenceNumbers, *out.SequenceNumber)
+      }
+      c, _ := LoadCheckpoint("batch")
+      c.Mark("shardId-000000000000", sequenceNumbers[0])
+      _, err := svc.SplitShard(&kinesis.SplitShardInput{StreamName: aws.String("clicks"),
+        ShardToSplit: aws.String("shardId-000000000000"), NewStartingHashKey: aws.String("1000")})
+      So(err, ShouldBeNil)
+      for i := 0; i < 4; i++ {
+        s.PutLogLine(fmt.Sprintf("after %d", i))
+      }
+      s.Checkpoint = c
+      s.SetStartingPosition("LATEST", "", time.Time{})
+
+      Convey("Reading every shard should pick up the rest of the parent, then the children", func() {
+        records, errs, err := s.ReadAllShards(context.Background(), false, time.Millisecond)
+        So(err, ShouldBeNil)
+        out := NewRecordWriter(ioutil.Discard, "clicks", "text", "")
+        So(writeShardRecords(out, records, errs, false, c), ShouldBeNil)
+        seq, _ := c.SequenceNumber("shardId-000000000000")
+        So(seq, ShouldEqual, sequenceNumbers[2])
+        _, ok1 := c.SequenceNumber("shardId-000000000001")
+        _, ok2 := c.SequenceNumber("shardId-000000000002")
+        So(ok1 || ok2, ShouldBeTrue)
+      })
+
+      Convey("Records that fail to write shouldn't be marked", func() {
+        records, errs, _ := s.ReadAllShards(context.Background(), false, time.Millisecond)
+        out := NewRecordWriter(failingWriter{}, "clicks", "text", "")
+        So(writeShardRecords(out, records, errs, false, c), ShouldNotBeNil)
+        seq, _ := c.SequenceNumber("shardId-000000000000")
+        So(seq, ShouldEqual, sequenceNumbers[0])
+      })
+    })
+  })
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) { return 0, errors.New("disk full") }
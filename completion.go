@@ -0,0 +1,144 @@
+package main
+
+import (
+  "gopkg.in/alecthomas/kingpin.v2"
+  "sort"
+  "strings"
+  "time"
+)
+
+// How long to keep the shard IDs of a stream for completion.
+const shardCompletionTTL = time.Minute
+
+// interCompleter completes interactive command lines: command names and
+// flags from the interApp model, and argument and flag values by name.
+type interCompleter struct {
+  group  *KinesisStreamGroup
+  model  *kingpin.ApplicationModel
+  shards map[string]shardCompletions
+}
+
+type shardCompletions struct {
+  ids     []string
+  fetched time.Time
+}
+
+func newInterCompleter(g *KinesisStreamGroup) *interCompleter {
+  return &interCompleter{group: g, model: interApp.Model(), shards: make(map[string]shardCompletions)}
+}
+
+// Complete is a readline.Completer, query is the word being completed and
+// line is the whole line so far.
+func (c *interCompleter) Complete(query, line string) (completions []string) {
+  words := strings.Fields(strings.TrimSuffix(line, query))
+
+  // Work out the command, and where in it we are.
+  var cmd *kingpin.CmdModel
+  commands, flags := c.model.Commands, c.model.Flags
+  var valueFor *kingpin.FlagModel
+  args := 0
+  for _, word := range words {
+    switch {
+      case valueFor != nil:
+        valueFor = nil
+      case strings.HasPrefix(word, "--"):
+        if flag := findFlag(flags, strings.TrimPrefix(word, "--")); flag != nil && !flag.IsBoolFlag() && !strings.Contains(word, "=") {
+          valueFor = flag
+        }
+      case len(commands) > 0:
+        for _, command := range commands {
+          if command.Name == word {
+            cmd, commands = command, command.Commands
+            flags = append(append([]*kingpin.FlagModel{}, c.model.Flags...), command.Flags...)
+          }
+        }
+      default:
+        args++
+    }
+  }
+
+  switch {
+    case valueFor != nil:
+      completions = c.values(cmd, valueFor.Name)
+    case strings.HasPrefix(query, "-"):
+      for _, flag := range flags {
+        if !flag.Hidden {
+          completions = append(completions, "--"+flag.Name)
+        }
+      }
+    case len(commands) > 0:
+      for _, command := range commands {
+        if !command.Hidden {
+          completions = append(completions, command.Name)
+        }
+      }
+    case cmd != nil && args < len(cmd.Args):
+      completions = c.values(cmd, cmd.Args[args].Name)
+  }
+  return withPrefix(completions, query)
+}
+
+func findFlag(flags []*kingpin.FlagModel, name string) *kingpin.FlagModel {
+  name = strings.SplitN(name, "=", 2)[0]
+  for _, flag := range flags {
+    if flag.Name == name {
+      return flag
+    }
+  }
+  return nil
+}
+
+// The values an argument or flag can take, by its name.
+func (c *interCompleter) values(cmd *kingpin.CmdModel, name string) []string {
+  switch name {
+    case "stream":
+      names := []string{}
+      for name := range c.group.Streams {
+        names = append(names, name)
+      }
+      if cmd != nil && cmd.Name == "use" {
+        names = append(names, spurConfig.Names()...)
+      }
+      sort.Strings(names)
+      return names
+    case "read type": return interReadTypes
+    case "type": return interListTypes
+    case "setting": return streamSettingNames
+    case "output": return outputFormats
+    case "data-encoding": return dataEncodings
+    case "partition-strategy": return partitionStrategies
+    case "shard", "shard-id", "adjacent-shard": return c.shardIDs()
+  }
+  return nil
+}
+
+// The shard IDs of the current stream, described at most once a minute.
+func (c *interCompleter) shardIDs() []string {
+  s := c.group.CurrentStream
+  if s == nil {
+    return nil
+  }
+  cached, ok := c.shards[s.Name]
+  if ok && time.Since(cached.fetched) < shardCompletionTTL {
+    return cached.ids
+  }
+  shards, err := s.GetShards()
+  if err != nil {
+    return nil
+  }
+  cached = shardCompletions{fetched: time.Now()}
+  for _, shard := range shards {
+    cached.ids = append(cached.ids, *shard.ShardID)
+  }
+  c.shards[s.Name] = cached
+  return cached.ids
+}
+
+func withPrefix(words []string, prefix string) (matches []string) {
+  for _, word := range words {
+    if strings.HasPrefix(word, prefix) {
+      matches = append(matches, word)
+    }
+  }
+  return matches
+}
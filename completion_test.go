@@ -0,0 +1,50 @@
+package main
+
+import (
+  "testing"
+  "github.com/aws/aws-sdk-go/aws"
+  "github.com/aws/aws-sdk-go/service/kinesis"
+  . "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCompletion(t *testing.T) {
+
+  Convey("Given the interactive completer on a fake Kinesis", t, func() {
+    svc := NewFakeKinesis()
+    for _, name := range []string{"clicks", "clocks", "orders"} {
+      svc.CreateStream(&kinesis.CreateStreamInput{StreamName: aws.String(name), ShardCount: aws.Long(2)})
+    }
+    g, _ := NewStreamGroupWithService(svc, "us-west-1")
+    g.CurrentStream = g.Streams["clicks"]
+    c := newInterCompleter(g)
+
+    Convey("Command names should complete", func() {
+      So(c.Complete("", ""), ShouldContain, "use")
+      So(c.Complete("de", "de"), ShouldResemble, []string{"delete"})
+    })
+
+    Convey("Stream names should complete for use, delete and show", func() {
+      So(c.Complete("cl", "use cl"), ShouldResemble, []string{"clicks", "clocks"})
+      So(c.Complete("", "delete "), ShouldResemble, []string{"clicks", "clocks", "orders"})
+      So(c.Complete("", "delete clicks "), ShouldBeEmpty)
+    })
+
+    Convey("Use should complete target names too", func() {
+      saved := spurConfig
+      defer func() { spurConfig = saved }()
+      spurConfig = &SpurConfig{Targets: map[string]*Target{"cl-prod": &Target{Stream: "clicks"}}}
+      So(c.Complete("cl", "use cl"), ShouldResemble, []string{"cl-prod", "clicks", "clocks"})
+    })
+
+    Convey("Read types and flag values should complete", func() {
+      So(c.Complete("a", "read a"), ShouldResemble, []string{"all", "at", "after"})
+      So(c.Complete("--o", "read --o"), ShouldResemble, []string{"--ordered", "--output"})
+      So(c.Complete("j", "read all --output j"), ShouldResemble, []string{"jsonl"})
+      So(c.Complete("", "read --all-shards "), ShouldContain, "tail")
+    })
+
+    Convey("Shard IDs of the current stream should complete", func() {
+      So(c.shardIDs(), ShouldResemble, []string{"shardId-000000000000", "shardId-000000000001"})
+    })
+  })
+}
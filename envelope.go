@@ -0,0 +1,136 @@
+package main
+
+import (
+  "bytes"
+  "encoding/json"
+  "fmt"
+  "os"
+  "regexp"
+  "strconv"
+  "strings"
+  "time"
+)
+
+// Formats for wrapping the lines written to a stream.
+var envelopeFormats = []string{"raw", "log", "json"}
+
+// Named time formats for the log and json envelopes, anything else is taken as a Go time layout.
+var timeFormats = map[string]string{
+  "RFC1123Z":    time.RFC1123Z,
+  "RFC1123":     time.RFC1123,
+  "RFC3339":     time.RFC3339,
+  "RFC3339Nano": time.RFC3339Nano,
+  "Kitchen":     time.Kitchen,
+  "Stamp":       time.StampMilli,
+}
+
+// Where a line being written came from, for the json envelope.
+type LineSource struct {
+  File string
+  Line int
+}
+
+// An Envelope wraps each line written to a stream.
+// See UnwrapRecord for the other direction.
+type Envelope interface {
+  Wrap(line string, source *LineSource) []byte
+  String() string
+}
+
+// NewEnvelope makes an envelope from its format, see envelopeFormats.
+// The time format is a name from timeFormats, unix, unixms or a Go time layout.
+func NewEnvelope(format, timeFormat string) (Envelope, error) {
+  switch format {
+    case "raw":
+      return rawEnvelope{}, nil
+    case "log", "":
+      return logEnvelope(timeFormat), nil
+    case "json":
+      hostname, _ := os.Hostname()
+      return &jsonEnvelope{timeFormat: timeFormat, hostname: hostname, pid: os.Getpid()}, nil
+  }
+  return nil, fmt.Errorf("Unknown envelope \"%s\", use one of: %s", format, strings.Join(envelopeFormats, ", "))
+}
+
+func formatTime(t time.Time, format string) string {
+  t = t.UTC()
+  switch format {
+    case "", "RFC1123Z": return t.Format(time.RFC1123Z)
+    case "unix": return strconv.FormatInt(t.Unix(), 10)
+    case "unixms": return strconv.FormatInt(t.UnixNano()/int64(time.Millisecond), 10)
+  }
+  if layout, ok := timeFormats[format]; ok {
+    return t.Format(layout)
+  }
+  return t.Format(format)
+}
+
+// The line as is.
+type rawEnvelope struct{}
+
+func (e rawEnvelope) Wrap(line string, source *LineSource) []byte { return []byte(line) }
+func (e rawEnvelope) String() string { return "raw" }
+
+// The line prefixed with the time: [ <time> ] line
+type logEnvelope string
+
+func (e logEnvelope) Wrap(line string, source *LineSource) []byte {
+  return []byte(fmt.Sprintf("[ %s ] %s", formatTime(time.Now(), string(e)), line))
+}
+func (e logEnvelope) String() string { return "log" }
+
+var logEnvelopePattern = regexp.MustCompile(`^\[ (.+?) \] `)
+
+// A JSON object with the line and where and when it was written.
+type jsonEnvelope struct {
+  timeFormat string
+  hostname   string
+  pid        int
+}
+
+// The json envelope as written to the stream.
+type JSONEnvelope struct {
+  Timestamp string `json:"timestamp"`
+  Hostname  string `json:"hostname"`
+  PID       int    `json:"pid"`
+  Source    string `json:"source,omitempty"`
+  Line      int    `json:"line,omitempty"`
+  Data      string `json:"data"`
+}
+
+func (e *jsonEnvelope) Wrap(line string, source *LineSource) []byte {
+  timeFormat := e.timeFormat
+  if timeFormat == "" || timeFormat == "RFC1123Z" {
+    timeFormat = "RFC3339Nano"
+  }
+  envelope := &JSONEnvelope{
+    Timestamp: formatTime(time.Now(), timeFormat),
+    Hostname: e.hostname,
+    PID: e.pid,
+    Data: line,
+  }
+  if source != nil {
+    envelope.Source, envelope.Line = source.File, source.Line
+  }
+  data, _ := json.Marshal(envelope)
+  return data
+}
+func (e *jsonEnvelope) String() string { return "json" }
+
+// UnwrapRecord recognizes data wrapped by the log or json envelopes and
+// returns the original line along with the envelope's format. Anything
+// else is taken to be raw.
+func UnwrapRecord(data []byte) (line string, format string) {
+  if bytes.HasPrefix(data, []byte("{")) {
+    var envelope JSONEnvelope
+    var fields map[string]json.RawMessage
+    if json.Unmarshal(data, &fields) == nil && fields["timestamp"] != nil && fields["data"] != nil &&
+      json.Unmarshal(data, &envelope) == nil {
+      return envelope.Data, "json"
+    }
+  }
+  if m := logEnvelopePattern.FindIndex(data); m != nil {
+    return string(data[m[1]:]), "log"
+  }
+  return string(data), "raw"
+}
@@ -0,0 +1,820 @@
+package main
+
+import (
+  "encoding/json"
+  "fmt"
+  "github.com/aws/aws-sdk-go/aws"
+  "github.com/aws/aws-sdk-go/aws/awserr"
+  "github.com/aws/aws-sdk-go/service/kinesis"
+  "io"
+  "math/big"
+  "sort"
+  "sync"
+  "time"
+)
+
+// FakeKinesis is an in-memory KinesisAPI for running spur without AWS.
+// It models streams and their status, shards with hash key ranges and
+// lineage, sequence numbers, shard iterators and MillisBehindLatest.
+// Status changes (CREATING to ACTIVE, UPDATING to ACTIVE after a split
+// or merge, DELETING to gone) take StateDelay. Save and Load keep the
+// streams on disk, Trim applies the retention period; see spur serve.
+type FakeKinesis struct {
+  // How long streams spend CREATING, UPDATING or DELETING.
+  StateDelay time.Duration
+  // Fail this many of the following PutRecord(s) records as throttled.
+  Throttle int
+  // Fail this many of the following DescribeStream calls with LimitExceededException.
+  DescribeThrottle int
+  // Streams listed per ListStreams call.
+  ListStreamsLimit int64
+  Region string
+
+  mu        sync.Mutex
+  streams   map[string]*fakeStream
+  iterators map[string]*fakeIterator
+  sequence  int64
+  iterator  int64
+  changes   int64
+}
+
+type fakeStream struct {
+  Name                 string
+  Status               string
+  NextStatus           string
+  StatusUntil          time.Time
+  Created              time.Time
+  RetentionPeriodHours int64
+  Shards               []*fakeShard
+  NextShard            int
+  Tags                 map[string]string
+  EncryptionType       string
+  KeyID                string
+}
+
+type fakeShard struct {
+  ID                    string
+  ParentShardID         string
+  AdjacentParentShardID string
+  StartingHashKey       string
+  EndingHashKey         string
+  StartingSequence      string
+  EndingSequence        string
+  Records               []*fakeRecord
+  // Records dropped off the front by Trim, iterator positions count them.
+  Trimmed               int
+}
+
+type fakeRecord struct {
+  Data           []byte
+  PartitionKey   string
+  SequenceNumber string
+  Arrival        time.Time
+}
+
+// Shard iterators expire this long after they're handed out, as in Kinesis.
+const fakeIteratorLifetime = 5 * time.Minute
+
+type fakeIterator struct {
+  stream   string
+  shard    string
+  position int // Of the next record, including those trimmed.
+  created  time.Time
+}
+
+func NewFakeKinesis() *FakeKinesis {
+  return &FakeKinesis{
+    ListStreamsLimit: 10,
+    Region: "us-west-1",
+    streams: make(map[string]*fakeStream),
+    iterators: make(map[string]*fakeIterator),
+  }
+}
+
+func fakeError(code, format string, args ...interface{}) error {
+  return awserr.New(code, fmt.Sprintf(format, args...), nil)
+}
+
+// Look up a stream, moving it on to its next status if it's time.
+// Deleted streams are dropped. Call with the lock held.
+func (f *FakeKinesis) stream(name *string) (*fakeStream, error) {
+  if name == nil || *name == "" {
+    return nil, fakeError("ValidationException", "StreamName is required")
+  }
+  s := f.streams[*name]
+  if s != nil && s.NextStatus != "" && !time.Now().Before(s.StatusUntil) {
+    s.Status, s.NextStatus = s.NextStatus, ""
+  }
+  if s != nil && s.Status == "DELETED" {
+    delete(f.streams, s.Name)
+    s = nil
+  }
+  if s == nil {
+    return nil, fakeError("ResourceNotFoundException", "Stream %s under account 000000000000 not found.", *name)
+  }
+  return s, nil
+}
+
+// Look up a stream that can be written to, read from or changed.
+func (f *FakeKinesis) activeStream(name *string, updating bool) (*fakeStream, error) {
+  s, err := f.stream(name)
+  if err == nil && s.Status != "ACTIVE" && !(updating && s.Status == "UPDATING") {
+    err = fakeError("ResourceInUseException", "Stream %s under account 000000000000 not ACTIVE, instead in state %s", s.Name, s.Status)
+  }
+  return s, err
+}
+
+func (f *FakeKinesis) transition(s *fakeStream, status, next string) {
+  s.Status, s.NextStatus, s.StatusUntil = status, next, time.Now().Add(f.StateDelay)
+}
+
+func (f *FakeKinesis) nextSequenceNumber() string {
+  f.sequence++
+  return fmt.Sprintf("49%054d", f.sequence)
+}
+
+func (s *fakeStream) newShard(start, end *big.Int, sequence string) *fakeShard {
+  shard := &fakeShard{
+    ID: fmt.Sprintf("shardId-%012d", s.NextShard),
+    StartingHashKey: start.String(),
+    EndingHashKey: end.String(),
+    StartingSequence: sequence,
+  }
+  s.NextShard++
+  s.Shards = append(s.Shards, shard)
+  return shard
+}
+
+func (s *fakeStream) shard(id *string) *fakeShard {
+  for _, shard := range s.Shards {
+    if id != nil && shard.ID == *id {
+      return shard
+    }
+  }
+  return nil
+}
+
+func (sh *fakeShard) open() bool {
+  return sh.EndingSequence == ""
+}
+
+func (sh *fakeShard) hashRange() (start, end *big.Int) {
+  start, _ = new(big.Int).SetString(sh.StartingHashKey, 10)
+  end, _ = new(big.Int).SetString(sh.EndingHashKey, 10)
+  return start, end
+}
+
+// The open shard whose hash key range holds the hash key.
+func (s *fakeStream) shardForHashKey(hashKey *big.Int) *fakeShard {
+  for _, shard := range s.Shards {
+    start, end := shard.hashRange()
+    if shard.open() && hashKey.Cmp(start) >= 0 && hashKey.Cmp(end) <= 0 {
+      return shard
+    }
+  }
+  return nil
+}
+
+func (f *FakeKinesis) CreateStream(input *kinesis.CreateStreamInput) (*kinesis.CreateStreamOutput, error) {
+  f.mu.Lock()
+  defer f.mu.Unlock()
+  if input.StreamName == nil || *input.StreamName == "" {
+    return nil, fakeError("ValidationException", "StreamName is required")
+  }
+  if input.ShardCount == nil || *input.ShardCount < 1 {
+    return nil, fakeError("ValidationException", "ShardCount must be at least 1")
+  }
+  if _, err := f.stream(input.StreamName); err == nil {
+    return nil, fakeError("ResourceInUseException", "Stream %s under account 000000000000 already exists.", *input.StreamName)
+  }
+
+  s := &fakeStream{Name: *input.StreamName, Created: time.Now(), RetentionPeriodHours: 24}
+  count := big.NewInt(*input.ShardCount)
+  width := new(big.Int).Div(new(big.Int).Add(maxHashKey, big.NewInt(1)), count)
+  for i := int64(0); i < *input.ShardCount; i++ {
+    start := new(big.Int).Mul(width, big.NewInt(i))
+    end := new(big.Int).Sub(new(big.Int).Add(start, width), big.NewInt(1))
+    if i == *input.ShardCount-1 {
+      end = maxHashKey
+    }
+    s.newShard(start, end, f.nextSequenceNumber())
+  }
+  f.transition(s, "CREATING", "ACTIVE")
+  f.streams[s.Name] = s
+  f.changes++
+  return &kinesis.CreateStreamOutput{}, nil
+}
+
+func (f *FakeKinesis) DeleteStream(input *kinesis.DeleteStreamInput) (*kinesis.DeleteStreamOutput, error) {
+  f.mu.Lock()
+  defer f.mu.Unlock()
+  s, err := f.stream(input.StreamName)
+  if err != nil {
+    return nil, err
+  }
+  f.transition(s, "DELETING", "DELETED")
+  f.changes++
+  return &kinesis.DeleteStreamOutput{}, nil
+}
+
+func (f *FakeKinesis) DescribeStream(input *kinesis.DescribeStreamInput) (*kinesis.DescribeStreamOutput, error) {
+  f.mu.Lock()
+  defer f.mu.Unlock()
+  if f.DescribeThrottle > 0 {
+    f.DescribeThrottle--
+    return nil, fakeError("LimitExceededException", "Rate exceeded for DescribeStream")
+  }
+  s, err := f.stream(input.StreamName)
+  if err != nil {
+    return nil, err
+  }
+
+  limit := 100
+  if input.Limit != nil && *input.Limit > 0 {
+    limit = int(*input.Limit)
+  }
+  var shards []*kinesis.Shard
+  started := input.ExclusiveStartShardID == nil
+  more := false
+  for _, shard := range s.Shards {
+    if !started {
+      started = shard.ID == *input.ExclusiveStartShardID
+      continue
+    }
+    if len(shards) == limit {
+      more = true
+      break
+    }
+    shards = append(shards, shard.description())
+  }
+
+  output := &kinesis.DescribeStreamOutput{StreamDescription: &kinesis.StreamDescription{
+    StreamName: aws.String(s.Name),
+    StreamARN: aws.String(fmt.Sprintf("arn:aws:kinesis:%s:000000000000:stream/%s", f.Region, s.Name)),
+    StreamStatus: aws.String(s.Status),
+    RetentionPeriodHours: aws.Long(s.RetentionPeriodHours),
+    EncryptionType: aws.String(s.encryptionType()),
+    Shards: shards,
+    HasMoreShards: aws.Boolean(more),
+  }}
+  if s.KeyID != "" {
+    output.StreamDescription.KeyID = aws.String(s.KeyID)
+  }
+  return output, nil
+}
+
+func (s *fakeStream) encryptionType() string {
+  if s.EncryptionType == "" {
+    return "NONE"
+  }
+  return s.EncryptionType
+}
+
+func (sh *fakeShard) description() *kinesis.Shard {
+  d := &kinesis.Shard{
+    ShardID: aws.String(sh.ID),
+    HashKeyRange: &kinesis.HashKeyRange{
+      StartingHashKey: aws.String(sh.StartingHashKey),
+      EndingHashKey: aws.String(sh.EndingHashKey),
+    },
+    SequenceNumberRange: &kinesis.SequenceNumberRange{StartingSequenceNumber: aws.String(sh.StartingSequence)},
+  }
+  if sh.ParentShardID != "" {
+    d.ParentShardID = aws.String(sh.ParentShardID)
+  }
+  if sh.AdjacentParentShardID != "" {
+    d.AdjacentParentShardID = aws.String(sh.AdjacentParentShardID)
+  }
+  if !sh.open() {
+    d.SequenceNumberRange.EndingSequenceNumber = aws.String(sh.EndingSequence)
+  }
+  return d
+}
+
+func (f *FakeKinesis) ListStreams(input *kinesis.ListStreamsInput) (*kinesis.ListStreamsOutput, error) {
+  f.mu.Lock()
+  defer f.mu.Unlock()
+  names := []string{}
+  for name := range f.streams {
+    if _, err := f.stream(aws.String(name)); err == nil {
+      names = append(names, name)
+    }
+  }
+  sort.Strings(names)
+
+  limit := f.ListStreamsLimit
+  if input.Limit != nil && *input.Limit > 0 {
+    limit = *input.Limit
+  }
+  output := &kinesis.ListStreamsOutput{HasMoreStreams: aws.Boolean(false)}
+  for _, name := range names {
+    if input.ExclusiveStartStreamName != nil && name <= *input.ExclusiveStartStreamName {
+      continue
+    }
+    if limit > 0 && int64(len(output.StreamNames)) == limit {
+      output.HasMoreStreams = aws.Boolean(true)
+      break
+    }
+    output.StreamNames = append(output.StreamNames, aws.String(name))
+  }
+  return output, nil
+}
+
+func (f *FakeKinesis) PutRecord(input *kinesis.PutRecordInput) (*kinesis.PutRecordOutput, error) {
+  f.mu.Lock()
+  defer f.mu.Unlock()
+  s, err := f.activeStream(input.StreamName, true)
+  if err != nil {
+    return nil, err
+  }
+  shard, seq, err := f.put(s, input.Data, input.PartitionKey, input.ExplicitHashKey)
+  if err != nil {
+    return nil, err
+  }
+  return &kinesis.PutRecordOutput{ShardID: aws.String(shard.ID), SequenceNumber: aws.String(seq)}, nil
+}
+
+func (f *FakeKinesis) PutRecords(input *kinesis.PutRecordsInput) (*kinesis.PutRecordsOutput, error) {
+  f.mu.Lock()
+  defer f.mu.Unlock()
+  s, err := f.activeStream(input.StreamName, true)
+  if err != nil {
+    return nil, err
+  }
+  if len(input.Records) > MaxBatchRecords {
+    return nil, fakeError("ValidationException", "Records must have at most %d entries", MaxBatchRecords)
+  }
+
+  output := &kinesis.PutRecordsOutput{FailedRecordCount: aws.Long(0)}
+  for _, entry := range input.Records {
+    result := &kinesis.PutRecordsResultEntry{}
+    shard, seq, err := f.put(s, entry.Data, entry.PartitionKey, entry.ExplicitHashKey)
+    if err != nil {
+      awsErr := err.(awserr.Error)
+      result.ErrorCode, result.ErrorMessage = aws.String(awsErr.Code()), aws.String(awsErr.Message())
+      *output.FailedRecordCount++
+    } else {
+      result.ShardID, result.SequenceNumber = aws.String(shard.ID), aws.String(seq)
+    }
+    output.Records = append(output.Records, result)
+  }
+  return output, nil
+}
+
+// Add a record to the shard its hash key falls in.
+func (f *FakeKinesis) put(s *fakeStream, data []byte, partitionKey, explicitHashKey *string) (*fakeShard, string, error) {
+  if partitionKey == nil || *partitionKey == "" || len(*partitionKey) > 256 {
+    return nil, "", fakeError("ValidationException", "PartitionKey must be 1 to 256 characters")
+  }
+  if len(data) > 1024*1024 {
+    return nil, "", fakeError("ValidationException", "Data must be at most 1 MB")
+  }
+  if f.Throttle > 0 {
+    f.Throttle--
+    return nil, "", fakeError("ProvisionedThroughputExceededException", "Rate exceeded for shard in stream %s", s.Name)
+  }
+
+  hashKey := partitionHashKey(*partitionKey)
+  if explicitHashKey != nil {
+    var ok bool
+    if hashKey, ok = new(big.Int).SetString(*explicitHashKey, 10); !ok || hashKey.Sign() < 0 || hashKey.Cmp(maxHashKey) > 0 {
+      return nil, "", fakeError("ValidationException", "ExplicitHashKey %s is not a valid hash key", *explicitHashKey)
+    }
+  }
+  shard := s.shardForHashKey(hashKey)
+  if shard == nil {
+    return nil, "", fakeError("InternalFailure", "No open shard for hash key %s", hashKey)
+  }
+
+  seq := f.nextSequenceNumber()
+  shard.Records = append(shard.Records, &fakeRecord{Data: data, PartitionKey: *partitionKey, SequenceNumber: seq, Arrival: time.Now()})
+  f.changes++
+  return shard, seq, nil
+}
+
+func (f *FakeKinesis) GetShardIterator(input *kinesis.GetShardIteratorInput) (*kinesis.GetShardIteratorOutput, error) {
+  f.mu.Lock()
+  defer f.mu.Unlock()
+  s, err := f.activeStream(input.StreamName, true)
+  if err != nil {
+    return nil, err
+  }
+  shard := s.shard(input.ShardID)
+  if shard == nil {
+    return nil, fakeError("ResourceNotFoundException", "Shard %s in stream %s under account 000000000000 does not exist", stringValue(input.ShardID), s.Name)
+  }
+
+  position := 0
+  iteratorType := stringValue(input.ShardIteratorType)
+  switch iteratorType {
+    case "TRIM_HORIZON":
+    case "LATEST":
+      position = len(shard.Records)
+    case "AT_SEQUENCE_NUMBER", "AFTER_SEQUENCE_NUMBER":
+      seq := stringValue(input.StartingSequenceNumber)
+      if !isDigits(seq) {
+        return nil, fakeError("InvalidArgumentException", "StartingSequenceNumber %s is not a valid sequence number", seq)
+      }
+      for position < len(shard.Records) && shard.Records[position].SequenceNumber < seq {
+        position++
+      }
+      if iteratorType == "AFTER_SEQUENCE_NUMBER" && position < len(shard.Records) && shard.Records[position].SequenceNumber == seq {
+        position++
+      }
+    case "AT_TIMESTAMP":
+      if input.Timestamp == nil {
+        return nil, fakeError("InvalidArgumentException", "Timestamp is required for AT_TIMESTAMP")
+      }
+      for position < len(shard.Records) && shard.Records[position].Arrival.Before(*input.Timestamp) {
+        position++
+      }
+    default:
+      return nil, fakeError("ValidationException", "Unknown ShardIteratorType %s", iteratorType)
+  }
+  return &kinesis.GetShardIteratorOutput{ShardIterator: aws.String(f.newIterator(s.Name, shard.ID, position+shard.Trimmed))}, nil
+}
+
+func (f *FakeKinesis) newIterator(stream, shard string, position int) string {
+  f.iterator++
+  name := fmt.Sprintf("fake-iterator-%d", f.iterator)
+  f.iterators[name] = &fakeIterator{stream: stream, shard: shard, position: position, created: time.Now()}
+  return name
+}
+
+func (f *FakeKinesis) GetRecords(input *kinesis.GetRecordsInput) (*kinesis.GetRecordsOutput, error) {
+  f.mu.Lock()
+  defer f.mu.Unlock()
+  it := f.iterators[stringValue(input.ShardIterator)]
+  delete(f.iterators, stringValue(input.ShardIterator))
+  if it == nil || time.Since(it.created) > fakeIteratorLifetime {
+    return nil, fakeError("ExpiredIteratorException", "Iterator %s has expired or is invalid", stringValue(input.ShardIterator))
+  }
+  s, err := f.stream(aws.String(it.stream))
+  if err != nil {
+    return nil, err
+  }
+  shard := s.shard(aws.String(it.shard))
+  if shard == nil {
+    return nil, fakeError("ResourceNotFoundException", "Shard %s in stream %s does not exist", it.shard, s.Name)
+  }
+
+  limit := 10000
+  if input.Limit != nil && *input.Limit > 0 {
+    limit = int(*input.Limit)
+  }
+  output := &kinesis.GetRecordsOutput{Records: []*kinesis.Record{}}
+  position := it.position - shard.Trimmed
+  if position < 0 {
+    position = 0
+  }
+  for ; position < len(shard.Records) && len(output.Records) < limit; position++ {
+    r := shard.Records[position]
+    arrival := r.Arrival
+    output.Records = append(output.Records, &kinesis.Record{
+      Data: r.Data,
+      PartitionKey: aws.String(r.PartitionKey),
+      SequenceNumber: aws.String(r.SequenceNumber),
+      ApproximateArrivalTimestamp: &arrival,
+    })
+  }
+
+  behind := int64(0)
+  if position < len(shard.Records) {
+    behind = int64(time.Since(shard.Records[position].Arrival) / time.Millisecond)
+  }
+  output.MillisBehindLatest = aws.Long(behind)
+
+  // A closed shard that has been read to the end has no more iterators.
+  if shard.open() || position < len(shard.Records) {
+    output.NextShardIterator = aws.String(f.newIterator(s.Name, shard.ID, position+shard.Trimmed))
+  }
+  return output, nil
+}
+
+func (f *FakeKinesis) SplitShard(input *kinesis.SplitShardInput) (*kinesis.SplitShardOutput, error) {
+  f.mu.Lock()
+  defer f.mu.Unlock()
+  s, err := f.activeStream(input.StreamName, false)
+  if err != nil {
+    return nil, err
+  }
+  parent := s.shard(input.ShardToSplit)
+  if parent == nil || !parent.open() {
+    return nil, fakeError("ResourceNotFoundException", "Could not find open shard %s in stream %s", stringValue(input.ShardToSplit), s.Name)
+  }
+  start, end := parent.hashRange()
+  at, ok := new(big.Int).SetString(stringValue(input.NewStartingHashKey), 10)
+  if !ok || at.Cmp(start) <= 0 || at.Cmp(end) > 0 {
+    return nil, fakeError("InvalidArgumentException", "NewStartingHashKey %s is not inside the hash key range of shard %s", stringValue(input.NewStartingHashKey), parent.ID)
+  }
+
+  parent.EndingSequence = f.nextSequenceNumber()
+  for _, child := range []*fakeShard{
+    s.newShard(start, new(big.Int).Sub(at, big.NewInt(1)), f.nextSequenceNumber()),
+    s.newShard(at, end, f.nextSequenceNumber()),
+  } {
+    child.ParentShardID = parent.ID
+  }
+  f.transition(s, "UPDATING", "ACTIVE")
+  f.changes++
+  return &kinesis.SplitShardOutput{}, nil
+}
+
+func (f *FakeKinesis) MergeShards(input *kinesis.MergeShardsInput) (*kinesis.MergeShardsOutput, error) {
+  f.mu.Lock()
+  defer f.mu.Unlock()
+  s, err := f.activeStream(input.StreamName, false)
+  if err != nil {
+    return nil, err
+  }
+  shard, adjacent := s.shard(input.ShardToMerge), s.shard(input.AdjacentShardToMerge)
+  if shard == nil || adjacent == nil || !shard.open() || !adjacent.open() {
+    return nil, fakeError("ResourceNotFoundException", "Could not find open shards %s and %s in stream %s",
+      stringValue(input.ShardToMerge), stringValue(input.AdjacentShardToMerge), s.Name)
+  }
+  start, end := shard.hashRange()
+  adjacentStart, adjacentEnd := adjacent.hashRange()
+  one := big.NewInt(1)
+  switch {
+    case new(big.Int).Add(end, one).Cmp(adjacentStart) == 0: end = adjacentEnd
+    case new(big.Int).Add(adjacentEnd, one).Cmp(start) == 0: start = adjacentStart
+    default:
+      return nil, fakeError("InvalidArgumentException", "Shards %s and %s are not adjacent", shard.ID, adjacent.ID)
+  }
+
+  shard.EndingSequence = f.nextSequenceNumber()
+  adjacent.EndingSequence = f.nextSequenceNumber()
+  child := s.newShard(start, end, f.nextSequenceNumber())
+  child.ParentShardID, child.AdjacentParentShardID = shard.ID, adjacent.ID
+  f.transition(s, "UPDATING", "ACTIVE")
+  f.changes++
+  return &kinesis.MergeShardsOutput{}, nil
+}
+
+// UpdateShardCount closes the open shards and opens count new ones that
+// divide the hash keys evenly, each the child of the shards it overlaps.
+func (f *FakeKinesis) UpdateShardCount(input *kinesis.UpdateShardCountInput) (*kinesis.UpdateShardCountOutput, error) {
+  f.mu.Lock()
+  defer f.mu.Unlock()
+  s, err := f.activeStream(input.StreamName, false)
+  if err != nil {
+    return nil, err
+  }
+  if stringValue(input.ScalingType) != "UNIFORM_SCALING" {
+    return nil, fakeError("ValidationException", "ScalingType must be UNIFORM_SCALING")
+  }
+  open := []*fakeShard{}
+  for _, shard := range s.Shards {
+    if shard.open() {
+      open = append(open, shard)
+    }
+  }
+  current := int64(len(open))
+  if input.TargetShardCount == nil || *input.TargetShardCount < 1 || *input.TargetShardCount > 2*current || 2**input.TargetShardCount < current {
+    return nil, fakeError("InvalidArgumentException", "TargetShardCount must be between half and double the %d open shards", current)
+  }
+  target := *input.TargetShardCount
+
+  // Find each new range's parents while only the old shards are open.
+  type newRange struct {
+    start, end       *big.Int
+    parent, adjacent *fakeShard
+  }
+  ranges := []newRange{}
+  width := new(big.Int).Div(new(big.Int).Add(maxHashKey, big.NewInt(1)), big.NewInt(target))
+  for i := int64(0); i < target; i++ {
+    start := new(big.Int).Mul(width, big.NewInt(i))
+    end := new(big.Int).Sub(new(big.Int).Add(start, width), big.NewInt(1))
+    if i == target-1 {
+      end = maxHashKey
+    }
+    ranges = append(ranges, newRange{start, end, s.shardForHashKey(start), s.shardForHashKey(end)})
+  }
+  for _, shard := range open {
+    shard.EndingSequence = f.nextSequenceNumber()
+  }
+  for _, r := range ranges {
+    child := s.newShard(r.start, r.end, f.nextSequenceNumber())
+    child.ParentShardID = r.parent.ID
+    if r.adjacent != r.parent {
+      child.AdjacentParentShardID = r.adjacent.ID
+    }
+  }
+  f.transition(s, "UPDATING", "ACTIVE")
+  f.changes++
+  return &kinesis.UpdateShardCountOutput{StreamName: input.StreamName, CurrentShardCount: aws.Long(current), TargetShardCount: aws.Long(target)}, nil
+}
+
+// Limits on retention, in hours, and tags.
+const (
+  fakeMinRetentionHours = 24
+  fakeMaxRetentionHours = 8760
+  fakeMaxTags           = 50
+)
+
+func (f *FakeKinesis) IncreaseStreamRetentionPeriod(input *kinesis.IncreaseStreamRetentionPeriodInput) (*kinesis.IncreaseStreamRetentionPeriodOutput, error) {
+  f.mu.Lock()
+  defer f.mu.Unlock()
+  s, err := f.activeStream(input.StreamName, false)
+  if err != nil {
+    return nil, err
+  }
+  hours := int64(0)
+  if input.RetentionPeriodHours != nil {
+    hours = *input.RetentionPeriodHours
+  }
+  if hours < s.RetentionPeriodHours || hours > fakeMaxRetentionHours {
+    return nil, fakeError("InvalidArgumentException", "RetentionPeriodHours %d must be from the current %d to %d", hours, s.RetentionPeriodHours, fakeMaxRetentionHours)
+  }
+  s.RetentionPeriodHours = hours
+  f.transition(s, "UPDATING", "ACTIVE")
+  f.changes++
+  return &kinesis.IncreaseStreamRetentionPeriodOutput{}, nil
+}
+
+func (f *FakeKinesis) DecreaseStreamRetentionPeriod(input *kinesis.DecreaseStreamRetentionPeriodInput) (*kinesis.DecreaseStreamRetentionPeriodOutput, error) {
+  f.mu.Lock()
+  defer f.mu.Unlock()
+  s, err := f.activeStream(input.StreamName, false)
+  if err != nil {
+    return nil, err
+  }
+  hours := int64(0)
+  if input.RetentionPeriodHours != nil {
+    hours = *input.RetentionPeriodHours
+  }
+  if hours > s.RetentionPeriodHours || hours < fakeMinRetentionHours {
+    return nil, fakeError("InvalidArgumentException", "RetentionPeriodHours %d must be from %d to the current %d", hours, fakeMinRetentionHours, s.RetentionPeriodHours)
+  }
+  s.RetentionPeriodHours = hours
+  f.transition(s, "UPDATING", "ACTIVE")
+  f.changes++
+  return &kinesis.DecreaseStreamRetentionPeriodOutput{}, nil
+}
+
+func (f *FakeKinesis) AddTagsToStream(input *kinesis.AddTagsToStreamInput) (*kinesis.AddTagsToStreamOutput, error) {
+  f.mu.Lock()
+  defer f.mu.Unlock()
+  s, err := f.activeStream(input.StreamName, true)
+  if err != nil {
+    return nil, err
+  }
+  if s.Tags == nil {
+    s.Tags = make(map[string]string)
+  }
+  added := 0
+  for key := range input.Tags {
+    if _, ok := s.Tags[key]; !ok {
+      added++
+    }
+  }
+  if len(s.Tags)+added > fakeMaxTags {
+    return nil, fakeError("LimitExceededException", "A stream can have at most %d tags", fakeMaxTags)
+  }
+  for key, value := range input.Tags {
+    s.Tags[key] = stringValue(value)
+  }
+  f.changes++
+  return &kinesis.AddTagsToStreamOutput{}, nil
+}
+
+func (f *FakeKinesis) RemoveTagsFromStream(input *kinesis.RemoveTagsFromStreamInput) (*kinesis.RemoveTagsFromStreamOutput, error) {
+  f.mu.Lock()
+  defer f.mu.Unlock()
+  s, err := f.activeStream(input.StreamName, true)
+  if err != nil {
+    return nil, err
+  }
+  for _, key := range input.TagKeys {
+    delete(s.Tags, stringValue(key))
+  }
+  f.changes++
+  return &kinesis.RemoveTagsFromStreamOutput{}, nil
+}
+
+// ListTagsForStream lists tags in key order, 10 at a time unless a Limit is given.
+func (f *FakeKinesis) ListTagsForStream(input *kinesis.ListTagsForStreamInput) (*kinesis.ListTagsForStreamOutput, error) {
+  f.mu.Lock()
+  defer f.mu.Unlock()
+  s, err := f.stream(input.StreamName)
+  if err != nil {
+    return nil, err
+  }
+  limit := 10
+  if input.Limit != nil && *input.Limit > 0 {
+    limit = int(*input.Limit)
+  }
+  keys := []string{}
+  for key := range s.Tags {
+    if input.ExclusiveStartTagKey == nil || key > *input.ExclusiveStartTagKey {
+      keys = append(keys, key)
+    }
+  }
+  sort.Strings(keys)
+  output := &kinesis.ListTagsForStreamOutput{HasMoreTags: aws.Boolean(len(keys) > limit)}
+  for i, key := range keys {
+    if i == limit {
+      break
+    }
+    output.Tags = append(output.Tags, &kinesis.Tag{Key: aws.String(key), Value: aws.String(s.Tags[key])})
+  }
+  return output, nil
+}
+
+func (f *FakeKinesis) StartStreamEncryption(input *kinesis.StartStreamEncryptionInput) (*kinesis.StartStreamEncryptionOutput, error) {
+  f.mu.Lock()
+  defer f.mu.Unlock()
+  s, err := f.activeStream(input.StreamName, false)
+  if err != nil {
+    return nil, err
+  }
+  if stringValue(input.EncryptionType) != "KMS" || stringValue(input.KeyID) == "" {
+    return nil, fakeError("ValidationException", "EncryptionType must be KMS, with a KeyId")
+  }
+  s.EncryptionType, s.KeyID = "KMS", *input.KeyID
+  f.transition(s, "UPDATING", "ACTIVE")
+  f.changes++
+  return &kinesis.StartStreamEncryptionOutput{}, nil
+}
+
+func (f *FakeKinesis) StopStreamEncryption(input *kinesis.StopStreamEncryptionInput) (*kinesis.StopStreamEncryptionOutput, error) {
+  f.mu.Lock()
+  defer f.mu.Unlock()
+  s, err := f.activeStream(input.StreamName, false)
+  if err != nil {
+    return nil, err
+  }
+  if stringValue(input.EncryptionType) != "KMS" || stringValue(input.KeyID) != s.KeyID || s.KeyID == "" {
+    return nil, fakeError("InvalidArgumentException", "Stream %s isn't encrypted with KMS key %s", s.Name, stringValue(input.KeyID))
+  }
+  s.EncryptionType, s.KeyID = "NONE", ""
+  f.transition(s, "UPDATING", "ACTIVE")
+  f.changes++
+  return &kinesis.StopStreamEncryptionOutput{}, nil
+}
+
+// Trim drops records older than their stream's retention period, and
+// expired shard iterators. Returns the number of records dropped.
+func (f *FakeKinesis) Trim(now time.Time) (trimmed int) {
+  f.mu.Lock()
+  defer f.mu.Unlock()
+  for _, s := range f.streams {
+    oldest := now.Add(-time.Duration(s.RetentionPeriodHours) * time.Hour)
+    for _, shard := range s.Shards {
+      n := 0
+      for n < len(shard.Records) && shard.Records[n].Arrival.Before(oldest) {
+        n++
+      }
+      shard.Records = shard.Records[n:]
+      shard.Trimmed += n
+      trimmed += n
+    }
+  }
+  // Iterators expire by the clock, as GetRecords sees them.
+  for name, it := range f.iterators {
+    if time.Since(it.created) > fakeIteratorLifetime {
+      delete(f.iterators, name)
+    }
+  }
+  f.changes += int64(trimmed)
+  return trimmed
+}
+
+// Changes counts the changes made to streams and records, so
+// callers can tell whether there is anything new to Save.
+func (f *FakeKinesis) Changes() int64 {
+  f.mu.Lock()
+  defer f.mu.Unlock()
+  return f.changes
+}
+
+// The fake's state as saved by Save.
+type fakeSnapshot struct {
+  Sequence int64
+  Streams  map[string]*fakeStream
+}
+
+// Save writes every stream and its records as JSON, for Load.
+func (f *FakeKinesis) Save(w io.Writer) error {
+  f.mu.Lock()
+  defer f.mu.Unlock()
+  return json.NewEncoder(w).Encode(&fakeSnapshot{Sequence: f.sequence, Streams: f.streams})
+}
+
+// Load replaces the streams with those written by Save.
+// Shard iterators from before the load are no longer valid.
+func (f *FakeKinesis) Load(r io.Reader) error {
+  snapshot := &fakeSnapshot{}
+  if err := json.NewDecoder(r).Decode(snapshot); err != nil {
+    return err
+  }
+  f.mu.Lock()
+  defer f.mu.Unlock()
+  f.sequence = snapshot.Sequence
+  f.streams = snapshot.Streams
+  if f.streams == nil {
+    f.streams = make(map[string]*fakeStream)
+  }
+  f.iterators = make(map[string]*fakeIterator)
+  return nil
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+  "context"
+  "fmt"
+  "io/ioutil"
+  "os"
+  "strings"
+  "testing"
+  "time"
+  "github.com/aws/aws-sdk-go/aws"
+  "github.com/aws/aws-sdk-go/aws/awserr"
+  "github.com/aws/aws-sdk-go/service/kinesis"
+  . "github.com/smartystreets/goconvey/convey"
+)
+
+// A fake Kinesis with an active stream of the given number of shards.
+func newFakeStream(name string, shards int64) (*FakeKinesis, *KinesisStream) {
+  svc := NewFakeKinesis()
+  svc.CreateStream(&kinesis.CreateStreamInput{StreamName: aws.String(name), ShardCount: aws.Long(shards)})
+  s := NewStreamWithService(svc, name, "PARTITION", "TRIM_HORIZON", "shardId-000000000000")
+  s.Envelope = rawEnvelope{}
+  return svc, s
+}
+
+// Run f and return what it printed to stdout.
+func captureStdout(f func()) string {
+  r, w, _ := os.Pipe()
+  stdout := os.Stdout
+  os.Stdout = w
+  defer func() { os.Stdout = stdout }()
+  done := make(chan string)
+  go func() {
+    out, _ := ioutil.ReadAll(r)
+    done <- string(out)
+  }()
+  f()
+  w.Close()
+  return <-done
+}
+
+func awsCode(err error) string {
+  if awsErr, ok := err.(awserr.Error); ok {
+    return awsErr.Code()
+  }
+  return ""
+}
+
+func TestFakeKinesis(t *testing.T) {
+
+  Convey("Given a fake stream that takes a while to create", t, func() {
+    svc := NewFakeKinesis()
+    svc.StateDelay = 50 * time.Millisecond
+    _, err := svc.CreateStream(&kinesis.CreateStreamInput{StreamName: aws.String("clicks"), ShardCount: aws.Long(4)})
+    So(err, ShouldBeNil)
+    s := NewStreamWithService(svc, "clicks", "PARTITION", "TRIM_HORIZON", "")
+
+    Convey("It should be CREATING and refuse writes, then become ACTIVE", func() {
+      d, err := s.GetAWSDescription()
+      So(err, ShouldBeNil)
+      So(*d.StreamStatus, ShouldEqual, "CREATING")
+      _, err = s.PutLogLine("too soon")
+      So(awsCode(err), ShouldEqual, "ResourceInUseException")
+
+      time.Sleep(60 * time.Millisecond)
+      d, _ = s.GetAWSDescription()
+      So(*d.StreamStatus, ShouldEqual, "ACTIVE")
+    })
+
+    Convey("Its shards should cover the hash key range between them", func() {
+      shards, err := s.GetShards()
+      So(err, ShouldBeNil)
+      So(len(shards), ShouldEqual, 4)
+      So(*shards[0].HashKeyRange.StartingHashKey, ShouldEqual, "0")
+      So(*shards[3].HashKeyRange.EndingHashKey, ShouldEqual, maxHashKey.String())
+    })
+
+    Convey("A deleted stream should be gone after DELETING", func() {
+      time.Sleep(60 * time.Millisecond)
+      svc.DeleteStream(&kinesis.DeleteStreamInput{StreamName: aws.String("clicks")})
+      d, _ := s.GetAWSDescription()
+      So(*d.StreamStatus, ShouldEqual, "DELETING")
+      time.Sleep(60 * time.Millisecond)
+      _, err := s.GetAWSDescription()
+      So(awsCode(err), ShouldEqual, "ResourceNotFoundException")
+    })
+  })
+
+  Convey("Given an active fake stream with records", t, func() {
+    svc, s := newFakeStream("clicks", 1)
+    var sequenceNumbers []string
+    for i := 0; i < 5; i++ {
+      out, err := s.PutLogLine(fmt.Sprintf("line %d", i))
+      So(err, ShouldBeNil)
+      sequenceNumbers = append(sequenceNumbers, *out.SequenceNumber)
+    }
+
+    Convey("Sequence numbers should increase", func() {
+      for i := 1; i < len(sequenceNumbers); i++ {
+        So(sequenceNumbers[i] > sequenceNumbers[i-1], ShouldBeTrue)
+      }
+    })
+
+    Convey("Reading from AFTER_SEQUENCE_NUMBER should skip that record", func() {
+      s.SetStartingPosition("AFTER_SEQUENCE_NUMBER", sequenceNumbers[2], time.Time{})
+      output, err := s.GetRecords()
+      So(err, ShouldBeNil)
+      So(len(output.Records), ShouldEqual, 2)
+      So(string(output.Records[0].Data), ShouldEqual, "line 3")
+      So(*output.MillisBehindLatest, ShouldEqual, 0)
+    })
+
+    Convey("Reading a page at a time should say how far behind it is", func() {
+      it, _ := svc.GetShardIterator(&kinesis.GetShardIteratorInput{StreamName: aws.String("clicks"),
+        ShardID: aws.String("shardId-000000000000"), ShardIteratorType: aws.String("TRIM_HORIZON")})
+      time.Sleep(5 * time.Millisecond)
+      output, err := svc.GetRecords(&kinesis.GetRecordsInput{ShardIterator: it.ShardIterator, Limit: aws.Long(2)})
+      So(err, ShouldBeNil)
+      So(len(output.Records), ShouldEqual, 2)
+      So(*output.MillisBehindLatest, ShouldBeGreaterThan, 0)
+
+      Convey("And iterators should only be good once", func() {
+        _, err := svc.GetRecords(&kinesis.GetRecordsInput{ShardIterator: it.ShardIterator})
+        So(awsCode(err), ShouldEqual, "ExpiredIteratorException")
+      })
+    })
+
+    Convey("Iterators should expire after five minutes, and be trimmed then", func() {
+      it, _ := svc.GetShardIterator(&kinesis.GetShardIteratorInput{StreamName: aws.String("clicks"),
+        ShardID: aws.String("shardId-000000000000"), ShardIteratorType: aws.String("TRIM_HORIZON")})
+      svc.GetShardIterator(&kinesis.GetShardIteratorInput{StreamName: aws.String("clicks"),
+        ShardID: aws.String("shardId-000000000000"), ShardIteratorType: aws.String("LATEST")})
+      for _, it := range svc.iterators {
+        it.created = time.Now().Add(-6 * time.Minute)
+      }
+      _, err := svc.GetRecords(&kinesis.GetRecordsInput{ShardIterator: it.ShardIterator})
+      So(awsCode(err), ShouldEqual, "ExpiredIteratorException")
+      So(svc.iterators, ShouldHaveLength, 1)
+      svc.Trim(time.Now())
+      So(svc.iterators, ShouldBeEmpty)
+    })
+  })
+}
+
+func TestCommandsWithFake(t *testing.T) {
+
+  Convey("Given a stream group on a fake Kinesis", t, func() {
+    svc := NewFakeKinesis()
+    svc.ListStreamsLimit = 2
+    for _, name := range []string{"a", "b", "c"} {
+      svc.CreateStream(&kinesis.CreateStreamInput{StreamName: aws.String(name), ShardCount: aws.Long(1)})
+    }
+    g, err := NewStreamGroupWithService(svc, "us-west-1")
+    So(err, ShouldBeNil)
+    g.CurrentStream = g.Streams["a"]
+
+    Convey("Every stream should be listed, across pages", func() {
+      So(len(g.Streams), ShouldEqual, 3)
+    })
+
+    Convey("Streams can be created, written to and read in interactive mode", func() {
+      out := captureStdout(func() {
+        So(DoICommand("create fresh", g), ShouldBeNil)
+        So(DoICommand("use fresh", g), ShouldBeNil)
+        g.CurrentStream.Partition = "PARTITION"
+        So(DoICommand("iterate 3 hello", g), ShouldBeNil)
+        So(DoICommand("read all --all-shards", g), ShouldBeNil)
+      })
+      So(strings.Count(out, "hello: "), ShouldEqual, 3)
+    })
+
+    Convey("Streams should get the defaults, and keep their own settings", func() {
+      So(g.SetDefaults(StreamSettings{Partition: "PARTITION", ShardID: "shardId-000000000000", IteratorType: "LATEST", Output: "text"}), ShouldBeNil)
+      So(g.Streams["b"].ShardID, ShouldEqual, "shardId-000000000000")
+      out := captureStdout(func() {
+        So(DoICommand("use b", g), ShouldBeNil)
+        So(DoICommand("set output jsonl", g), ShouldBeNil)
+        So(DoICommand("set iterator TRIM_HORIZON", g), ShouldBeNil)
+        So(DoICommand("set partition-strategy hash", g), ShouldBeNil)
+        So(DoICommand("iterate 2 hello", g), ShouldBeNil)
+        So(DoICommand("read", g), ShouldBeNil)
+        So(DoICommand("show", g), ShouldBeNil)
+      })
+      So(out, ShouldContainSubstring, `"stream":"b"`)
+      So(out, ShouldContainSubstring, `Output: "jsonl"`)
+      So(out, ShouldContainSubstring, `PartitionStrategy: "hash"`)
+      So(g.Streams["a"].Output, ShouldEqual, "text")
+
+      captureStdout(func() {
+        So(DoICommand("unset output", g), ShouldBeNil)
+        So(DoICommand("set iterator NOWHERE", g), ShouldNotBeNil)
+      })
+      So(g.Streams["b"].Output, ShouldEqual, "text")
+      So(g.Streams["b"].ShardIteratorType, ShouldEqual, "TRIM_HORIZON")
+    })
+
+    Convey("Deleting a stream should take it out of the group", func() {
+      So(DoICommand("delete b --yes", g), ShouldBeNil)
+      _, err := g.GetStream("b")
+      So(err, ShouldNotBeNil)
+    })
+  })
+
+  Convey("Given a stream with records in both shards", t, func() {
+    _, s := newFakeStream("clicks", 2)
+    s.Partitioner, _ = NewPartitionStrategy("round-robin", "PARTITION", s)
+    p := s.NewProducer(0)
+    for i := 0; i < 10; i++ {
+      So(p.PutLogLine(fmt.Sprintf("line %d", i)), ShouldBeNil)
+    }
+    So(p.Close(), ShouldBeNil)
+
+    Convey("Round robin should have spread them evenly", func() {
+      for _, id := range []string{"shardId-000000000000", "shardId-000000000001"} {
+        output, err := s.ForShard(id).GetRecords()
+        So(err, ShouldBeNil)
+        So(len(output.Records), ShouldEqual, 5)
+      }
+    })
+
+    Convey("Reading all shards should get every record", func() {
+      records, errs, err := s.ReadAllShards(context.Background(), false, time.Millisecond)
+      So(err, ShouldBeNil)
+      count := 0
+      for range records {
+        count++
+      }
+      So(count, ShouldEqual, 10)
+      for err := range errs {
+        So(err, ShouldBeNil)
+      }
+    })
+
+    Convey("Reading all shards from a sequence number should be refused", func() {
+      s.SetStartingPosition("AFTER_SEQUENCE_NUMBER", "49590338271490256608559692538361571095921575989136588898", time.Time{})
+      _, _, err := s.ReadAllShards(context.Background(), false, time.Millisecond)
+      So(err, ShouldNotBeNil)
+    })
+  })
+
+  Convey("Given a producer on a stream that throttles", t, func() {
+    svc, s := newFakeStream("clicks", 1)
+    svc.Throttle = 3
+    p := s.NewProducer(0)
+    p.Backoff, p.MaxBackoff = time.Millisecond, time.Millisecond
+    for i := 0; i < 10; i++ {
+      p.PutLogLine(fmt.Sprintf("line %d", i))
+    }
+
+    Convey("The throttled records should be retried", func() {
+      So(p.Close(), ShouldBeNil)
+      sent, failed := p.Stats()
+      So(sent, ShouldEqual, 10)
+      So(failed, ShouldEqual, 0)
+    })
+  })
+}
@@ -3,10 +3,40 @@ package main
 import (
   "fmt"
   "io"
+  "os"
+  "path/filepath"
+  "strings"
+  "time"
   "github.com/bobappleyard/readline"
   "github.com/aws/aws-sdk-go/aws/awserr"
 )
 
+// Spur keeps its local state (checkpoints etc.) under ~/.spur.
+func spurDir() string {
+  home, err := os.UserHomeDir()
+  if err != nil {
+    home = "."
+  }
+  return filepath.Join(home, ".spur")
+}
+
+// The string pointed to, or "" for nil.
+func stringValue(s *string) string {
+  if s == nil {
+    return ""
+  }
+  return *s
+}
+
+func isDigits(s string) bool {
+  for _, c := range s {
+    if c < '0' || c > '9' {
+      return false
+    }
+  }
+  return s != ""
+}
+
 func fmtMilliseconds(msec int64) string {
   hours := (msec / (1000 * 60 * 60)) % 24
   minutes := (msec / (1000 * 60)) % 60
@@ -23,17 +53,65 @@ func fmtMilliseconds(msec int64) string {
   }
 }
 
+// Layouts accepted by parseTimestamp, most specific first.
+// Times without a zone are taken as UTC.
+var timestampLayouts = []string{
+  time.RFC3339Nano,
+  time.RFC3339,
+  "2006-01-02T15:04Z07:00",
+  "2006-01-02T15:04:05",
+  "2006-01-02T15:04",
+  "2006-01-02 15:04:05",
+  "2006-01-02 15:04",
+  "2006-01-02",
+}
+
+// parseTimestamp reads a point in time for AT_TIMESTAMP reads.
+// It takes an absolute time (e.g. 2026-10-18T10:00Z) or a duration
+// (e.g. 90m) meaning that long before now.
+func parseTimestamp(value string) (t time.Time, err error) {
+  value = strings.TrimSpace(value)
+  if d, e := time.ParseDuration(value); e == nil {
+    return time.Now().Add(-d), nil
+  }
+  for _, layout := range timestampLayouts {
+    if t, err = time.Parse(layout, value); err == nil {
+      return t, nil
+    }
+  }
+  return t, fmt.Errorf("Couldn't understand \"%s\" as a time, try something like 2006-01-02T15:04Z or 30m", value)
+}
+
+func fmtBytes(bytes float64) string {
+  switch {
+    case bytes >= 1024*1024*1024: return fmt.Sprintf("%.1f GB", bytes/(1024*1024*1024))
+    case bytes >= 1024*1024: return fmt.Sprintf("%.1f MB", bytes/(1024*1024))
+    case bytes >= 1024: return fmt.Sprintf("%.1f KB", bytes/1024)
+  }
+  return fmt.Sprintf("%.0f B", bytes)
+}
+
 func printAWSError(err error) {
-  awsErr, _ := err.(awserr.Error)
-  fmt.Println("awsError:")
-  fmt.Println(awsErr.Code(), awsErr.Message(), awsErr.OrigErr())
+  writeAWSError(os.Stdout, err)
+}
+
+func writeAWSError(w io.Writer, err error) {
+  awsErr, ok := err.(awserr.Error)
+  if !ok {
+    fmt.Fprintln(w, "Error:", err)
+    return
+  }
+  fmt.Fprintln(w, "awsError:")
+  fmt.Fprintln(w, awsErr.Code(), awsErr.Message(), awsErr.OrigErr())
   if reqErr, ok := err.(awserr.RequestFailure); ok {
-    fmt.Println("reqErr:")
-    fmt.Println(reqErr.Code(), reqErr.Message(), reqErr.StatusCode(), reqErr.RequestID())
+    fmt.Fprintln(w, "reqErr:")
+    fmt.Fprintln(w, reqErr.Code(), reqErr.Message(), reqErr.StatusCode(), reqErr.RequestID())
   }
 }
 
-func promptLoop(prompt string, process func(string) (error)) (err error) {
+// Prompt for lines until EOF or process returns io.EOF. Lines go into
+// the history when there is one, otherwise just readline's.
+func promptLoop(prompt string, history *History, process func(string) (error)) (err error) {
 
   errStr := "Error - %s.\n"
   for moreCommands := true; moreCommands; {
@@ -43,7 +121,13 @@ func promptLoop(prompt string, process func(string) (error)) (err error) {
     } else if err != nil {
       fmt.Printf(errStr, err)
     } else {
-      readline.AddHistory(line)
+      if history != nil {
+        if err = history.Add(line); err != nil {
+          fmt.Printf(errStr, err)
+        }
+      } else {
+        readline.AddHistory(line)
+      }
       err = process(line)
       if err == io.EOF {
         moreCommands = false
@@ -0,0 +1,102 @@
+package main
+
+import (
+  "bufio"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "strings"
+  "github.com/bobappleyard/readline"
+)
+
+// How many interactive commands to keep in the history file by default.
+const defaultHistorySize = 1000
+
+// The interactive history file, ~/.spur_history.
+func historyFile() string {
+  home, err := os.UserHomeDir()
+  if err != nil {
+    home = "."
+  }
+  return filepath.Join(home, ".spur_history")
+}
+
+// History is the interactive command history, kept in a file across
+// sessions. A command appears once, where it was last used, and only the
+// last Size commands are kept.
+type History struct {
+  File  string
+  Size  int
+  lines []string
+}
+
+// LoadHistory reads the history file, if there is one, into readline.
+func LoadHistory(file string, size int) (*History, error) {
+  if size < 0 {
+    size = 0
+  }
+  h := &History{File: file, Size: size}
+  f, err := os.Open(file)
+  if os.IsNotExist(err) {
+    return h, nil
+  }
+  if err != nil {
+    return h, err
+  }
+  defer f.Close()
+  scanner := bufio.NewScanner(f)
+  for scanner.Scan() {
+    h.add(scanner.Text())
+  }
+  for _, line := range h.lines {
+    readline.AddHistory(line)
+  }
+  return h, scanner.Err()
+}
+
+// Add a command to the history, and save it.
+func (h *History) Add(line string) error {
+  last := ""
+  if len(h.lines) > 0 {
+    last = h.lines[len(h.lines)-1]
+  }
+  if !h.add(line) {
+    return nil
+  }
+  if line != last {
+    readline.AddHistory(line)
+  }
+  return h.Save()
+}
+
+// Add a line, dropping an earlier copy and anything over the size.
+func (h *History) add(line string) bool {
+  if strings.TrimSpace(line) == "" {
+    return false
+  }
+  for i, l := range h.lines {
+    if l == line {
+      h.lines = append(h.lines[:i], h.lines[i+1:]...)
+      break
+    }
+  }
+  h.lines = append(h.lines, line)
+  if len(h.lines) > h.Size {
+    h.lines = h.lines[len(h.lines)-h.Size:]
+  }
+  return true
+}
+
+// Lines in the history, oldest first.
+func (h *History) Lines() []string {
+  return h.lines
+}
+
+// Save the history file, a size of 0 keeps no file at all.
+func (h *History) Save() error {
+  if h.Size <= 0 {
+    return nil
+  }
+  data := strings.Join(h.lines, "\n") + "\n"
+  return ioutil.WriteFile(h.File, []byte(data), 0600)
+}
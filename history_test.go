@@ -0,0 +1,55 @@
+package main
+
+import (
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "testing"
+  . "github.com/smartystreets/goconvey/convey"
+)
+
+func TestHistory(t *testing.T) {
+
+  Convey("Given a history file", t, func() {
+    dir, _ := ioutil.TempDir("", "spur-history")
+    defer os.RemoveAll(dir)
+    file := filepath.Join(dir, "history")
+
+    Convey("Commands should be kept once, where they were last used", func() {
+      h, err := LoadHistory(file, 10)
+      So(err, ShouldBeNil)
+      for _, line := range []string{"list", "use clicks", "", "read all", "use clicks"} {
+        So(h.Add(line), ShouldBeNil)
+      }
+      So(h.Lines(), ShouldResemble, []string{"list", "read all", "use clicks"})
+
+      Convey("And be there in the next session", func() {
+        h, err := LoadHistory(file, 10)
+        So(err, ShouldBeNil)
+        So(h.Lines(), ShouldResemble, []string{"list", "read all", "use clicks"})
+      })
+    })
+
+    Convey("Only the most recent commands should be kept", func() {
+      h, _ := LoadHistory(file, 2)
+      h.Add("one")
+      h.Add("two")
+      h.Add("three")
+      h, _ = LoadHistory(file, 2)
+      So(h.Lines(), ShouldResemble, []string{"two", "three"})
+    })
+
+    Convey("A size of 0 should keep no file", func() {
+      h, _ := LoadHistory(file, 0)
+      h.Add("one")
+      _, err := os.Stat(file)
+      So(os.IsNotExist(err), ShouldBeTrue)
+    })
+
+    Convey("A negative size should be taken as 0", func() {
+      h, _ := LoadHistory(file, -1)
+      So(h.Add("one"), ShouldBeNil)
+      So(h.Lines(), ShouldBeEmpty)
+    })
+  })
+}
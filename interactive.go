@@ -2,8 +2,10 @@ package main
 // TODO: pull this out as it's own package.
 
 import (
+  "context"
   "fmt"
   "log"
+  "os"
   "strings"
   "time"
   "io"
@@ -12,6 +14,7 @@ import (
 
 var (
   interApp     *kingpin.Application
+  interTranscript *Transcript
 
   interExit *kingpin.CmdClause
   interQuit *kingpin.CmdClause
@@ -23,19 +26,75 @@ var (
   interTestString []string
 
   interPrompt *kingpin.CmdClause
+  interPartitionStrategy string
 
   interRead *kingpin.CmdClause
   interTailCmd *kingpin.CmdClause
   interReadType *string
+  interReadTypes = []string{"latest", "all", "tail", "at", "after", "since"}
+  interReadPosition string
+  interAllShards bool
+  interOrdered bool
+  interOutput *string
+  interDataEncoding *string
+  interUnwrap bool
   interTail bool
 
   interShow *kingpin.CmdClause
   interUse *kingpin.CmdClause
   interList   *kingpin.CmdClause
   interListType *string
+  interListTypes = []string{"aws", "group"}
+  interListQuery ListQuery
+  interListOutput *string
+  interListSort *string
+  interListReverse bool
   interCreate *kingpin.CmdClause
   interDelete *kingpin.CmdClause
+  interUpdate *kingpin.CmdClause
+  interRetention string
+  interTags []string
+  interUntags []string
+  interEncryption string
+  interWait bool
+  interYes bool
+  interWaitCmd *kingpin.CmdClause
+  interWaitFor *string
+  interWaitTimeout time.Duration
   interStreamName string
+  interSet *kingpin.CmdClause
+  interUnset *kingpin.CmdClause
+  interSetting *string
+  interUnsetSetting *string
+  interSettingValue string
+  interShards *kingpin.CmdClause
+  interShardsList *kingpin.CmdClause
+  interShardsSplit *kingpin.CmdClause
+  interShardsMerge *kingpin.CmdClause
+  interShardsScale *kingpin.CmdClause
+  interShardID string
+  interAdjacentShardID string
+  interSplitAt string
+  interSplitEven bool
+  interShardCount int64
+  interWhichShard *kingpin.CmdClause
+  interPartitionKey string
+
+  // Jobs
+  interJobs = NewJobs()
+  interJobsCmd *kingpin.CmdClause
+  interFg *kingpin.CmdClause
+  interBg *kingpin.CmdClause
+  interStop *kingpin.CmdClause
+  interKill *kingpin.CmdClause
+  interJobID int
+  interLine string
+  interBackground bool
+
+  // Scripts
+  interSource *kingpin.CmdClause
+  interSourceFile string
+  interSourceStop bool
 
 )
 
@@ -52,56 +111,174 @@ func init() {
   interIterate = interApp.Command("iterate", "Push a number of log entires to the Kinesis stream.")
   interIterate.Arg("numberOfIterations", "Number of log entires to push to the Kinesis stream.").Required().IntVar(&iterateCount)
   interIterate.Arg("test-string", "string to use in the log message.").Default("Testing the stream.").StringsVar(&interTestString)
+  interIterate.Flag("partition-strategy", "How to pick the partition key for each line: "+strings.Join(partitionStrategies, ", ")+".").StringVar(&interPartitionStrategy)
 
   interPrompt = interApp.Command("prompt", "Prompt for lines of text to log to stream.")
+  interPrompt.Flag("partition-strategy", "How to pick the partition key for each line: "+strings.Join(partitionStrategies, ", ")+".").StringVar(&interPartitionStrategy)
 
   // Read from streams
   interRead = interApp.Command("read", "Read from the stream.")
-  interReadType = interRead.Arg("read type", "How to read from the stream <latest|all|tail>.").Required().Enum("latest", "all", "tail")
+  interReadType = interRead.Arg("read type", "How to read from the stream <latest|all|tail|at|after|since>, the stream's iterator setting when left out.").Enum(interReadTypes...)
+  interRead.Flag("all-shards", "Read every open shard at once, each record is tagged with its shard ID.").BoolVar(&interAllShards)
+  interRead.Flag("ordered", "With --all-shards, order the records by their approximate arrival time.").BoolVar(&interOrdered)
+  interOutput = interRead.Flag("output", "Write records as text (just the data), jsonl or csv (with the record metadata). Default is the stream's output setting.").Enum(outputFormats...)
+  interDataEncoding = interRead.Flag("data-encoding", "Write the record data in jsonl and csv output as a raw string or base64.").Default("raw").Enum(dataEncodings...)
+  interRead.Flag("unwrap", "Take the log or json envelope off each record, leaving the line as written.").BoolVar(&interUnwrap)
+  interRead.Arg("position", "Sequence number for at and after, time (2006-01-02T15:04Z) or duration ago (30m) for since.").StringVar(&interReadPosition)
 
 
   // Manage streams
   interList = interApp.Command("list", "List the available Kinesis streams.")
-  interListType = interList.Arg("type", "List all the arguments. ").Required().Enum("aws", "group")
+  interListType = interList.Arg("type", "List the streams in AWS, the default, or the streams in the local group.").Enum(interListTypes...)
+  interList.Flag("prefix", "Only list the streams whose names start with this.").StringVar(&interListQuery.Prefix)
+  interList.Flag("status", "Only list the streams with this status, e.g. ACTIVE.").StringVar(&interListQuery.Status)
+  interListOutput = interList.Flag("output", "List the streams as a table, json or yaml.").Short('o').Default("table").Enum(listOutputFormats...)
+  interListSort = interList.Flag("sort", "Sort the streams on name, shards, retention, encryption, status or tags.").Default("name").Enum(listSortColumns...)
+  interList.Flag("reverse", "Sort the streams the other way.").BoolVar(&interListReverse)
   interCreate = interApp.Command("create", "Create a new Kinesis stream.")
   interCreate.Arg("stream", "Name of Kinesis stream to create").Required().StringVar(&interStreamName)
+  interCreate.Flag("shards", "Number of shards.").Default("2").Int64Var(&interShardCount)
+  interCreate.Flag("retention", "How long to keep records, in hours (168h) or days (7d). Default 24h.").StringVar(&interRetention)
+  interCreate.Flag("tag", "Tag the stream, key=value. Can be repeated.").StringsVar(&interTags)
+  interCreate.Flag("encrypt", "Encrypt the stream with a KMS key, kms:<key ID, ARN or alias>.").StringVar(&interEncryption)
+  interCreate.Flag("wait", "Wait for the stream to be ACTIVE.").BoolVar(&interWait)
+  interUpdate = interApp.Command("update", "Change the retention, tags or encryption of the current stream.")
+  interUpdate.Flag("retention", "How long to keep records, in hours (168h) or days (7d).").StringVar(&interRetention)
+  interUpdate.Flag("tag", "Add or change a tag, key=value. Can be repeated.").StringsVar(&interTags)
+  interUpdate.Flag("untag", "Remove a tag by its key. Can be repeated.").StringsVar(&interUntags)
+  interUpdate.Flag("encrypt", "Encrypt the stream with a KMS key, kms:<key ID, ARN or alias>, or none to stop.").StringVar(&interEncryption)
+  interUpdate.Flag("wait", "Wait for the stream to be ACTIVE again.").BoolVar(&interWait)
   interDelete = interApp.Command("delete", "Delete a specific Kinesis stream.")
   interDelete.Arg("stream", "Name of Kinesis stream to delete").Required().StringVar(&interStreamName)
+  interDelete.Flag("wait", "Wait for the stream to be gone.").BoolVar(&interWait)
+  interDelete.Flag("yes", "Don't ask to confirm. Protected streams still can't be deleted.").Short('y').BoolVar(&interYes)
+  interWaitCmd = interApp.Command("wait", "Wait for a stream to be ACTIVE or DELETED, showing each status it moves through.")
+  interWaitCmd.Arg("stream", "Stream to wait for, the current stream if left out.").StringVar(&interStreamName)
+  interWaitFor = interWaitCmd.Flag("for", "Status to wait for <"+strings.Join(waitStatuses, "|")+">.").Default("ACTIVE").Enum(waitStatuses...)
+  interWaitCmd.Flag("timeout", "How long to wait before giving up.").Default(activeTimeout.String()).DurationVar(&interWaitTimeout)
 
   // Manage current stream
   interShow = interApp.Command("show", "Display details of the current Kinesis Stream.")
-  interUse = interApp.Command("use", "Set the named stream as the current Kinesis Stream for future commands.")
-  interUse.Arg("stream", "Name of KinesisStream to use.").Required().StringVar(&interStreamName)
+  interUse = interApp.Command("use", "Set the named stream, or the stream of a target from .spur.yaml, as the current Kinesis Stream for future commands.")
+  interUse.Arg("stream", "Name of KinesisStream or target to use.").Required().StringVar(&interStreamName)
+  interShards = interApp.Command("shards", "List or reshard the shards of the current stream.")
+  interShardsList = interShards.Command("list", "List the shards, with their hash key and sequence number ranges and parents.").Default()
+  interShardsSplit = interShards.Command("split", "Split a shard in two and wait for the stream to be ACTIVE.")
+  interShardsSplit.Arg("shard", "Shard to split.").Required().StringVar(&interShardID)
+  interShardsSplit.Flag("at", "Hash key the second shard starts at.").StringVar(&interSplitAt)
+  interShardsSplit.Flag("even", "Split the hash key range in half, the default.").BoolVar(&interSplitEven)
+  interShardsMerge = interShards.Command("merge", "Merge two shards with adjacent hash key ranges and wait for the stream to be ACTIVE.")
+  interShardsMerge.Arg("shard", "Shard to merge.").Required().StringVar(&interShardID)
+  interShardsMerge.Arg("adjacent-shard", "Shard next to it to merge with.").Required().StringVar(&interAdjacentShardID)
+  interShardsScale = interShards.Command("scale", "Change the number of shards, evenly sized, and wait for the stream to be ACTIVE.")
+  interShardsScale.Arg("count", "Number of shards, from half to double the open shards.").Required().Int64Var(&interShardCount)
+  interWhichShard = interApp.Command("which-shard", "Work out which open shard of the current stream a partition key lands on.")
+  interWhichShard.Arg("partition-key", "Partition key to look up.").Required().StringVar(&interPartitionKey)
+  interSet = interApp.Command("set", "Change how the current stream is read and written, show lists the settings.")
+  interSetting = interSet.Arg("setting", "Setting to change <"+strings.Join(streamSettingNames, "|")+">.").Required().Enum(streamSettingNames...)
+  interSet.Arg("value", "New value: a partition key, shard ID, iterator type ("+strings.Join(iteratorTypes, ", ")+"), output ("+strings.Join(outputFormats, ", ")+") or partition strategy.").Required().StringVar(&interSettingValue)
+  interUnset = interApp.Command("unset", "Put a setting of the current stream back to the default.")
+  interUnsetSetting = interUnset.Arg("setting", "Setting to unset <"+strings.Join(streamSettingNames, "|")+">.").Required().Enum(streamSettingNames...)
+
+  // Scripts
+  interSource = interApp.Command("source", "Run the commands in a file, one per line or separated by ;. # starts a comment.")
+  interSource.Arg("file", "File of commands.").Required().StringVar(&interSourceFile)
+  interSource.Flag("stop-on-error", "Stop at the first command that fails.").BoolVar(&interSourceStop)
+
+  // Jobs, read and iterate run in the background when the line ends with &.
+  interJobsCmd = interApp.Command("jobs", "List the background jobs, e.g. those started with read tail &.")
+  interFg = interApp.Command("fg", "Bring a job to the foreground, <ctrl-c> kills it.")
+  interFg.Arg("id", "Job ID.").Required().IntVar(&interJobID)
+  interBg = interApp.Command("bg", "Continue a stopped job in the background.")
+  interBg.Arg("id", "Job ID.").Required().IntVar(&interJobID)
+  interStop = interApp.Command("stop", "Pause a job, fg or bg continue it.")
+  interStop.Arg("id", "Job ID.").Required().IntVar(&interJobID)
+  interKill = interApp.Command("kill", "Stop a job for good.")
+  interKill.Arg("id", "Job ID.").Required().IntVar(&interJobID)
 }
 
 
-func DoICommand(line string, g *KinesisStreamGroup) (err error) {
+// A command line that couldn't be parsed.
+type commandLineError struct {
+  error
+}
+
+// DoICommand runs one command line from the prompt. Mistyped command
+// lines are reported and shrugged off, errors from commands are returned.
+func DoICommand(line string, g *KinesisStreamGroup) error {
+  err := runICommand(line, g)
+  if lineErr, ok := err.(commandLineError); ok {
+    fmt.Printf("Command error: %s.\n", lineErr.error)
+    return nil
+  }
+  // A sourced script has reported its own errors.
+  if _, ok := err.(scriptError); ok {
+    return nil
+  }
+  return err
+}
+
+// Run one command line, returning a commandLineError if it can't be parsed.
+func runICommand(line string, g *KinesisStreamGroup) (err error) {
 
   // This is due to a 'peculiarity' kingpin, it collects strings as arguments across parses.
   interTestString = []string{}
-
-  // Prepare the line for parsing.
-  line = strings.TrimRight(line, "\n")
+  // Optional arguments and flags keep their value from the last parse too.
+  interReadPosition = ""
+  interAllShards, interOrdered, interUnwrap = false, false, false
+  interPartitionStrategy = ""
+  interSourceStop = false
+  interSplitAt, interSplitEven = "", false
+  interStreamName, interYes = "", false
+  interRetention, interTags, interUntags, interEncryption, interWait = "", []string{}, []string{}, "", false
+  *interOutput, *interReadType = "", ""
+  interListQuery, interListReverse, *interListType = ListQuery{}, false, ""
+
+  // Prepare the line for parsing, a trailing & runs it in the background.
+  line = strings.TrimSpace(line)
+  if line != "" {
+    interTranscript.Command(line)
+  }
+  interBackground = strings.HasSuffix(line, "&")
+  interLine = strings.TrimSpace(strings.TrimSuffix(line, "&"))
+  line = interLine
   fields := []string{}
   fields = append(fields, strings.Fields(line)...)
-  if len(fields) <= 0 {                                                                                                                                                                                                                                                                                       
+  if len(fields) <= 0 {
     return nil
   }
 
   command, err := interApp.Parse(fields)
+  if err == nil && interBackground && command != interRead.FullCommand() && command != interIterate.FullCommand() {
+    err = fmt.Errorf("only read and iterate can run in the background")
+  }
   if err != nil {
-    fmt.Printf("Command error: %s.\n", err)
-    return nil
+    return commandLineError{err}
   } else {
     switch command {
       case interList.FullCommand(): err = doListStreams(g)
       case interDelete.FullCommand(): err = doDeleteStream(g)
       case interCreate.FullCommand(): err = doCreateStream(g)
+      case interUpdate.FullCommand(): err = doUpdateStream(g)
+      case interWaitCmd.FullCommand(): err = doWaitStream(g)
       case interIterate.FullCommand(): err = doIterateWrite(g)
       case interPrompt.FullCommand(): err = doPromptWrite(g)
       case interRead.FullCommand(): err = doReadStream(g)
       case interShow.FullCommand(): err = doShowStream(g)
       case interUse.FullCommand(): err = doUseStream(g)
+      case interSet.FullCommand(): err = doSet(g)
+      case interShardsList.FullCommand(): err = doListShards(g)
+      case interShardsSplit.FullCommand(): err = doSplitStreamShard(g)
+      case interShardsMerge.FullCommand(): err = doMergeStreamShards(g)
+      case interShardsScale.FullCommand(): err = doScaleStreamShards(g)
+      case interWhichShard.FullCommand(): err = doLookupShard(g)
+      case interUnset.FullCommand(): err = doUnset(g)
+      case interSource.FullCommand(): err = doSource(g)
+      case interJobsCmd.FullCommand(): err = doJobs()
+      case interFg.FullCommand(): err = doForeground()
+      case interBg.FullCommand(): err = doBackground()
+      case interStop.FullCommand(): err = doStopJob()
+      case interKill.FullCommand(): err = doKillJob()
       case interVerbose.FullCommand(): err = doVerbose()
       case interExit.FullCommand(): err = doQuit()
       case interQuit.FullCommand(): err = doQuit() 
@@ -132,63 +309,58 @@ func doQuit() (error) {
 
 
 func doCreateStream(g *KinesisStreamGroup) (err error) {
-  shards := int64(2)
-  stream, err := g.CreateKinesisStream(interStreamName, shards)
-  if err == nil {
-    stream.WaitForStateChange(10, 1, "CREATING", func(stateName string, err error) {
-      if err == nil {
-        fmt.Printf("\nStream %s is now in the %s state.\n", stream.Name, stateName)
-      }
-      })
-    fmt.Printf("Created stream: %s with %d shards.\n", stream.Name, shards)
+  config, err := NewStreamConfig(interRetention, interTags, nil, interEncryption)
+  if err != nil {
+    return err
   }
+  _, err = createStream(os.Stdout, g, interStreamName, interShardCount, config, interWait)
   return err
 }
 
+func doUpdateStream(g *KinesisStreamGroup) (err error) {
+  config, err := NewStreamConfig(interRetention, interTags, interUntags, interEncryption)
+  if err != nil {
+    return err
+  }
+  return updateStream(os.Stdout, g.CurrentStream, config, interWait)
+}
+
 func doDeleteStream(g *KinesisStreamGroup) (error) {
-  stream, err := g.DeleteKinesisStream(interStreamName)
+  stream, err := g.GetStream(interStreamName)
+  if err != nil {
+    return err
+  }
+  if err = checkDelete(os.Stdout, stream, interYes); err != nil {
+    return err
+  }
+  _, err = g.DeleteKinesisStream(interStreamName)
   if err == nil {
-    stream.WaitForStateChange(10, 1, "DELETING", func(stateName string, err error) {
-      if err == nil {
-        if stateName == "" {
-          stateName = "DELETED"
-        }
-        fmt.Printf("\nStream %s, is now in the %s state.\n", stream.Name, stateName)
-      }
-    })
-    fmt.Printf("Deleted sttream: %s.\n", interStreamName)
+    fmt.Printf("Deleted stream: %s.\n", interStreamName)
+    if interWait {
+      err = waitForStream(os.Stdout, stream, statusDeleted, activeTimeout)
+    }
   }
   return err
 }
 
-func doListStreams(g *KinesisStreamGroup) (err error) {
-
-  if *interListType == "aws" {
-    streams, err := g.ListStreams()
-    if err == nil {
-      for i, description := range streams {
-        fmt.Printf("%d. %s\n", i+1, description.Name)
-        sd := description.Description
-        fmt.Printf("%20s %s\n", "Stream Status:", *sd.StreamStatus)
-        fmt.Printf("%20s %s\n", "ARN:", *sd.StreamARN)
-        fmt.Printf("%20s %d\n", "Number of Shards:", len(sd.Shards))
-        fmt.Printf("%20s [", "ShardNames:")
-        for j, shard := range sd.Shards {
-          fmt.Printf("%s", *shard.ShardID)
-          if j < (len(sd.Shards) - 1) {
-            fmt.Printf(", ")
-          }
-          // Put three shardIDs to a line, then 'tab' over
-          if ((j + 1) % 3) == 0 {
-            fmt.Printf("%20s ", "")
-          }
-        }
-      }
+func doWaitStream(g *KinesisStreamGroup) (err error) {
+  s := g.CurrentStream
+  if interStreamName != "" {
+    // A stream being deleted is no longer in the group.
+    s = g.Streams[interStreamName]
+    if s == nil {
+      s = g.newStream(interStreamName)
     }
-  } else {
+  }
+  return waitForStream(os.Stdout, s, *interWaitFor, interWaitTimeout)
+}
+
+func doListStreams(g *KinesisStreamGroup) (err error) {
+  if *interListType == "group" {
     fmt.Printf("The local group is: %s.\n", g.Description())
+    return nil
   }
-  return err
+  return listStreams(os.Stdout, g, interListQuery, *interListSort, interListReverse, *interListOutput)
 }
 
 func doShowStream(g *KinesisStreamGroup) (err error) {
@@ -197,22 +369,136 @@ func doShowStream(g *KinesisStreamGroup) (err error) {
 }
 
 func doUseStream(g *KinesisStreamGroup) (err error) {
+  if t := spurConfig.Targets[interStreamName]; t != nil {
+    return useTarget(g, interStreamName, t)
+  }
   err = g.SetCurrentStream(interStreamName)
+  if err == nil {
+    fmt.Printf("Now using %s.\n", g.CurrentStream.Name)
+  }
+  return err
+}
+
+func doListShards(g *KinesisStreamGroup) (err error) {
+  shards, err := g.CurrentStream.GetShards()
+  if err == nil {
+    writeShards(os.Stdout, shards)
+  }
+  return err
+}
+
+func doSplitStreamShard(g *KinesisStreamGroup) (err error) {
+  return splitShard(os.Stdout, g.CurrentStream, interShardID, interSplitAt, interSplitEven)
+}
+
+func doMergeStreamShards(g *KinesisStreamGroup) (err error) {
+  s := g.CurrentStream
+  return Reshard(os.Stdout, s, func() error { return s.MergeShards(interShardID, interAdjacentShardID) })
+}
+
+func doScaleStreamShards(g *KinesisStreamGroup) (err error) {
+  s := g.CurrentStream
+  return Reshard(os.Stdout, s, func() error { return s.UpdateShardCount(interShardCount) })
+}
+
+func doLookupShard(g *KinesisStreamGroup) (err error) {
+  return writeWhichShard(os.Stdout, g.CurrentStream, interPartitionKey)
+}
+
+func doSet(g *KinesisStreamGroup) (err error) {
+  s := g.CurrentStream
+  if err = s.Set(*interSetting, interSettingValue); err == nil {
+    fmt.Printf("%s %s is now %s.\n", s.Name, *interSetting, s.Setting(*interSetting))
+  }
+  return err
+}
+
+func doUnset(g *KinesisStreamGroup) (err error) {
+  s := g.CurrentStream
+  if err = s.Set(*interUnsetSetting, g.Defaults.Get(*interUnsetSetting)); err == nil {
+    fmt.Printf("%s %s is back to the default: \"%s\".\n", s.Name, *interUnsetSetting, s.Setting(*interUnsetSetting))
+  }
+  return err
+}
+
+// Use a target's stream, switching the group to the target's account,
+// region or endpoint if it names one. The group is left as it was unless
+// the stream and all the target's settings can be used.
+func useTarget(g *KinesisStreamGroup, name string, t *Target) (err error) {
+  group := g
+  if t.Profile != "" || t.Region != "" || t.Endpoint != "" {
+    config, err := LoadAWSConfig(AWSOptions{Profile: t.Profile, Region: t.Region, EndpointURL: t.Endpoint})
+    if err != nil {
+      return err
+    }
+    if group, err = NewStreamGroup(config); err != nil {
+      return err
+    }
+    if err = group.SetDefaults(g.Defaults); err != nil {
+      return err
+    }
+  }
+
+  streamName := t.Stream
+  if streamName == "" {
+    streamName = g.CurrentStream.Name
+  }
+  stream, err := group.GetStream(streamName)
   if err != nil {
-    fmt.Printf("Error: %s\n", err)
+    return err
   }
-  fmt.Printf("Now using %s.\n", g.CurrentStream.Name)
+  // Settings go on a copy, so a bad one doesn't leave the others set.
+  s := *stream
+  settings := StreamSettings{Partition: t.Partition, PartitionStrategy: t.PartitionStrategy,
+    ShardID: t.ShardID, IteratorType: t.IteratorType, Output: t.Output}
+  for _, setting := range streamSettingNames {
+    if value := settings.Get(setting); value != "" {
+      if err = s.Set(setting, value); err != nil {
+        return err
+      }
+    }
+  }
+  // The target's since starts reading at that time, as it does with --target.
+  if t.Since != "" {
+    timestamp, err := parseTimestamp(t.Since)
+    if err != nil {
+      return err
+    }
+    if err = s.SetStartingPosition("AT_TIMESTAMP", "", timestamp); err != nil {
+      return err
+    }
+  }
+  g.Streams, g.Service, g.Region = group.Streams, group.Service, group.Region
+  g.Streams[streamName] = &s
+  g.CurrentStream = &s
+  fmt.Printf("Now using %s in %s (target %s).\n", s.Name, g.Region, name)
   return nil
 }
 
+// The current stream to write to, using the --partition-strategy for
+// this command if one was given.
+func writeStream(g *KinesisStreamGroup) (*KinesisStream, error) {
+  if interPartitionStrategy == "" {
+    return g.CurrentStream, nil
+  }
+  s := *g.CurrentStream
+  partitioner, err := NewPartitionStrategy(interPartitionStrategy, s.Partition, &s)
+  s.Partitioner = partitioner
+  return &s, err
+}
+
 func doPromptWrite(g *KinesisStreamGroup) (err error) {
+  s, err := writeStream(g)
+  if err != nil {
+    return err
+  }
   xPRCommand := func(line string) (err error) { 
-    _, e := g.CurrentStream.PutLogLine(line)
+    _, e := s.PutLogLine(line)
     return e
   }
 
-  prompt := g.CurrentStream.Name + "(write) >"
-  err = promptLoop(prompt, xPRCommand)
+  prompt := s.Name + "(write) >"
+  err = promptLoop(prompt, nil, xPRCommand)
   fmt.Println("")
   return err
 }
@@ -222,18 +508,36 @@ func doIterateWrite(g *KinesisStreamGroup) (err error) {
   for i := range interTestString {
     testString += " " + interTestString[i]
   }
-  if iVerbose {
-    fmt.Printf("Using \"%s\" as the test string for %d iterations.\n", testString, iterateCount)
-  }
+  count, verbose := iterateCount, iVerbose
 
-  for i := 0; i < iterateCount; i++ {
-    line := fmt.Sprintf("%s: %d", testString, i)
-    _, err := g.CurrentStream.PutLogLine(line)
-    if err != nil {
-      return err
-    }
+  s, err := writeStream(g)
+  if err != nil {
+    return err
   }
-  return nil
+  return runJob(s, func(j *Job) (err error) {
+    if verbose {
+      fmt.Fprintf(j.Out(), "Using \"%s\" as the test string for %d iterations.\n", testString, count)
+    }
+    p := s.NewProducer(time.Second)
+    p.Verbose = verbose
+    for i := 0; i < count; i++ {
+      line := fmt.Sprintf("%s: %d", testString, i)
+      if err = j.Wait(); err != nil {
+        break
+      }
+      if err = p.PutLogLine(line); err != nil {
+        break
+      }
+    }
+    if closeErr := p.Close(); err == nil {
+      err = closeErr
+    }
+    if verbose || (err != nil && err != context.Canceled) {
+      sent, failed := p.Stats()
+      fmt.Fprintf(j.Out(), "Put %d records, %d failed.\n", sent, failed)
+    }
+    return err
+  })
 }
 
 func doReadStreamTail(g *KinesisStreamGroup) (err error) {
@@ -247,53 +551,97 @@ func configureFromReadType(readType string) (shardIteeator string, tail bool) {
     case "tail": return "LATEST", true
     case "latest": return "LATEST", false
     case "all": return "TRIM_HORIZON", false
+    case "at": return "AT_SEQUENCE_NUMBER", false
+    case "after": return "AFTER_SEQUENCE_NUMBER", false
+    case "since": return "AT_TIMESTAMP", false
     default: log.Fatal(fmt.Sprintf("Unkonown ReadType: %s", readType))
   }
   return "", false
 }
 
+// Set the starting position of the stream from the read type and its position argument.
+func configureStartingPosition(s *KinesisStream, readType, position string) (tail bool, err error) {
+  var sequenceNumber string
+  var timestamp time.Time
+  iteratorType, tail := configureFromReadType(readType)
+  switch iteratorType {
+    case "AT_SEQUENCE_NUMBER", "AFTER_SEQUENCE_NUMBER":
+      sequenceNumber = position
+    case "AT_TIMESTAMP":
+      if position != "" {
+        timestamp, err = parseTimestamp(position)
+        if err != nil {
+          return tail, err
+        }
+      }
+  }
+  return tail, s.SetStartingPosition(iteratorType, sequenceNumber, timestamp)
+}
+
 func doReadStream(g *KinesisStreamGroup) (err error) {
 
+  // Read from a copy of the stream, so jobs reading it don't share shard iterators.
+  s := *g.CurrentStream
+  tail := false
+  if *interReadType != "" {
+    tail, err = configureStartingPosition(&s, *interReadType, interReadPosition)
+  } else {
+    err = s.SetStartingPosition(s.ShardIteratorType, s.StartingSequenceNumber, s.StartingTimestamp)
+  }
+  if err != nil {
+    return err
+  }
+
+  // Take the flags now, the next command line will reset them.
+  output, encoding, unwrap := *interOutput, *interDataEncoding, interUnwrap
+  if output == "" {
+    output = s.outputName()
+  }
+  allShards, ordered, verbose := interAllShards, interOrdered, iVerbose
+  return runJob(&s, func(j *Job) error {
+    out := NewRecordWriter(j.Out(), s.Name, output, encoding)
+    out.Unwrap = unwrap
+    if allShards {
+      return readAllShards(j.Context(), out, &s, tail, ordered, verbose)
+    }
+    return readStream(j.Context(), j.Out(), out, &s, tail, verbose)
+  })
+}
+
+func readStream(ctx context.Context, w io.Writer, out *RecordWriter, s *KinesisStream, tail, verbose bool) (err error) {
+
   const sleepMilli = 500
   var lastDelay, msecBehind int64 = 0,0
-  s := g.CurrentStream
-  s.ShardIteratorType, interTail = configureFromReadType(*interReadType)
-  
-  if iVerbose {
-    fmt.Printf("Reading from shard: %s\n", s.ShardID)
-    fmt.Printf("With iterator type: %s\n", s.ShardIteratorType)
+
+  if verbose {
+    fmt.Fprintf(w, "Reading from shard: %s\n", s.ShardID)
+    fmt.Fprintf(w, "With iterator type: %s\n", s.ShardIteratorType)
+    fmt.Fprint(w, s.startingPositionDescription())
   }
 
   emptyReads := 0
   s.ReadReset()
-  for moreData := true; moreData; {
+  for moreData := true; moreData && ctx.Err() == nil; {
 
     output, err := s.GetRecords()
     if err != nil {
-      printAWSError(err)
+      writeAWSError(w, err)
       return err
     }
 
-    // Read until we're caught up, or sleep if we're tailing.
-    // TODO: Tail needs fixing. Currently, we can only control-c out of it
-    // need to set something up that allows us to tail and listen
-    // and so stop taliing with a control-d.
+    // Read until we're caught up, or sleep if we're tailing (below).
     msecBehind = *output.MillisBehindLatest
-    if msecBehind == 0 {
-      if interTail {
-        time.Sleep(time.Duration(sleepMilli) * time.Millisecond)
-      } else {
-        moreData = false
-      }
+    if msecBehind == 0 && !tail {
+      moreData = false
     }
 
     // Count empty reads, and only report on them when we finally get data.
     if len(output.Records) > 0 {
-      if iVerbose {
+      if verbose {
         if emptyReads != 0 {
-          fmt.Printf("%d empty responses (no records).")
+          fmt.Fprintf(w, "%d empty responses (no records).\n", emptyReads)
         } 
-        fmt.Printf("Got %d data records\n", len(output.Records))
+        fmt.Fprintf(w, "Got %d data records\n", len(output.Records))
       }
       emptyReads = 0 
     } else {
@@ -301,30 +649,125 @@ func doReadStream(g *KinesisStreamGroup) (err error) {
     }
 
     // Report if the lag from end of stream has changed.
-    if iVerbose && (lastDelay != msecBehind) {
+    if verbose && (lastDelay != msecBehind) {
       lastDelay = msecBehind 
       if msecBehind == 0 {
-        fmt.Printf("We are now at the top of the stream.\n")
+        fmt.Fprintf(w, "We are now at the top of the stream.\n")
       } else {
-        fmt.Printf("The response is now %s behind the top of stream.\n", fmtMilliseconds(msecBehind))
+        fmt.Fprintf(w, "The response is now %s behind the top of stream.\n", fmtMilliseconds(msecBehind))
       }
     }
 
     for i, record := range output.Records {
-      if iVerbose {
-        fmt.Printf("Data record: %d\n", i+1)
-        fmt.Printf("Parittion: %s\n", *record.PartitionKey)
-        fmt.Printf("Sequence number: %s\n", *record.SequenceNumber)
-        fmt.Printf("Data: ")
+      if verbose && !out.Structured() {
+        fmt.Fprintf(w, "Data record: %d\n", i+1)
+        fmt.Fprintf(w, "Parittion: %s\n", *record.PartitionKey)
+        fmt.Fprintf(w, "Sequence number: %s\n", *record.SequenceNumber)
+        fmt.Fprintf(w, "Data: ")
+      }
+      if err = out.Write(s.ShardID, record); err != nil {
+        return err
+      }
+      if verbose && !out.Structured() {fmt.Fprintln(w)}
+    }
+
+    // The shard was closed by a split or merge, carry on with its children.
+    if s.ShardClosed {
+      others, err := s.FollowChildShards()
+      if err != nil {
+        return err
+      }
+      if len(others) > 0 {
+        if verbose {
+          fmt.Fprintf(w, "Shard %s was split, now reading: %s\n", s.ShardID, strings.Join(others, ", "))
+        }
+        records, errs := s.ReadShards(ctx, others, tail, sleepMilli*time.Millisecond)
+        return writeShardRecords(out, records, errs, verbose, s.Checkpoint)
+      }
+      moreData = !s.ShardClosed
+      if verbose && moreData {
+        fmt.Fprintf(w, "Shard was closed, now reading from shard: %s\n", s.ShardID)
+      }
+    }
+
+    // Tails stop when their job is killed, or with ctrl-c in the foreground.
+    if msecBehind == 0 && tail && moreData {
+      select {
+        case <-time.After(time.Duration(sleepMilli) * time.Millisecond):
+        case <-ctx.Done():
       }
-      fmt.Println(string(record.Data))
-      if iVerbose {fmt.Println()}
     }
   }
 
+  return ctx.Err()
+}
+
+func readAllShards(ctx context.Context, out *RecordWriter, s *KinesisStream, tail, ordered, verbose bool) (err error) {
+  const sleep = 500 * time.Millisecond
+  records, errs, err := s.ReadAllShards(ctx, tail, sleep)
+  if err != nil {
+    return err
+  }
+  if ordered {
+    records = orderRecords(records, tail, sleep)
+  }
+  return writeShardRecords(out, records, errs, verbose, s.Checkpoint)
+}
+
+// Run the command line as a job, in the foreground or, if it
+// ended with &, the background.
+func runJob(s *KinesisStream, work func(j *Job) error) error {
+  j := interJobs.Start(interLine, s.Name, !interBackground, work)
+  if interBackground {
+    fmt.Printf("[%d] %s\n", j.ID, interLine)
+    return nil
+  }
+  return interJobs.Foreground(j)
+}
+
+func doJobs() error {
+  for _, j := range interJobs.List() {
+    fmt.Println(j)
+  }
   return nil
 }
 
+func doForeground() error {
+  j, err := interJobs.Get(interJobID)
+  if err != nil {
+    return err
+  }
+  fmt.Println(j.Command)
+  return interJobs.Foreground(j)
+}
+
+func doBackground() error {
+  j, err := interJobs.Get(interJobID)
+  if err == nil {
+    j.Continue()
+    fmt.Println(j)
+  }
+  return err
+}
+
+func doStopJob() error {
+  j, err := interJobs.Get(interJobID)
+  if err == nil {
+    j.Stop()
+    fmt.Println(j)
+  }
+  return err
+}
+
+func doKillJob() error {
+  j, err := interJobs.Get(interJobID)
+  if err == nil {
+    j.Kill()
+    <-j.Done()
+  }
+  return err
+}
+
 // This is used to catch the termiation on help
 // that is the default kingpin behavior.
 func doTerminate(i int) {
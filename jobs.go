@@ -0,0 +1,302 @@
+package main
+
+import (
+  "bytes"
+  "context"
+  "fmt"
+  "io"
+  "os"
+  "os/signal"
+  "sort"
+  "sync"
+  "time"
+)
+
+// Job is an interactive command running in its own goroutine, in the
+// foreground or, when the command line ends with &, the background.
+// Background jobs tag each line they write with their ID.
+type Job struct {
+  ID      int
+  Command string
+  Stream  string
+  Started time.Time
+
+  ctx    context.Context
+  cancel context.CancelFunc
+  out    *jobWriter
+  done   chan struct{}
+
+  mu         sync.Mutex
+  state      string
+  err        error
+  foreground bool
+  resume     chan struct{}
+}
+
+// The states a job can be in.
+const (
+  jobRunning = "running"
+  jobStopped = "stopped"
+  jobDone    = "done"
+  jobFailed  = "failed"
+  jobKilled  = "killed"
+)
+
+// Jobs is the job table of an interactive session.
+type Jobs struct {
+  mu   sync.Mutex
+  jobs map[int]*Job
+  next int
+}
+
+func NewJobs() *Jobs {
+  return &Jobs{jobs: make(map[int]*Job), next: 1}
+}
+
+// Start runs work as a new job. The work should give up when the job's
+// context is done, and call Wait between steps that don't write, to
+// stop when the job is stopped. Writing to the job's output waits too.
+func (js *Jobs) Start(command, stream string, foreground bool, work func(j *Job) error) *Job {
+  js.mu.Lock()
+  j := &Job{ID: js.next, Command: command, Stream: stream, Started: time.Now(),
+    done: make(chan struct{}), state: jobRunning, foreground: foreground}
+  js.next++
+  js.jobs[j.ID] = j
+  js.mu.Unlock()
+
+  j.ctx, j.cancel = context.WithCancel(context.Background())
+  j.out = &jobWriter{job: j}
+  go func() {
+    err := work(j)
+    j.out.Flush()
+    j.finish(err)
+    if !j.isForeground() {
+      fmt.Printf("\n%s\n", j)
+    }
+    close(j.done)
+  }()
+  return j
+}
+
+func (j *Job) finish(err error) {
+  j.mu.Lock()
+  defer j.mu.Unlock()
+  switch {
+    case j.state == jobKilled:
+    case err != nil && err != context.Canceled:
+      j.state, j.err = jobFailed, err
+    default:
+      j.state = jobDone
+  }
+  j.cancel()
+}
+
+// Get a job by ID.
+func (js *Jobs) Get(id int) (*Job, error) {
+  js.mu.Lock()
+  defer js.mu.Unlock()
+  j := js.jobs[id]
+  if j == nil {
+    return nil, fmt.Errorf("No job %d.", id)
+  }
+  return j, nil
+}
+
+// List the jobs in ID order. Finished jobs are listed once, then forgotten.
+func (js *Jobs) List() (jobs []*Job) {
+  js.mu.Lock()
+  defer js.mu.Unlock()
+  for _, j := range js.jobs {
+    jobs = append(jobs, j)
+    if j.Finished() {
+      delete(js.jobs, j.ID)
+    }
+  }
+  sort.Slice(jobs, func(a, b int) bool { return jobs[a].ID < jobs[b].ID })
+  return jobs
+}
+
+// KillAll kills every job and waits for them to finish.
+func (js *Jobs) KillAll() {
+  for _, j := range js.List() {
+    j.Kill()
+    <-j.done
+  }
+}
+
+// Foreground runs the job in the foreground until it finishes, or an
+// interrupt (ctrl-c) kills it.
+func (js *Jobs) Foreground(j *Job) error {
+  interrupt := make(chan os.Signal, 1)
+  signal.Notify(interrupt, os.Interrupt)
+  defer signal.Stop(interrupt)
+
+  j.mu.Lock()
+  j.foreground = true
+  j.mu.Unlock()
+  j.Continue()
+
+  select {
+    case <-j.done:
+    case <-interrupt:
+      j.Kill()
+      <-j.done
+      fmt.Println()
+  }
+  js.mu.Lock()
+  delete(js.jobs, j.ID)
+  js.mu.Unlock()
+  return j.Err()
+}
+
+// Stop pauses the job, until it's continued in the background or foreground.
+func (j *Job) Stop() {
+  j.mu.Lock()
+  defer j.mu.Unlock()
+  if j.state == jobRunning {
+    j.state, j.resume = jobStopped, make(chan struct{})
+  }
+}
+
+// Continue a stopped job.
+func (j *Job) Continue() {
+  j.mu.Lock()
+  defer j.mu.Unlock()
+  if j.state == jobStopped {
+    j.state = jobRunning
+    close(j.resume)
+  }
+}
+
+// Kill cancels the job's context.
+func (j *Job) Kill() {
+  j.mu.Lock()
+  if !j.finished() {
+    j.state = jobKilled
+    if j.resume != nil {
+      select {
+        case <-j.resume:
+        default: close(j.resume)
+      }
+    }
+  }
+  j.mu.Unlock()
+  j.cancel()
+}
+
+// Wait blocks while the job is stopped, and returns an error once it's been killed.
+func (j *Job) Wait() error {
+  j.mu.Lock()
+  resume := j.resume
+  stopped := j.state == jobStopped
+  j.mu.Unlock()
+  if stopped {
+    select {
+      case <-resume:
+      case <-j.ctx.Done():
+    }
+  }
+  return j.ctx.Err()
+}
+
+func (j *Job) Context() context.Context {
+  return j.ctx
+}
+
+// Out is where the job writes, through Wait.
+func (j *Job) Out() io.Writer {
+  return j.out
+}
+
+func (j *Job) Done() <-chan struct{} {
+  return j.done
+}
+
+// Err is the error the job failed with, if it did.
+func (j *Job) Err() error {
+  j.mu.Lock()
+  defer j.mu.Unlock()
+  return j.err
+}
+
+func (j *Job) State() string {
+  j.mu.Lock()
+  defer j.mu.Unlock()
+  return j.state
+}
+
+func (j *Job) Finished() bool {
+  j.mu.Lock()
+  defer j.mu.Unlock()
+  return j.finished()
+}
+
+func (j *Job) finished() bool {
+  return j.state == jobDone || j.state == jobFailed || j.state == jobKilled
+}
+
+func (j *Job) isForeground() bool {
+  j.mu.Lock()
+  defer j.mu.Unlock()
+  return j.foreground
+}
+
+func (j *Job) String() string {
+  j.mu.Lock()
+  defer j.mu.Unlock()
+  s := fmt.Sprintf("[%d] %-8s %s", j.ID, j.state, j.Command)
+  if j.Stream != "" {
+    s += " (" + j.Stream + ")"
+  }
+  if !j.finished() {
+    s += fmt.Sprintf(" %s", time.Since(j.Started).Round(time.Second))
+  }
+  if j.err != nil {
+    s += fmt.Sprintf(": %s", j.err)
+  }
+  return s
+}
+
+// jobWriter writes a job's output to stdout a line at a time, tagging
+// the lines of background jobs with the job ID. It waits while the job
+// is stopped.
+type jobWriter struct {
+  job     *Job
+  mu      sync.Mutex
+  partial []byte
+}
+
+func (w *jobWriter) Write(p []byte) (n int, err error) {
+  if err = w.job.Wait(); err != nil {
+    return 0, err
+  }
+  w.mu.Lock()
+  defer w.mu.Unlock()
+  w.partial = append(w.partial, p...)
+  for {
+    i := bytes.IndexByte(w.partial, '\n')
+    if i < 0 {
+      break
+    }
+    w.writeLine(w.partial[:i+1])
+    w.partial = w.partial[i+1:]
+  }
+  return len(p), nil
+}
+
+// Flush writes any last line without a newline.
+func (w *jobWriter) Flush() {
+  w.mu.Lock()
+  defer w.mu.Unlock()
+  if len(w.partial) > 0 {
+    w.writeLine(append(w.partial, '\n'))
+    w.partial = nil
+  }
+}
+
+func (w *jobWriter) writeLine(line []byte) {
+  if !w.job.isForeground() {
+    fmt.Fprintf(os.Stdout, "[%d] ", w.job.ID)
+  }
+  os.Stdout.Write(line)
+}
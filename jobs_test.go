@@ -0,0 +1,112 @@
+package main
+
+import (
+  "strings"
+  "testing"
+  "time"
+  . "github.com/smartystreets/goconvey/convey"
+)
+
+// Wait up to a second for cond to hold.
+func eventually(cond func() bool) bool {
+  for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+    if cond() {
+      return true
+    }
+  }
+  return cond()
+}
+
+func TestJobs(t *testing.T) {
+
+  Convey("Given an interactive session on a fake stream", t, func() {
+    svc, s := newFakeStream("clicks", 1)
+    g, _ := NewStreamGroupWithService(svc, "us-west-1")
+    g.Streams["clicks"] = s
+    g.CurrentStream = s
+    interJobs = NewJobs()
+    defer interJobs.KillAll()
+
+    Convey("A background tail should print tagged records until it's killed", func() {
+      out := captureStdout(func() {
+        So(DoICommand("read tail &", g), ShouldBeNil)
+        j, err := interJobs.Get(1)
+        So(err, ShouldBeNil)
+        So(j.State(), ShouldEqual, jobRunning)
+
+        // Management commands still work while it tails.
+        So(DoICommand("show", g), ShouldBeNil)
+        // Give the tail time to start at the latest record, and then to poll again.
+        time.Sleep(300 * time.Millisecond)
+        s.PutLogLine("tailed")
+        time.Sleep(700 * time.Millisecond)
+
+        So(DoICommand("stop 1", g), ShouldBeNil)
+        So(j.State(), ShouldEqual, jobStopped)
+        So(DoICommand("bg 1", g), ShouldBeNil)
+        So(j.State(), ShouldEqual, jobRunning)
+        So(DoICommand("kill 1", g), ShouldBeNil)
+        So(j.State(), ShouldEqual, jobKilled)
+      })
+      So(out, ShouldContainSubstring, "[1] tailed")
+      So(out, ShouldContainSubstring, "[1] killed")
+    })
+
+    Convey("A background iterate should finish by itself and be listed once", func() {
+      captureStdout(func() {
+        So(DoICommand("iterate 5 hello &", g), ShouldBeNil)
+        j, _ := interJobs.Get(1)
+        So(eventually(j.Finished), ShouldBeTrue)
+        So(j.State(), ShouldEqual, jobDone)
+        So(interJobs.List(), ShouldHaveLength, 1)
+        So(interJobs.List(), ShouldBeEmpty)
+      })
+      out := captureStdout(func() {
+        So(DoICommand("read all", g), ShouldBeNil)
+      })
+      So(strings.Count(out, "hello: "), ShouldEqual, 5)
+    })
+
+    Convey("A stopped job should hold its output until it's continued", func() {
+      j := interJobs.Start("test", "clicks", false, func(j *Job) error {
+        <-j.Context().Done()
+        return j.Context().Err()
+      })
+      j.Stop()
+      written := make(chan bool)
+      go func() {
+        j.Out().Write([]byte("held\n"))
+        written <- true
+      }()
+      select {
+        case <-written: t.Error("wrote while stopped")
+        case <-time.After(50 * time.Millisecond):
+      }
+      captureStdout(func() {
+        j.Continue()
+        <-written
+      })
+      j.Kill()
+      <-j.Done()
+      So(j.State(), ShouldEqual, jobKilled)
+    })
+
+    Convey("A background read's errors should go through the job's output", func() {
+      s.ShardID = "shardId-000000000009"
+      out := captureStdout(func() {
+        So(DoICommand("read all &", g), ShouldBeNil)
+        j, _ := interJobs.Get(1)
+        So(eventually(j.Finished), ShouldBeTrue)
+      })
+      So(out, ShouldContainSubstring, "[1] awsError:")
+    })
+
+    Convey("Only read and iterate can run in the background", func() {
+      out := captureStdout(func() {
+        So(DoICommand("show &", g), ShouldBeNil)
+      })
+      So(out, ShouldContainSubstring, "only read and iterate")
+      So(interJobs.List(), ShouldBeEmpty)
+    })
+  })
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+  "fmt"
+  "github.com/aws/aws-sdk-go/aws"
+  "github.com/aws/aws-sdk-go/service/kinesis"
+  "io"
+  "strconv"
+  "strings"
+  "time"
+)
+
+// StreamConfig is what can be changed about a stream after it's created,
+// the zero values leave things as they are.
+type StreamConfig struct {
+  Retention  time.Duration
+  Tags       map[string]string
+  Untag      []string
+
+  // kms:<key ID, ARN or alias> to encrypt, none to stop.
+  Encryption string
+}
+
+func (c StreamConfig) empty() bool {
+  return c.Retention == 0 && len(c.Tags) == 0 && len(c.Untag) == 0 && c.Encryption == ""
+}
+
+// NewStreamConfig makes a config from its command line form: retention as
+// a duration (168h) or days (7d), tags as key=value and encryption as
+// kms:<key> or none.
+func NewStreamConfig(retention string, tags, untag []string, encryption string) (c StreamConfig, err error) {
+  if retention != "" {
+    if c.Retention, err = parseRetention(retention); err != nil {
+      return c, err
+    }
+  }
+  for _, tag := range tags {
+    i := strings.Index(tag, "=")
+    if i <= 0 {
+      return c, fmt.Errorf("A tag is key=value, not %s", tag)
+    }
+    if c.Tags == nil {
+      c.Tags = make(map[string]string)
+    }
+    c.Tags[tag[:i]] = tag[i+1:]
+  }
+  c.Untag = untag
+  if encryption != "" && encryption != "none" && (!strings.HasPrefix(encryption, "kms:") || encryption == "kms:") {
+    return c, fmt.Errorf("Encryption is kms:<key ID, ARN or alias> or none, not %s", encryption)
+  }
+  c.Encryption = encryption
+  return c, nil
+}
+
+// Retention is kept in whole hours.
+func parseRetention(value string) (time.Duration, error) {
+  d, err := time.ParseDuration(value)
+  if strings.HasSuffix(value, "d") {
+    days, e := strconv.Atoi(strings.TrimSuffix(value, "d"))
+    d, err = time.Duration(days)*24*time.Hour, e
+  }
+  if err != nil || d <= 0 || d%time.Hour != 0 {
+    return 0, fmt.Errorf("Retention is a whole number of hours (168h) or days (7d), not %s", value)
+  }
+  return d, nil
+}
+
+// Configure changes the stream to match the config, waiting for it to be
+// ACTIVE before each change that needs it to be.
+func (s *KinesisStream) Configure(c StreamConfig) (err error) {
+  if c.empty() {
+    return nil
+  }
+  if err = s.WaitForStatus("ACTIVE", activeTimeout, nil); err != nil {
+    return err
+  }
+  if len(c.Tags) > 0 {
+    tags := make(map[string]*string)
+    for key, value := range c.Tags {
+      tags[key] = aws.String(value)
+    }
+    if _, err = s.Service.AddTagsToStream(&kinesis.AddTagsToStreamInput{StreamName: aws.String(s.Name), Tags: tags}); err != nil {
+      return err
+    }
+  }
+  if len(c.Untag) > 0 {
+    keys := []*string{}
+    for _, key := range c.Untag {
+      keys = append(keys, aws.String(key))
+    }
+    if _, err = s.Service.RemoveTagsFromStream(&kinesis.RemoveTagsFromStreamInput{StreamName: aws.String(s.Name), TagKeys: keys}); err != nil {
+      return err
+    }
+  }
+  if c.Retention != 0 {
+    if err = s.SetRetention(c.Retention); err != nil {
+      return err
+    }
+  }
+  if c.Encryption != "" {
+    if err = s.WaitForStatus("ACTIVE", activeTimeout, nil); err != nil {
+      return err
+    }
+    err = s.SetEncryption(strings.TrimPrefix(c.Encryption, "kms:"))
+  }
+  return err
+}
+
+// SetRetention increases or decreases how long the stream keeps records.
+func (s *KinesisStream) SetRetention(retention time.Duration) (err error) {
+  sd, err := s.GetAWSDescription()
+  if err != nil {
+    return err
+  }
+  hours := aws.Long(int64(retention / time.Hour))
+  switch current := *sd.RetentionPeriodHours; {
+    case *hours > current:
+      _, err = s.Service.IncreaseStreamRetentionPeriod(&kinesis.IncreaseStreamRetentionPeriodInput{StreamName: aws.String(s.Name), RetentionPeriodHours: hours})
+    case *hours < current:
+      _, err = s.Service.DecreaseStreamRetentionPeriod(&kinesis.DecreaseStreamRetentionPeriodInput{StreamName: aws.String(s.Name), RetentionPeriodHours: hours})
+  }
+  return err
+}
+
+// SetEncryption starts encrypting the stream with a KMS key, or with
+// none stops encrypting it.
+func (s *KinesisStream) SetEncryption(keyID string) (err error) {
+  if keyID != "none" {
+    _, err = s.Service.StartStreamEncryption(&kinesis.StartStreamEncryptionInput{StreamName: aws.String(s.Name),
+      EncryptionType: aws.String("KMS"), KeyID: aws.String(keyID)})
+    return err
+  }
+  sd, err := s.GetAWSDescription()
+  if err != nil || stringValue(sd.EncryptionType) != "KMS" {
+    return err
+  }
+  _, err = s.Service.StopStreamEncryption(&kinesis.StopStreamEncryptionInput{StreamName: aws.String(s.Name),
+    EncryptionType: aws.String("KMS"), KeyID: sd.KeyID})
+  return err
+}
+
+// Tags lists all the stream's tags.
+func (s *KinesisStream) Tags() (tags map[string]string, err error) {
+  tags = make(map[string]string)
+  params := &kinesis.ListTagsForStreamInput{StreamName: aws.String(s.Name)}
+  for {
+    output, err := s.Service.ListTagsForStream(params)
+    if err != nil {
+      return tags, err
+    }
+    for _, tag := range output.Tags {
+      tags[*tag.Key] = stringValue(tag.Value)
+      params.ExclusiveStartTagKey = tag.Key
+    }
+    if output.HasMoreTags == nil || !*output.HasMoreTags || len(output.Tags) == 0 {
+      return tags, nil
+    }
+  }
+}
+
+// Write the stream's status, retention, encryption and tags.
+func writeStreamConfig(w io.Writer, s *KinesisStream) error {
+  sd, err := s.GetAWSDescription()
+  if err != nil {
+    return err
+  }
+  tags, err := s.Tags()
+  if err != nil {
+    return err
+  }
+  fmt.Fprintf(w, "%s\n", s.Name)
+  fmt.Fprintf(w, "%20s %s\n", "Stream Status:", stringValue(sd.StreamStatus))
+  if sd.RetentionPeriodHours != nil {
+    fmt.Fprintf(w, "%20s %dh\n", "Retention:", *sd.RetentionPeriodHours)
+  }
+  if encryption := encryptionString(sd); encryption != "" {
+    fmt.Fprintf(w, "%20s %s\n", "Encryption:", encryption)
+  }
+  if len(tags) > 0 {
+    fmt.Fprintf(w, "%20s %s\n", "Tags:", tagsString(tags))
+  }
+  return nil
+}
+
+// Create a stream and say so, waiting for it to be ACTIVE when asked.
+func createStream(w io.Writer, g *KinesisStreamGroup, name string, shards int64, config StreamConfig, wait bool) (*KinesisStream, error) {
+  s, err := g.CreateKinesisStream(name, shards, config)
+  if s == nil {
+    return s, err
+  }
+  fmt.Fprintf(w, "Created stream: %s with %d shards.\n", s.Name, shards)
+  if err != nil {
+    return s, err
+  }
+  if wait {
+    if err = waitForStream(w, s, "ACTIVE", activeTimeout); err != nil {
+      return s, err
+    }
+  }
+  if wait || !config.empty() {
+    err = writeStreamConfig(w, s)
+  }
+  return s, err
+}
+
+// Cutting the retention of a protected stream drops records, and
+// untagging it can unprotect it, so both need confirming.
+func checkUpdate(w io.Writer, s *KinesisStream, config StreamConfig) error {
+  if contains(config.Untag, protectedTag) {
+    return checkChange(w, s, "Untag")
+  }
+  if config.Retention == 0 {
+    return nil
+  }
+  sd, err := s.GetAWSDescription()
+  if err != nil || sd.RetentionPeriodHours == nil || int64(config.Retention/time.Hour) >= *sd.RetentionPeriodHours {
+    return err
+  }
+  return checkChange(w, s, "Cut the retention of")
+}
+
+// Change a stream's retention, tags or encryption and show the result,
+// waiting for it to be ACTIVE again when asked.
+func updateStream(w io.Writer, s *KinesisStream, config StreamConfig, wait bool) (err error) {
+  if config.empty() {
+    return fmt.Errorf("Nothing to update, give --retention, --tag, --untag or --encrypt")
+  }
+  if err = checkUpdate(w, s, config); err != nil {
+    return err
+  }
+  if err = s.Configure(config); err != nil {
+    return err
+  }
+  if wait {
+    if err = waitForStream(w, s, "ACTIVE", activeTimeout); err != nil {
+      return err
+    }
+  }
+  return writeStreamConfig(w, s)
+}
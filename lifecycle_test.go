@@ -0,0 +1,89 @@
+package main
+
+import (
+  "io/ioutil"
+  "testing"
+  "time"
+  . "github.com/smartystreets/goconvey/convey"
+)
+
+func TestLifecycle(t *testing.T) {
+
+  Convey("Given the command line form of a stream's config", t, func() {
+
+    Convey("Retention should take hours or days", func() {
+      c, err := NewStreamConfig("7d", nil, nil, "")
+      So(err, ShouldBeNil)
+      So(c.Retention, ShouldEqual, 168*time.Hour)
+      _, err = NewStreamConfig("90m", nil, nil, "")
+      So(err, ShouldNotBeNil)
+    })
+
+    Convey("Tags should be key=value, and encryption kms:<key> or none", func() {
+      c, err := NewStreamConfig("", []string{"team=data", "env="}, nil, "kms:alias/streams")
+      So(err, ShouldBeNil)
+      So(c.Tags, ShouldResemble, map[string]string{"team": "data", "env": ""})
+      _, err = NewStreamConfig("", []string{"team"}, nil, "")
+      So(err, ShouldNotBeNil)
+      _, err = NewStreamConfig("", nil, nil, "aes:key")
+      So(err, ShouldNotBeNil)
+    })
+  })
+
+  Convey("Given an interactive session on a fake Kinesis that takes a while to change", t, func() {
+    svc, s := newFakeStream("clicks", 1)
+    g := streamGroupFor(svc, s)
+    saved := statusPollInterval
+    statusPollInterval = 5 * time.Millisecond
+    defer func() { statusPollInterval = saved }()
+    svc.StateDelay = 20 * time.Millisecond
+
+    Convey("Create should set the shards, retention, tags and encryption", func() {
+      out := captureStdout(func() {
+        So(DoICommand("create orders --shards 3 --retention 168h --tag team=data --tag env=prod --encrypt kms:alias/streams --wait", g), ShouldBeNil)
+      })
+      So(out, ShouldContainSubstring, "Created stream: orders with 3 shards.")
+      So(out, ShouldContainSubstring, "Stream Status: ACTIVE")
+      So(out, ShouldContainSubstring, "Retention: 168h")
+      So(out, ShouldContainSubstring, "Encryption: KMS alias/streams")
+      So(out, ShouldContainSubstring, "Tags: env=prod, team=data")
+      shards, _ := g.Streams["orders"].GetShards()
+      So(shards, ShouldHaveLength, 3)
+    })
+
+    Convey("Create should say the stream was made even when configuring it fails", func() {
+      out := captureStdout(func() {
+        err := DoICommand("create orders --retention 10000h", g)
+        So(err, ShouldNotBeNil)
+        So(err.Error(), ShouldContainSubstring, "Created stream orders, but couldn't configure it")
+      })
+      So(out, ShouldContainSubstring, "Created stream: orders with")
+      So(g.Streams["orders"], ShouldNotBeNil)
+    })
+
+    Convey("Update should change retention either way, tags and encryption", func() {
+      captureStdout(func() {
+        So(DoICommand("update --retention 48h --tag team=data --tag old=yes --encrypt kms:key-1 --wait", g), ShouldBeNil)
+        So(DoICommand("update --retention 24h --untag old --encrypt none --wait", g), ShouldBeNil)
+        So(DoICommand("update", g), ShouldNotBeNil)
+      })
+      sd, _ := s.GetAWSDescription()
+      So(*sd.RetentionPeriodHours, ShouldEqual, 24)
+      So(*sd.EncryptionType, ShouldEqual, "NONE")
+      tags, err := s.Tags()
+      So(err, ShouldBeNil)
+      So(tags, ShouldResemble, map[string]string{"team": "data"})
+    })
+
+    Convey("Tags should be listed across pages", func() {
+      config := StreamConfig{Tags: map[string]string{}}
+      for _, key := range []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j", "k", "l"} {
+        config.Tags[key] = key
+      }
+      So(updateStream(ioutil.Discard, s, config, false), ShouldBeNil)
+      tags, err := s.Tags()
+      So(err, ShouldBeNil)
+      So(tags, ShouldHaveLength, 12)
+    })
+  })
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+  "encoding/json"
+  "fmt"
+  "github.com/aws/aws-sdk-go/aws"
+  "github.com/aws/aws-sdk-go/aws/awserr"
+  "github.com/aws/aws-sdk-go/service/kinesis"
+  "gopkg.in/yaml.v2"
+  "io"
+  "math/rand"
+  "sort"
+  "strings"
+  "sync"
+  "text/tabwriter"
+  "time"
+)
+
+// How many streams are described at once. DescribeStream is limited to
+// 10 calls a second an account, throttled calls are tried again.
+var (
+  describeWorkers    = 10
+  describeBackoff    = 100 * time.Millisecond
+  describeMaxBackoff = 2 * time.Second
+  describeAttempts   = 8
+)
+
+var (
+  listOutputFormats = []string{"table", "json", "yaml"}
+  listSortColumns   = []string{"name", "shards", "retention", "encryption", "status", "tags"}
+)
+
+// StreamSummary is a stream as list shows it. Shards counts the open shards.
+type StreamSummary struct {
+  Name       string            `json:"name" yaml:"name"`
+  ARN        string            `json:"arn" yaml:"arn"`
+  Status     string            `json:"status" yaml:"status"`
+  Shards     int               `json:"shards" yaml:"shards"`
+  Retention  int64             `json:"retentionHours" yaml:"retention-hours"`
+  Encryption string            `json:"encryption" yaml:"encryption"`
+  Tags       map[string]string `json:"tags" yaml:"tags"`
+}
+
+// ListQuery picks streams by name prefix and status, the zero value picks them all.
+type ListQuery struct {
+  Prefix string
+  Status string
+}
+
+// StreamNames lists the names of the streams that start with prefix, across pages.
+func (g *KinesisStreamGroup) StreamNames(prefix string) (names []string, err error) {
+  params := &kinesis.ListStreamsInput{}
+  for {
+    output, err := g.Service.ListStreams(params)
+    if err != nil {
+      return names, err
+    }
+    for _, name := range output.StreamNames {
+      if strings.HasPrefix(*name, prefix) {
+        names = append(names, *name)
+      }
+    }
+
+    // Carry on after the last stream we were given.
+    if output.HasMoreStreams == nil || !*output.HasMoreStreams || len(output.StreamNames) == 0 {
+      return names, nil
+    }
+    params.ExclusiveStartStreamName = output.StreamNames[len(output.StreamNames)-1]
+  }
+}
+
+// Inventory describes the streams the query picks, several at a time,
+// in the order they are listed. Streams deleted while it runs are left out.
+func (g *KinesisStreamGroup) Inventory(q ListQuery) ([]*StreamSummary, error) {
+  names, err := g.StreamNames(q.Prefix)
+  if err != nil {
+    return nil, err
+  }
+  summaries := make([]*StreamSummary, len(names))
+  err = describeConcurrently(len(names), func(i int) (err error) {
+    s := NewStreamWithService(g.Service, names[i], "", "", "")
+    summaries[i], err = s.summary(q.Status)
+    if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == "ResourceNotFoundException" {
+      return nil
+    }
+    return err
+  })
+  if err != nil {
+    return nil, err
+  }
+  picked := []*StreamSummary{}
+  for _, summary := range summaries {
+    if summary != nil {
+      picked = append(picked, summary)
+    }
+  }
+  return picked, nil
+}
+
+// The stream's summary, or nil if it doesn't have the status.
+func (s *KinesisStream) summary(status string) (*StreamSummary, error) {
+  var sd *kinesis.StreamDescription
+  var shards []*kinesis.Shard
+  params := &kinesis.DescribeStreamInput{StreamName: aws.String(s.Name)}
+  for {
+    var res *kinesis.DescribeStreamOutput
+    err := retryThrottled(func() (err error) {
+      res, err = s.Service.DescribeStream(params)
+      return err
+    })
+    if err != nil {
+      return nil, err
+    }
+    sd = res.StreamDescription
+    if status != "" && !strings.EqualFold(stringValue(sd.StreamStatus), status) {
+      return nil, nil
+    }
+    shards = append(shards, sd.Shards...)
+    if len(sd.Shards) == 0 || sd.HasMoreShards == nil || !*sd.HasMoreShards {
+      break
+    }
+    params.ExclusiveStartShardID = shards[len(shards)-1].ShardID
+  }
+
+  summary := &StreamSummary{Name: s.Name, ARN: stringValue(sd.StreamARN), Status: stringValue(sd.StreamStatus),
+    Encryption: encryptionString(sd)}
+  for _, shard := range shards {
+    if shardOpen(shard) {
+      summary.Shards++
+    }
+  }
+  if sd.RetentionPeriodHours != nil {
+    summary.Retention = *sd.RetentionPeriodHours
+  }
+  err := retryThrottled(func() (err error) {
+    summary.Tags, err = s.Tags()
+    return err
+  })
+  return summary, err
+}
+
+// Run describe for each of n streams, describeWorkers at a time, and
+// return the first error.
+func describeConcurrently(n int, describe func(i int) error) error {
+  errs := make([]error, n)
+  next := make(chan int)
+  var wg sync.WaitGroup
+  for w := 0; w < describeWorkers; w++ {
+    wg.Add(1)
+    go func() {
+      defer wg.Done()
+      for i := range next {
+        errs[i] = describe(i)
+      }
+    }()
+  }
+  for i := 0; i < n; i++ {
+    next <- i
+  }
+  close(next)
+  wg.Wait()
+  for _, err := range errs {
+    if err != nil {
+      return err
+    }
+  }
+  return nil
+}
+
+// List the streams the query picks, sorted on a column, as a table, json or yaml.
+func listStreams(w io.Writer, g *KinesisStreamGroup, q ListQuery, column string, reverse bool, format string) error {
+  streams, err := g.Inventory(q)
+  if err != nil {
+    return err
+  }
+  if err = sortStreams(streams, column, reverse); err != nil {
+    return err
+  }
+  return writeStreams(w, streams, format)
+}
+
+// Make a call, backing off and trying again while it's throttled.
+func retryThrottled(call func() error) (err error) {
+  d := describeBackoff
+  for attempt := 1; ; attempt++ {
+    if err = call(); !isThrottled(err) || attempt == describeAttempts {
+      return err
+    }
+    time.Sleep(d/2 + time.Duration(rand.Int63n(int64(d/2)+1)))
+    if d *= 2; d > describeMaxBackoff {
+      d = describeMaxBackoff
+    }
+  }
+}
+
+// Sort the streams on a column, then by name.
+func sortStreams(streams []*StreamSummary, column string, reverse bool) error {
+  var less func(a, b *StreamSummary) bool
+  switch column {
+    case "", "name": less = func(a, b *StreamSummary) bool { return false }
+    case "shards": less = func(a, b *StreamSummary) bool { return a.Shards < b.Shards }
+    case "retention": less = func(a, b *StreamSummary) bool { return a.Retention < b.Retention }
+    case "encryption": less = func(a, b *StreamSummary) bool { return a.Encryption < b.Encryption }
+    case "status": less = func(a, b *StreamSummary) bool { return a.Status < b.Status }
+    case "tags": less = func(a, b *StreamSummary) bool { return tagsString(a.Tags) < tagsString(b.Tags) }
+    default: return fmt.Errorf("Can't sort on %s, sort on one of %v", column, listSortColumns)
+  }
+  sort.SliceStable(streams, func(i, j int) bool {
+    a, b := streams[i], streams[j]
+    if reverse {
+      a, b = b, a
+    }
+    if less(a, b) || less(b, a) {
+      return less(a, b)
+    }
+    return a.Name < b.Name
+  })
+  return nil
+}
+
+// Write the streams as a table, or as json or yaml with their ARNs.
+func writeStreams(w io.Writer, streams []*StreamSummary, format string) error {
+  switch format {
+    case "json":
+      data, err := json.MarshalIndent(streams, "", "  ")
+      if err != nil {
+        return err
+      }
+      _, err = fmt.Fprintf(w, "%s\n", data)
+      return err
+    case "yaml":
+      data, err := yaml.Marshal(streams)
+      if err != nil {
+        return err
+      }
+      _, err = w.Write(data)
+      return err
+    case "", "table":
+      tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+      fmt.Fprintln(tw, "NAME\tSTATUS\tSHARDS\tRETENTION\tENCRYPTION\tTAGS")
+      for _, s := range streams {
+        fmt.Fprintf(tw, "%s\t%s\t%d\t%dh\t%s\t%s\n", s.Name, s.Status, s.Shards, s.Retention, s.Encryption, tagsString(s.Tags))
+      }
+      tw.Flush()
+      _, err := fmt.Fprintf(w, "%d streams.\n", len(streams))
+      return err
+  }
+  return fmt.Errorf("Can't write streams as %s, use one of %v", format, listOutputFormats)
+}
+
+// The stream's encryption type, with the key when there is one.
+func encryptionString(sd *kinesis.StreamDescription) string {
+  encryption := stringValue(sd.EncryptionType)
+  if sd.KeyID != nil {
+    encryption += " " + *sd.KeyID
+  }
+  return encryption
+}
+
+// Tags as key=value, sorted by key.
+func tagsString(tags map[string]string) string {
+  keys := []string{}
+  for key := range tags {
+    keys = append(keys, key)
+  }
+  sort.Strings(keys)
+  for i, key := range keys {
+    keys[i] = key + "=" + tags[key]
+  }
+  return strings.Join(keys, ", ")
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+  "bytes"
+  "encoding/json"
+  "fmt"
+  "strings"
+  "testing"
+  "time"
+  "github.com/aws/aws-sdk-go/aws"
+  "github.com/aws/aws-sdk-go/service/kinesis"
+  "gopkg.in/yaml.v2"
+  . "github.com/smartystreets/goconvey/convey"
+)
+
+func TestList(t *testing.T) {
+
+  Convey("Given a fake Kinesis with a few streams", t, func() {
+    svc := NewFakeKinesis()
+    svc.ListStreamsLimit = 3
+    for name, shards := range map[string]int64{"clicks-eu": 4, "clicks-us": 2, "orders": 1, "clicks-old": 1} {
+      svc.CreateStream(&kinesis.CreateStreamInput{StreamName: aws.String(name), ShardCount: aws.Long(shards)})
+    }
+    svc.IncreaseStreamRetentionPeriod(&kinesis.IncreaseStreamRetentionPeriodInput{StreamName: aws.String("clicks-us"), RetentionPeriodHours: aws.Long(168)})
+    svc.AddTagsToStream(&kinesis.AddTagsToStreamInput{StreamName: aws.String("clicks-eu"), Tags: map[string]*string{"team": aws.String("web")}})
+    svc.StateDelay = time.Hour
+    svc.DeleteStream(&kinesis.DeleteStreamInput{StreamName: aws.String("clicks-old")})
+    g := &KinesisStreamGroup{Streams: make(map[string]*KinesisStream), Service: svc, Region: "us-west-1"}
+
+    Convey("Streams should be picked by prefix and status", func() {
+      streams, err := g.Inventory(ListQuery{Prefix: "clicks-", Status: "active"})
+      So(err, ShouldBeNil)
+      So(streams, ShouldHaveLength, 2)
+      So(streams[0].Name, ShouldEqual, "clicks-eu")
+      So(streams[0].Shards, ShouldEqual, 4)
+      So(streams[0].Tags, ShouldResemble, map[string]string{"team": "web"})
+      So(streams[1].Retention, ShouldEqual, 168)
+      streams, _ = g.Inventory(ListQuery{Status: "DELETING"})
+      So(streams, ShouldHaveLength, 1)
+    })
+
+    Convey("Throttled describes should be tried again", func() {
+      saved := describeBackoff
+      describeBackoff = time.Millisecond
+      defer func() { describeBackoff = saved }()
+      svc.DescribeThrottle = 5
+      streams, err := g.Inventory(ListQuery{})
+      So(err, ShouldBeNil)
+      So(streams, ShouldHaveLength, 4)
+      So(svc.DescribeThrottle, ShouldEqual, 0)
+    })
+
+    Convey("Streams should sort on a column, then by name", func() {
+      streams, _ := g.Inventory(ListQuery{})
+      So(sortStreams(streams, "shards", true), ShouldBeNil)
+      var names []string
+      for _, s := range streams {
+        names = append(names, s.Name)
+      }
+      So(names, ShouldResemble, []string{"clicks-eu", "clicks-us", "orders", "clicks-old"})
+      So(sortStreams(streams, "size", false), ShouldNotBeNil)
+    })
+
+    Convey("Streams should list as a table, json or yaml", func() {
+      var table, js, ym bytes.Buffer
+      So(listStreams(&table, g, ListQuery{Prefix: "clicks-u"}, "name", false, "table"), ShouldBeNil)
+      So(listStreams(&js, g, ListQuery{Prefix: "clicks-u"}, "name", false, "json"), ShouldBeNil)
+      So(listStreams(&ym, g, ListQuery{Prefix: "clicks-u"}, "name", false, "yaml"), ShouldBeNil)
+      lines := strings.Split(table.String(), "\n")
+      So(strings.Fields(lines[0]), ShouldResemble, []string{"NAME", "STATUS", "SHARDS", "RETENTION", "ENCRYPTION", "TAGS"})
+      So(strings.Fields(lines[1]), ShouldResemble, []string{"clicks-us", "ACTIVE", "2", "168h", "NONE"})
+      So(lines[2], ShouldEqual, "1 streams.")
+
+      var fromJSON, fromYAML []StreamSummary
+      So(json.Unmarshal(js.Bytes(), &fromJSON), ShouldBeNil)
+      So(yaml.Unmarshal(ym.Bytes(), &fromYAML), ShouldBeNil)
+      So(fromJSON, ShouldResemble, fromYAML)
+      So(fromJSON[0].ARN, ShouldEndWith, ":stream/clicks-us")
+    })
+  })
+
+  Convey("Given hundreds of streams", t, func() {
+    svc := NewFakeKinesis()
+    for i := 0; i < 300; i++ {
+      svc.CreateStream(&kinesis.CreateStreamInput{StreamName: aws.String(fmt.Sprintf("stream-%03d", i)), ShardCount: aws.Long(1)})
+    }
+    g := &KinesisStreamGroup{Streams: make(map[string]*KinesisStream), Service: svc, Region: "us-west-1"}
+
+    Convey("Every one should be described, in the interactive list too", func() {
+      out := captureStdout(func() {
+        So(DoICommand("list --prefix stream-1 --sort shards --reverse", g), ShouldBeNil)
+        So(DoICommand("list group", g), ShouldBeNil)
+      })
+      So(out, ShouldContainSubstring, "100 streams.")
+      So(out, ShouldContainSubstring, "The local group is")
+    })
+  })
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+  "bytes"
+  "context"
+  "fmt"
+  "github.com/aws/aws-sdk-go/aws"
+  "github.com/aws/aws-sdk-go/service/kinesis"
+  "math/rand"
+  "sort"
+  "strconv"
+  "strings"
+  "sync"
+  "sync/atomic"
+  "text/template"
+  "time"
+)
+
+// LoadOptions describe the load to put on a stream with RunLoad.
+type LoadOptions struct {
+  Rate     float64       // Records per second.
+  Duration time.Duration
+  Size     int           // Bytes in each random payload.
+  Workers  int
+  Template string        // When set, payloads are made with this text/template instead of at random.
+  Progress func(*LoadReport)
+}
+
+// A LoadReport sums up what RunLoad managed to put.
+type LoadReport struct {
+  Records   int64
+  Bytes     int64
+  Throttled int64
+  Errors    int64
+  Elapsed   time.Duration
+  Latencies []time.Duration // Of successful puts, sorted once RunLoad returns.
+}
+
+// The fields available to a payload template.
+type LoadTemplateData struct {
+  Seq    int64
+  Worker int
+  Time   string
+  Unix   int64
+}
+
+// RunLoad puts records on the stream at opts.Rate, spread across opts.Workers
+// goroutines each calling PutRecord, until opts.Duration passes or ctx is done.
+// Partition keys come from the stream's partition strategy, the payloads are
+// sent without an envelope so they are exactly the size asked for.
+func (s *KinesisStream) RunLoad(ctx context.Context, opts LoadOptions) (*LoadReport, error) {
+  payloads, err := newPayloadMaker(opts)
+  if err != nil {
+    return nil, err
+  }
+  if opts.Workers < 1 {
+    opts.Workers = 1
+  }
+  if opts.Duration > 0 {
+    var cancel context.CancelFunc
+    ctx, cancel = context.WithTimeout(ctx, opts.Duration)
+    defer cancel()
+  }
+
+  report := &LoadReport{}
+  var mu sync.Mutex
+  var seq int64
+  bucket := newTokenBucket(opts.Rate)
+  start := time.Now()
+
+  if opts.Progress != nil {
+    go func() {
+      ticker := time.NewTicker(time.Second)
+      defer ticker.Stop()
+      for {
+        select {
+          case <-ticker.C:
+            mu.Lock()
+            progress := *report
+            mu.Unlock()
+            progress.Elapsed = time.Since(start)
+            opts.Progress(&progress)
+          case <-ctx.Done(): return
+        }
+      }
+    }()
+  }
+
+  var wg sync.WaitGroup
+  for w := 0; w < opts.Workers; w++ {
+    wg.Add(1)
+    go func(worker int) {
+      defer wg.Done()
+      for bucket.Take(ctx) {
+        data := payloads(worker, atomic.AddInt64(&seq, 1))
+        partitionKey, explicitHashKey := s.partitionKey(string(data))
+        began := time.Now()
+        _, err := s.Service.PutRecord(&kinesis.PutRecordInput{
+          Data: data,
+          PartitionKey: aws.String(partitionKey),
+          ExplicitHashKey: explicitHashKey,
+          StreamName: aws.String(s.Name),
+        })
+        latency := time.Since(began)
+
+        mu.Lock()
+        switch {
+          case err == nil:
+            report.Records++
+            report.Bytes += int64(len(data))
+            report.Latencies = append(report.Latencies, latency)
+          case isThrottled(err):
+            report.Throttled++
+          default:
+            report.Errors++
+        }
+        mu.Unlock()
+      }
+    }(w)
+  }
+  wg.Wait()
+
+  report.Elapsed = time.Since(start)
+  sort.Sort(byDuration(report.Latencies))
+  return report, nil
+}
+
+// Percentile of the successful put latencies, p between 0 and 100.
+func (r *LoadReport) Percentile(p float64) time.Duration {
+  if len(r.Latencies) == 0 {
+    return 0
+  }
+  i := int(float64(len(r.Latencies)-1) * p / 100.0 + 0.5)
+  return r.Latencies[i]
+}
+
+func (r *LoadReport) String() string {
+  seconds := r.Elapsed.Seconds()
+  if seconds <= 0 {
+    seconds = 1
+  }
+  return fmt.Sprintf("%d records, %s in %s: %.1f records/s, %s/s, %d throttled, %d errors",
+    r.Records, fmtBytes(float64(r.Bytes)), r.Elapsed.Round(time.Millisecond),
+    float64(r.Records)/seconds, fmtBytes(float64(r.Bytes)/seconds), r.Throttled, r.Errors)
+}
+
+func (r *LoadReport) Description() string {
+  return r.String() + "\n" +
+    fmt.Sprintf("Put latency p50: %s p95: %s p99: %s max: %s\n",
+      r.Percentile(50), r.Percentile(95), r.Percentile(99), r.Percentile(100))
+}
+
+type byDuration []time.Duration
+
+func (b byDuration) Len() int           { return len(b) }
+func (b byDuration) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byDuration) Less(i, j int) bool { return b[i] < b[j] }
+
+// Makes the payload for a worker's next record.
+func newPayloadMaker(opts LoadOptions) (func(worker int, seq int64) []byte, error) {
+  if opts.Template == "" {
+    size := opts.Size
+    if size < 1 {
+      size = 1
+    }
+    return func(worker int, seq int64) []byte { return randomPayload(size) }, nil
+  }
+
+  t, err := template.New("payload").Funcs(template.FuncMap{
+    "random": func(n int) string { return string(randomPayload(n)) },
+  }).Parse(opts.Template)
+  if err != nil {
+    return nil, err
+  }
+  return func(worker int, seq int64) []byte {
+    var buf bytes.Buffer
+    now := time.Now().UTC()
+    if err := t.Execute(&buf, &LoadTemplateData{Seq: seq, Worker: worker, Time: now.Format(time.RFC3339Nano), Unix: now.Unix()}); err != nil {
+      return []byte(err.Error())
+    }
+    return buf.Bytes()
+  }, nil
+}
+
+const payloadLetters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+func randomPayload(size int) []byte {
+  data := make([]byte, size)
+  for i := range data {
+    data[i] = payloadLetters[rand.Intn(len(payloadLetters))]
+  }
+  return data
+}
+
+// A token bucket rate limiter, tokens are added at rate per second
+// up to a burst of a tenth of a second's worth.
+type tokenBucket struct {
+  mu     sync.Mutex
+  rate   float64
+  burst  float64
+  tokens float64
+  last   time.Time
+}
+
+// A rate of zero or less is unlimited.
+func newTokenBucket(rate float64) *tokenBucket {
+  burst := rate / 10
+  if burst < 1 {
+    burst = 1
+  }
+  return &tokenBucket{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// Take waits for a token, returning false if ctx is done first.
+func (b *tokenBucket) Take(ctx context.Context) bool {
+  for {
+    if ctx.Err() != nil {
+      return false
+    }
+    if b.rate <= 0 {
+      return true
+    }
+
+    b.mu.Lock()
+    now := time.Now()
+    b.tokens += now.Sub(b.last).Seconds() * b.rate
+    if b.tokens > b.burst {
+      b.tokens = b.burst
+    }
+    b.last = now
+    if b.tokens >= 1 {
+      b.tokens--
+      b.mu.Unlock()
+      return true
+    }
+    wait := time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+    b.mu.Unlock()
+
+    select {
+      case <-time.After(wait):
+      case <-ctx.Done(): return false
+    }
+  }
+}
+
+// parseRate reads a rate like 2000/s, 500/m or 10000/h as records per second,
+// a bare number is per second.
+func parseRate(value string) (float64, error) {
+  per := time.Second
+  number := value
+  if i := strings.Index(value, "/"); i >= 0 {
+    number = value[:i]
+    switch strings.ToLower(value[i+1:]) {
+      case "s", "sec", "second": per = time.Second
+      case "m", "min", "minute": per = time.Minute
+      case "h", "hour": per = time.Hour
+      default: return 0, fmt.Errorf("Couldn't understand the rate \"%s\", try something like 2000/s", value)
+    }
+  }
+  n, err := strconv.ParseFloat(strings.TrimSpace(number), 64)
+  if err != nil || n < 0 {
+    return 0, fmt.Errorf("Couldn't understand the rate \"%s\", try something like 2000/s", value)
+  }
+  return n / per.Seconds(), nil
+}
+
+// parseSize reads a size like 512, 512B, 1KB or 1MB as bytes.
+func parseSize(value string) (int, error) {
+  units := []struct {
+    suffix string
+    bytes  int
+  }{{"MB", 1024 * 1024}, {"KB", 1024}, {"K", 1024}, {"M", 1024 * 1024}, {"B", 1}}
+  number, multiplier := strings.ToUpper(strings.TrimSpace(value)), 1
+  for _, unit := range units {
+    if strings.HasSuffix(number, unit.suffix) {
+      number, multiplier = strings.TrimSpace(strings.TrimSuffix(number, unit.suffix)), unit.bytes
+      break
+    }
+  }
+  n, err := strconv.ParseFloat(number, 64)
+  if err != nil || n <= 0 {
+    return 0, fmt.Errorf("Couldn't understand the size \"%s\", try something like 1KB", value)
+  }
+  return int(n * float64(multiplier)), nil
+}
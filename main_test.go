@@ -1,28 +1,36 @@
 package main
 
 import (
+  "bytes"
+  "context"
+  "encoding/json"
+  "fmt"
+  "os"
+  "strings"
   "testing"
+  "time"
 	"github.com/aws/aws-sdk-go/aws"
+  "github.com/aws/aws-sdk-go/service/kinesis"
   . "github.com/smartystreets/goconvey/convey"
 )
 
 func TestSpec(t *testing.T) {
 
-  Convey("Given the region is set in the aws.DefaultConfig", t, func() {
+  Convey("Given a region and a Kinesis service", t, func() {
     testRegion := "us-west-1"
-    aws.DefaultConfig.Region = "us-west-1"
+    svc := NewFakeKinesis()
 
     Convey("When a new Test group is created", func() {
-      g, err := NewStreamGroup(aws.DefaultConfig)
+      g, err := NewStreamGroupWithService(svc, testRegion)
 
-      Convey("The new groups region should be the same as the aws.DefultConfig", func() {
+      Convey("The new groups region should be the one it was given", func() {
         So(g.Region, ShouldEqual, testRegion)
         So(err, ShouldBeNil)
       }) 
     })
 
     Convey("When a new Stream is created", func() {
-      s := NewStream(aws.DefaultConfig, "TestStream", "", "", "")
+      s := NewStreamWithService(svc, "TestStream", "", "", "")
       Convey("The new stream should have the correct name.", func() {
         So(s.Name, ShouldEqual, "TestStream")
       })
@@ -30,3 +38,251 @@ func TestSpec(t *testing.T) {
   })
 }
 
+func TestStartingPosition(t *testing.T) {
+
+  Convey("Given a time to start reading from", t, func() {
+
+    Convey("An absolute time without seconds should parse as UTC", func() {
+      ts, err := parseTimestamp("2026-10-18T10:00Z")
+      So(err, ShouldBeNil)
+      So(ts.Equal(time.Date(2026, 10, 18, 10, 0, 0, 0, time.UTC)), ShouldBeTrue)
+    })
+
+    Convey("A duration should be taken as that long ago", func() {
+      ts, err := parseTimestamp("30m")
+      So(err, ShouldBeNil)
+      So(time.Since(ts), ShouldAlmostEqual, 30*time.Minute, time.Second)
+    })
+
+    Convey("Nonsense should be an error", func() {
+      _, err := parseTimestamp("yesterday-ish")
+      So(err, ShouldNotBeNil)
+    })
+  })
+
+  Convey("Given a stream", t, func() {
+    s := NewStream(aws.DefaultConfig, "TestStream", "", "LATEST", "")
+
+    Convey("AT_SEQUENCE_NUMBER without a sequence number should be an error", func() {
+      So(s.SetStartingPosition("AT_SEQUENCE_NUMBER", "", time.Time{}), ShouldNotBeNil)
+    })
+
+    Convey("AFTER_SEQUENCE_NUMBER should keep the sequence number", func() {
+      So(s.SetStartingPosition("AFTER_SEQUENCE_NUMBER", "4955", time.Time{}), ShouldBeNil)
+      So(s.ShardIteratorType, ShouldEqual, "AFTER_SEQUENCE_NUMBER")
+      So(s.StartingSequenceNumber, ShouldEqual, "4955")
+    })
+
+    Convey("The interactive since read type should set AT_TIMESTAMP", func() {
+      tail, err := configureStartingPosition(s, "since", "2026-10-18T10:00Z")
+      So(err, ShouldBeNil)
+      So(tail, ShouldBeFalse)
+      So(s.ShardIteratorType, ShouldEqual, "AT_TIMESTAMP")
+      So(s.StartingTimestamp.Year(), ShouldEqual, 2026)
+    })
+  })
+}
+
+func TestOrderByArrival(t *testing.T) {
+
+  Convey("Given records from several shards arriving out of order", t, func() {
+    now := time.Now()
+    in := make(chan *ShardRecord, 3)
+    for i, offset := range []int{2, 0, 1} {
+      arrival := now.Add(time.Duration(offset) * time.Second)
+      data := fmt.Sprintf("record %d", offset)
+      in <- &ShardRecord{ShardID: fmt.Sprintf("shardId-%012d", i), Record: &kinesis.Record{Data: []byte(data), ApproximateArrivalTimestamp: &arrival}}
+    }
+    close(in)
+
+    Convey("They should come out in arrival order", func() {
+      var got []string
+      for r := range orderByArrival(in, 0) {
+        got = append(got, string(r.Record.Data))
+      }
+      So(got, ShouldResemble, []string{"record 0", "record 1", "record 2"})
+    })
+  })
+}
+
+func TestRecordWriter(t *testing.T) {
+
+  Convey("Given a record read from a shard", t, func() {
+    arrival := time.Date(2026, 10, 18, 10, 0, 0, 0, time.UTC)
+    record := &kinesis.Record{Data: []byte("hello"), PartitionKey: aws.String("key"),
+      SequenceNumber: aws.String("4955"), ApproximateArrivalTimestamp: &arrival}
+    var buf bytes.Buffer
+
+    Convey("jsonl should carry the metadata and the data", func() {
+      So(NewRecordWriter(&buf, "clicks", "jsonl", "raw").Write("shardId-000000000001", record), ShouldBeNil)
+      So(buf.String(), ShouldEqual, `{"stream":"clicks","shard_id":"shardId-000000000001","partition_key":"key",`+
+        `"sequence_number":"4955","approximate_arrival_timestamp":"2026-10-18T10:00:00Z","data_encoding":"raw","data":"hello"}`+"\n")
+    })
+
+    Convey("csv should have a header and base64 data when asked", func() {
+      So(NewRecordWriter(&buf, "clicks", "csv", "base64").Write("shardId-000000000001", record), ShouldBeNil)
+      So(buf.String(), ShouldEqual, "stream,shard_id,partition_key,sequence_number,approximate_arrival_timestamp,data_encoding,data\n"+
+        "clicks,shardId-000000000001,key,4955,2026-10-18T10:00:00Z,base64,aGVsbG8=\n")
+    })
+
+    Convey("text should be just the data", func() {
+      So(NewRecordWriter(&buf, "clicks", "text", "raw").Write("shardId-000000000001", record), ShouldBeNil)
+      So(buf.String(), ShouldEqual, "hello\n")
+    })
+  })
+}
+
+func TestPartitionStrategy(t *testing.T) {
+
+  Convey("Given a static partition to fall back on", t, func() {
+    const partition = "PARTITION"
+
+    Convey("The json strategy should use a nested field", func() {
+      p, err := NewPartitionStrategy("json:user.id", partition, nil)
+      So(err, ShouldBeNil)
+      key, hashKey := p.PartitionKey(`{"user": {"id": 42}}`)
+      So(key, ShouldEqual, "42")
+      So(hashKey, ShouldEqual, "")
+
+      Convey("And fall back when the field is missing", func() {
+        key, _ := p.PartitionKey(`{"user": {}}`)
+        So(key, ShouldEqual, partition)
+      })
+    })
+
+    Convey("The regex strategy should use the first capture group", func() {
+      p, err := NewPartitionStrategy(`regex:customer=(\w+)`, partition, nil)
+      So(err, ShouldBeNil)
+      key, _ := p.PartitionKey("GET /cart customer=acme status=200")
+      So(key, ShouldEqual, "acme")
+
+      Convey("And fall back when the key is too long for Kinesis", func() {
+        key, _ := p.PartitionKey("customer=" + strings.Repeat("a", 257))
+        So(key, ShouldEqual, partition)
+      })
+    })
+
+    Convey("Round robin shouldn't look up the shards for every line when it can't", func() {
+      svc, s := newFakeStream("clicks", 2)
+      svc.DescribeThrottle = 10
+      p, _ := NewPartitionStrategy("round-robin", partition, s)
+      for i := 0; i < 5; i++ {
+        key, hashKey := p.PartitionKey("a line")
+        So(key, ShouldEqual, partition)
+        So(hashKey, ShouldEqual, "")
+      }
+      So(svc.DescribeThrottle, ShouldEqual, 9)
+    })
+
+    Convey("Round robin should follow the shards when they're split", func() {
+      svc, s := newFakeStream("clicks", 1)
+      p, _ := NewPartitionStrategy("round-robin", partition, s)
+      _, before := p.PartitionKey("a line")
+      svc.SplitShard(&kinesis.SplitShardInput{StreamName: aws.String("clicks"), ShardToSplit: aws.String("shardId-000000000000"),
+        NewStartingHashKey: aws.String("170141183460469231731687303715884105728")})
+      saved := shardRefreshInterval
+      shardRefreshInterval = 0
+      defer func() { shardRefreshInterval = saved }()
+      hashKeys := map[string]bool{}
+      for i := 0; i < 4; i++ {
+        _, hashKey := p.PartitionKey("a line")
+        hashKeys[hashKey] = true
+      }
+      So(hashKeys, ShouldHaveLength, 2)
+      So(hashKeys[before], ShouldBeTrue)
+      So(hashKeys["170141183460469231731687303715884105728"], ShouldBeTrue)
+    })
+
+    Convey("The hash strategy should give the same line the same key", func() {
+      p, _ := NewPartitionStrategy("hash", partition, nil)
+      a, _ := p.PartitionKey("a line")
+      b, _ := p.PartitionKey("a line")
+      So(a, ShouldEqual, b)
+      So(len(a), ShouldEqual, 32)
+    })
+
+    Convey("An unknown strategy should be an error", func() {
+      _, err := NewPartitionStrategy("sideways", partition, nil)
+      So(err, ShouldNotBeNil)
+    })
+  })
+}
+
+func TestEnvelope(t *testing.T) {
+
+  Convey("Given a line and where it came from", t, func() {
+    line := "GET /index.html 200"
+    source := &LineSource{File: "access.log", Line: 7}
+
+    for _, format := range envelopeFormats {
+      format := format
+      Convey("The "+format+" envelope should unwrap to the line", func() {
+        e, err := NewEnvelope(format, "RFC3339")
+        So(err, ShouldBeNil)
+        unwrapped, found := UnwrapRecord(e.Wrap(line, source))
+        So(unwrapped, ShouldEqual, line)
+        So(found, ShouldEqual, format)
+      })
+    }
+
+    Convey("The json envelope should carry the source", func() {
+      e, _ := NewEnvelope("json", "")
+      var envelope JSONEnvelope
+      So(json.Unmarshal(e.Wrap(line, source), &envelope), ShouldBeNil)
+      So(envelope.Source, ShouldEqual, "access.log")
+      So(envelope.Line, ShouldEqual, 7)
+      So(envelope.PID, ShouldEqual, os.Getpid())
+    })
+
+    Convey("JSON that isn't an envelope should be left alone", func() {
+      unwrapped, found := UnwrapRecord([]byte(`{"data": "not ours"}`))
+      So(unwrapped, ShouldEqual, `{"data": "not ours"}`)
+      So(found, ShouldEqual, "raw")
+    })
+  })
+}
+
+func TestLoad(t *testing.T) {
+
+  Convey("Given load generator settings", t, func() {
+
+    Convey("Rates should be read per second", func() {
+      for value, expected := range map[string]float64{"2000/s": 2000, "120/m": 2, "7200/h": 2, "50": 50} {
+        rate, err := parseRate(value)
+        So(err, ShouldBeNil)
+        So(rate, ShouldEqual, expected)
+      }
+      _, err := parseRate("fast/s")
+      So(err, ShouldNotBeNil)
+    })
+
+    Convey("Sizes should be read as bytes", func() {
+      for value, expected := range map[string]int{"1KB": 1024, "512B": 512, "2MB": 2 * 1024 * 1024, "100": 100} {
+        size, err := parseSize(value)
+        So(err, ShouldBeNil)
+        So(size, ShouldEqual, expected)
+      }
+    })
+
+    Convey("Percentiles should come from the sorted latencies", func() {
+      r := &LoadReport{}
+      for i := 1; i <= 100; i++ {
+        r.Latencies = append(r.Latencies, time.Duration(i)*time.Millisecond)
+      }
+      So(r.Percentile(50), ShouldEqual, 51*time.Millisecond)
+      So(r.Percentile(99), ShouldEqual, 99*time.Millisecond)
+      So(r.Percentile(100), ShouldEqual, 100*time.Millisecond)
+    })
+
+    Convey("The token bucket should hold to its rate", func() {
+      b := newTokenBucket(200)
+      ctx, cancel := context.WithTimeout(context.Background(), 250*time.Millisecond)
+      defer cancel()
+      taken := 0
+      for b.Take(ctx) {
+        taken++
+      }
+      So(taken, ShouldBeBetweenOrEqual, 40, 80)
+    })
+  })
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+  "encoding/base64"
+  "encoding/csv"
+  "encoding/json"
+  "fmt"
+  "github.com/aws/aws-sdk-go/service/kinesis"
+  "io"
+  "sync"
+  "time"
+  "unicode/utf8"
+)
+
+// Output formats for records read from a stream.
+var outputFormats = []string{"text", "jsonl", "csv"}
+
+// Ways to write the data of a record in the jsonl and csv formats.
+var dataEncodings = []string{"raw", "base64"}
+
+var csvHeader = []string{"stream", "shard_id", "partition_key", "sequence_number", "approximate_arrival_timestamp", "data_encoding", "data"}
+
+// A RecordWriter writes records read from a stream in one of the output formats:
+// text just the data, tagged with the shard ID when TagShards is set.
+// jsonl one JSON object per line with the record's metadata and data.
+// csv the same as jsonl, as comma separated values with a header line.
+// With Unwrap set the data is taken out of its envelope, see UnwrapRecord.
+type RecordWriter struct {
+  Stream    string
+  Format    string
+  Encoding  string
+  TagShards bool
+  Unwrap    bool
+
+  w         io.Writer
+  csv       *csv.Writer
+  header    bool
+  mu        sync.Mutex
+}
+
+// A record with its metadata, as written in jsonl.
+type RecordOutput struct {
+  Stream                      string     `json:"stream"`
+  ShardID                     string     `json:"shard_id"`
+  PartitionKey                string     `json:"partition_key"`
+  SequenceNumber              string     `json:"sequence_number"`
+  ApproximateArrivalTimestamp *time.Time `json:"approximate_arrival_timestamp,omitempty"`
+  Envelope                    string     `json:"envelope,omitempty"`
+  DataEncoding                string     `json:"data_encoding"`
+  Data                        string     `json:"data"`
+}
+
+func NewRecordWriter(w io.Writer, stream, format, encoding string) *RecordWriter {
+  return &RecordWriter{Stream: stream, Format: format, Encoding: encoding, w: w, csv: csv.NewWriter(w)}
+}
+
+// Write one record read from shardID.
+func (rw *RecordWriter) Write(shardID string, record *kinesis.Record) (err error) {
+  rw.mu.Lock()
+  defer rw.mu.Unlock()
+
+  switch rw.Format {
+    case "jsonl":
+      line, err := json.Marshal(rw.output(shardID, record))
+      if err == nil {
+        _, err = fmt.Fprintf(rw.w, "%s\n", line)
+      }
+      return err
+    case "csv":
+      if !rw.header {
+        rw.header = true
+        if err = rw.csv.Write(csvHeader); err != nil {
+          return err
+        }
+      }
+      o := rw.output(shardID, record)
+      arrival := ""
+      if o.ApproximateArrivalTimestamp != nil {
+        arrival = o.ApproximateArrivalTimestamp.Format(time.RFC3339Nano)
+      }
+      err = rw.csv.Write([]string{o.Stream, o.ShardID, o.PartitionKey, o.SequenceNumber, arrival, o.DataEncoding, o.Data})
+      rw.csv.Flush()
+      if err == nil {
+        err = rw.csv.Error()
+      }
+      return err
+    default:
+      data, _ := rw.data(record)
+      if rw.TagShards {
+        _, err = fmt.Fprintf(rw.w, "%s %s\n", shardID, string(data))
+      } else {
+        _, err = fmt.Fprintln(rw.w, string(data))
+      }
+      return err
+  }
+}
+
+// Structured formats carry the metadata themselves, so don't need it printed verbosely.
+func (rw *RecordWriter) Structured() bool {
+  return rw.Format == "jsonl" || rw.Format == "csv"
+}
+
+func (rw *RecordWriter) output(shardID string, record *kinesis.Record) *RecordOutput {
+  o := &RecordOutput{
+    Stream: rw.Stream,
+    ShardID: shardID,
+    ApproximateArrivalTimestamp: record.ApproximateArrivalTimestamp,
+  }
+  data, envelope := rw.data(record)
+  o.Envelope = envelope
+  o.DataEncoding, o.Data = rw.encode(data)
+  if record.PartitionKey != nil {
+    o.PartitionKey = *record.PartitionKey
+  }
+  if record.SequenceNumber != nil {
+    o.SequenceNumber = *record.SequenceNumber
+  }
+  return o
+}
+
+// The record's data, unwrapped if asked, with the envelope it was in.
+func (rw *RecordWriter) data(record *kinesis.Record) (data []byte, envelope string) {
+  if !rw.Unwrap {
+    return record.Data, ""
+  }
+  line, envelope := UnwrapRecord(record.Data)
+  return []byte(line), envelope
+}
+
+// Raw data that isn't valid UTF-8 wouldn't survive JSON, so it is base64
+// encoded regardless. The encoding used is returned with the data.
+func (rw *RecordWriter) encode(data []byte) (encoding, encoded string) {
+  if rw.Encoding == "base64" || !utf8.Valid(data) {
+    return "base64", base64.StdEncoding.EncodeToString(data)
+  }
+  return "raw", string(data)
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+  "crypto/md5"
+  "encoding/hex"
+  "encoding/json"
+  "errors"
+  "fmt"
+  "math/rand"
+  "regexp"
+  "strconv"
+  "strings"
+  "sync"
+  "time"
+  "unicode/utf8"
+)
+
+// Kinesis takes partition keys of up to this many characters.
+const maxPartitionKeyLength = 256
+
+// How long round robin waits to look up the shards again after failing to,
+// and how often it looks them up again to follow splits and merges.
+var (
+  shardLookupBackoff   = 30 * time.Second
+  shardRefreshInterval = time.Minute
+)
+
+// Names of the partition strategies, json and regex take an argument after a colon.
+var partitionStrategies = []string{"static", "random", "round-robin", "hash", "json:<field>", "regex:<expression>"}
+
+// A PartitionStrategy decides which shard a line is written to. It returns
+// the partition key for the line, and optionally an explicit hash key which
+// Kinesis uses instead of the hash of the partition key.
+type PartitionStrategy interface {
+  PartitionKey(line string) (partitionKey, explicitHashKey string)
+  String() string
+}
+
+// NewPartitionStrategy makes a strategy from its name, see partitionStrategies.
+// Strategies fall back to the static partition when they can't come up with a key.
+func NewPartitionStrategy(spec, partition string, s *KinesisStream) (PartitionStrategy, error) {
+  name, arg := spec, ""
+  if i := strings.Index(spec, ":"); i >= 0 {
+    name, arg = spec[:i], spec[i+1:]
+  }
+  switch name {
+    case "", "static":
+      return staticPartition(partition), nil
+    case "random":
+      return randomPartition{}, nil
+    case "round-robin":
+      return &roundRobinPartition{partition: partition, stream: s}, nil
+    case "hash":
+      return hashPartition{}, nil
+    case "json":
+      if arg == "" {
+        return nil, errors.New("The json partition strategy needs a field, e.g. json:user.id")
+      }
+      return &jsonPartition{field: arg, path: strings.Split(arg, "."), partition: partition}, nil
+    case "regex":
+      re, err := regexp.Compile(arg)
+      if err != nil || arg == "" {
+        return nil, fmt.Errorf("The regex partition strategy needs a regular expression, e.g. regex:user=(\\w+): %v", err)
+      }
+      return &regexPartition{re: re, partition: partition}, nil
+  }
+  return nil, fmt.Errorf("Unknown partition strategy \"%s\", use one of: %s", spec, strings.Join(partitionStrategies, ", "))
+}
+
+// The same partition key for every line, all lines go to one shard.
+type staticPartition string
+
+func (p staticPartition) PartitionKey(line string) (string, string) { return string(p), "" }
+func (p staticPartition) String() string { return "static" }
+
+// A random partition key for each line, spreading lines evenly but in no order.
+type randomPartition struct{}
+
+func (p randomPartition) PartitionKey(line string) (string, string) {
+  return strconv.FormatUint(uint64(rand.Int63()), 36), ""
+}
+func (p randomPartition) String() string { return "random" }
+
+// Each line goes to the next open shard in turn, using the shard's starting
+// hash key as the explicit hash key. The shards are looked up on first use,
+// and again every shardRefreshInterval to pick up resharding. If that fails
+// lines keep the shards they had, or the static partition, for a while
+// before looking again, rather than describing the stream for every line.
+type roundRobinPartition struct {
+  partition string
+  stream    *KinesisStream
+
+  mu        sync.Mutex
+  hashKeys  []string
+  next      int
+  looked    time.Time
+  failed    time.Time
+}
+
+func (p *roundRobinPartition) PartitionKey(line string) (string, string) {
+  p.mu.Lock()
+  defer p.mu.Unlock()
+  if p.hashKeys == nil || time.Since(p.looked) >= shardRefreshInterval {
+    p.lookUpShards()
+  }
+  if p.hashKeys == nil {
+    return p.partition, ""
+  }
+  key := p.hashKeys[p.next%len(p.hashKeys)]
+  p.next++
+  return p.partition, key
+}
+
+func (p *roundRobinPartition) lookUpShards() {
+  if time.Since(p.failed) < shardLookupBackoff {
+    return
+  }
+  shards, err := p.stream.GetOpenShards()
+  if err != nil || len(shards) == 0 {
+    p.failed = time.Now()
+    return
+  }
+  hashKeys := []string{}
+  for _, shard := range shards {
+    hashKeys = append(hashKeys, *shard.HashKeyRange.StartingHashKey)
+  }
+  p.hashKeys, p.looked = hashKeys, time.Now()
+}
+func (p *roundRobinPartition) String() string { return "round-robin" }
+
+// The MD5 of the line, so identical lines land on the same shard.
+type hashPartition struct{}
+
+func (p hashPartition) PartitionKey(line string) (string, string) {
+  sum := md5.Sum([]byte(line))
+  return hex.EncodeToString(sum[:]), ""
+}
+func (p hashPartition) String() string { return "hash" }
+
+// The value of a field in a JSON line, dots separate nested fields.
+type jsonPartition struct {
+  field     string
+  path      []string
+  partition string
+}
+
+func (p *jsonPartition) PartitionKey(line string) (string, string) {
+  var value interface{}
+  if err := json.Unmarshal([]byte(line), &value); err != nil {
+    return p.partition, ""
+  }
+  for _, name := range p.path {
+    object, ok := value.(map[string]interface{})
+    if !ok {
+      return p.partition, ""
+    }
+    if value, ok = object[name]; !ok {
+      return p.partition, ""
+    }
+  }
+  switch v := value.(type) {
+    case string:
+      return derivedKey(v, p.partition), ""
+    case float64, bool:
+      return derivedKey(fmt.Sprint(v), p.partition), ""
+  }
+  return p.partition, ""
+}
+func (p *jsonPartition) String() string { return "json:" + p.field }
+
+// The first capture group of a regular expression matched against the
+// line, or the whole match if it has no groups.
+type regexPartition struct {
+  re        *regexp.Regexp
+  partition string
+}
+
+func (p *regexPartition) PartitionKey(line string) (string, string) {
+  match := p.re.FindStringSubmatch(line)
+  switch {
+    case len(match) > 1: return derivedKey(match[1], p.partition), ""
+    case len(match) == 1: return derivedKey(match[0], p.partition), ""
+  }
+  return p.partition, ""
+}
+func (p *regexPartition) String() string { return "regex:" + p.re.String() }
+
+// A key taken from the line, or the static partition when it's empty or
+// too long for Kinesis.
+func derivedKey(key, partition string) string {
+  if key == "" || utf8.RuneCountInString(key) > maxPartitionKeyLength {
+    return partition
+  }
+  return key
+}
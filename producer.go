@@ -0,0 +1,246 @@
+package main
+
+import (
+  "fmt"
+  "github.com/aws/aws-sdk-go/aws"
+  "github.com/aws/aws-sdk-go/aws/awserr"
+  "github.com/aws/aws-sdk-go/service/kinesis"
+  "math/rand"
+  "sync"
+  "time"
+)
+
+// Kinesis limits on a single PutRecords call.
+const (
+  MaxBatchRecords = 500
+  MaxBatchBytes   = 5 * 1024 * 1024
+)
+
+// A Producer buffers records and puts them to a stream in batches with
+// PutRecords. A batch is sent when it reaches BatchRecords records or
+// MaxBatchBytes, or when FlushInterval passes. Records that fail within
+// a batch, e.g. when a shard is throttled, are retried on their own with
+// exponential backoff, up to MaxRetries times.
+type Producer struct {
+  Stream        *KinesisStream
+  BatchRecords  int
+  FlushInterval time.Duration
+  MaxRetries    int
+  Backoff       time.Duration
+  MaxBackoff    time.Duration
+  Verbose       bool
+
+  mu           sync.Mutex
+  pending      []*kinesis.PutRecordsRequestEntry
+  pendingBytes int
+  sendMu       sync.Mutex
+  sent, failed int
+  err          error
+  stop         chan struct{}
+  stopped      sync.WaitGroup
+}
+
+// NewProducer returns a producer for the stream that flushes every flushInterval,
+// when flushInterval is zero only full batches and Flush send records.
+func (s *KinesisStream) NewProducer(flushInterval time.Duration) *Producer {
+  p := &Producer{
+    Stream: s,
+    BatchRecords: MaxBatchRecords,
+    FlushInterval: flushInterval,
+    MaxRetries: 8,
+    Backoff: 100 * time.Millisecond,
+    MaxBackoff: 5 * time.Second,
+    stop: make(chan struct{}),
+  }
+  if flushInterval > 0 {
+    p.stopped.Add(1)
+    go p.flushEvery(flushInterval)
+  }
+  return p
+}
+
+// PutLogLine buffers line, wrapped and partitioned as PutLogLine does.
+func (p *Producer) PutLogLine(line string) error {
+  return p.PutLine(line, nil)
+}
+
+// PutLine buffers line, wrapped with where it came from for envelopes that record it.
+func (p *Producer) PutLine(line string, source *LineSource) error {
+  partitionKey, explicitHashKey := p.Stream.partitionKey(line)
+  return p.Put(p.Stream.wrap(line, source), partitionKey, explicitHashKey)
+}
+
+// Put buffers a record, sending the batch if it's full. The explicit hash
+// key is optional. The error is the first failure of any batch sent so far.
+func (p *Producer) Put(data []byte, partitionKey string, explicitHashKey *string) error {
+  size := len(data) + len(partitionKey)
+  p.mu.Lock()
+  full := len(p.pending) > 0 &&
+    (len(p.pending) >= p.batchRecords() || p.pendingBytes+size > MaxBatchBytes)
+  p.mu.Unlock()
+  if full {
+    p.Flush()
+  }
+
+  p.mu.Lock()
+  p.pending = append(p.pending, &kinesis.PutRecordsRequestEntry{
+    Data: data,
+    PartitionKey: aws.String(partitionKey),
+    ExplicitHashKey: explicitHashKey,
+  })
+  p.pendingBytes += size
+  p.mu.Unlock()
+  return p.Err()
+}
+
+// Flush sends whatever is buffered.
+func (p *Producer) Flush() error {
+  p.sendMu.Lock()
+  defer p.sendMu.Unlock()
+
+  p.mu.Lock()
+  batch := p.pending
+  p.pending, p.pendingBytes = nil, 0
+  p.mu.Unlock()
+
+  if len(batch) > 0 {
+    p.putBatch(batch)
+  }
+  return p.Err()
+}
+
+// Close stops the flush timer and sends whatever is buffered.
+func (p *Producer) Close() error {
+  close(p.stop)
+  p.stopped.Wait()
+  return p.Flush()
+}
+
+// Err returns the first error from sending a batch.
+func (p *Producer) Err() error {
+  p.mu.Lock()
+  defer p.mu.Unlock()
+  return p.err
+}
+
+// Stats returns the number of records put and the number given up on.
+func (p *Producer) Stats() (sent, failed int) {
+  p.mu.Lock()
+  defer p.mu.Unlock()
+  return p.sent, p.failed
+}
+
+func (p *Producer) batchRecords() int {
+  if p.BatchRecords <= 0 || p.BatchRecords > MaxBatchRecords {
+    return MaxBatchRecords
+  }
+  return p.BatchRecords
+}
+
+func (p *Producer) flushEvery(interval time.Duration) {
+  defer p.stopped.Done()
+  ticker := time.NewTicker(interval)
+  defer ticker.Stop()
+  for {
+    select {
+      case <-ticker.C: p.Flush()
+      case <-p.stop: return
+    }
+  }
+}
+
+// Put the batch, retrying just the records that failed.
+func (p *Producer) putBatch(batch []*kinesis.PutRecordsRequestEntry) {
+  for attempt := 0; len(batch) > 0; attempt++ {
+    if attempt > 0 {
+      time.Sleep(p.backoff(attempt))
+    }
+
+    output, err := p.Stream.Service.PutRecords(&kinesis.PutRecordsInput{
+      Records: batch,
+      StreamName: aws.String(p.Stream.Name),
+    })
+
+    var failed []*kinesis.PutRecordsRequestEntry
+    if err != nil {
+      if !isRetryable(err) || attempt >= p.MaxRetries {
+        p.giveUp(len(batch), err)
+        return
+      }
+      failed = batch
+    } else {
+      for i, result := range output.Records {
+        if result.ErrorCode != nil {
+          failed = append(failed, batch[i])
+        }
+      }
+    }
+
+    p.mu.Lock()
+    p.sent += len(batch) - len(failed)
+    p.mu.Unlock()
+
+    if p.Verbose {
+      fmt.Printf("Put %d records to %s", len(batch)-len(failed), p.Stream.Name)
+      if len(failed) > 0 {
+        fmt.Printf(", %d failed", len(failed))
+      }
+      fmt.Println(".")
+    }
+
+    if len(failed) > 0 && attempt >= p.MaxRetries {
+      code := "failed"
+      if err == nil {
+        for _, result := range output.Records {
+          if result.ErrorCode != nil {
+            code = *result.ErrorCode
+            break
+          }
+        }
+      }
+      p.giveUp(len(failed), fmt.Errorf("%d records still failing after %d retries: %s", len(failed), p.MaxRetries, code))
+      return
+    }
+    batch = failed
+  }
+}
+
+func (p *Producer) giveUp(records int, err error) {
+  p.mu.Lock()
+  defer p.mu.Unlock()
+  p.failed += records
+  if p.err == nil {
+    p.err = err
+  }
+}
+
+// Exponential backoff with jitter, so throttled producers don't retry in lockstep.
+func (p *Producer) backoff(attempt int) time.Duration {
+  d := p.Backoff << uint(attempt-1)
+  if d <= 0 || d > p.MaxBackoff {
+    d = p.MaxBackoff
+  }
+  return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// Throttling and server side failures are worth retrying.
+func isRetryable(err error) bool {
+  if isThrottled(err) {
+    return true
+  }
+  if reqErr, ok := err.(awserr.RequestFailure); ok {
+    return reqErr.StatusCode() >= 500
+  }
+  return false
+}
+
+// Is the error Kinesis telling us to slow down?
+func isThrottled(err error) bool {
+  if awsErr, ok := err.(awserr.Error); ok {
+    switch awsErr.Code() {
+      case "ProvisionedThroughputExceededException", "ThrottlingException", "LimitExceededException":
+        return true
+    }
+  }
+  return false
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+  "context"
+  "fmt"
+  "github.com/aws/aws-sdk-go/service/kinesis"
+  "os"
+  "sort"
+  "sync"
+  "time"
+)
+
+// A record along with the shard it was read from.
+type ShardRecord struct {
+  ShardID string
+  Record  *kinesis.Record
+}
+
+// ReadAllShards starts a GetRecords loop for each open shard of the
+// stream, each in its own goroutine, and merges their records onto a
+// single channel. It can't start at a sequence number. See ReadShards
+// and startingShards.
+func (s *KinesisStream) ReadAllShards(ctx context.Context, tail bool, sleep time.Duration) (<-chan *ShardRecord, <-chan error, error) {
+  // A sequence number belongs to one shard, every other shard would refuse it.
+  switch s.ShardIteratorType {
+    case "AT_SEQUENCE_NUMBER", "AFTER_SEQUENCE_NUMBER":
+      return nil, nil, fmt.Errorf("Can't start every shard at sequence number %s, it belongs to one shard, read that shard on its own",
+        s.StartingSequenceNumber)
+  }
+  shardIDs, err := s.startingShards()
+  if err != nil {
+    return nil, nil, err
+  }
+  records, errs := s.ReadShards(ctx, shardIDs, tail, sleep)
+  return records, errs, nil
+}
+
+// The shards reading every shard starts on: the open shards, or resuming
+// from a checkpoint, the checkpointed shards and the open shards that
+// don't descend from them. A checkpointed shard closed since is read to
+// its end and its children followed from the start, so nothing after
+// the checkpoint is missed.
+func (s *KinesisStream) startingShards() (shardIDs []string, err error) {
+  shards, err := s.GetShards()
+  if err != nil {
+    return nil, err
+  }
+  checkpointed := func(id string) bool {
+    if s.Checkpoint == nil {
+      return false
+    }
+    _, ok := s.Checkpoint.SequenceNumber(id)
+    return ok
+  }
+  parents := make(map[string][]string)
+  for _, shard := range shards {
+    for _, parent := range []*string{shard.ParentShardID, shard.AdjacentParentShardID} {
+      if parent != nil {
+        parents[*shard.ShardID] = append(parents[*shard.ShardID], *parent)
+      }
+    }
+  }
+  resumed := make(map[string]bool)
+  var descends func(id string) bool
+  descends = func(id string) bool {
+    if d, ok := resumed[id]; ok {
+      return d
+    }
+    resumed[id] = false
+    for _, parent := range parents[id] {
+      if checkpointed(parent) || descends(parent) {
+        resumed[id] = true
+      }
+    }
+    return resumed[id]
+  }
+  for _, shard := range shards {
+    id := *shard.ShardID
+    if checkpointed(id) || (shardOpen(shard) && !descends(id)) {
+      shardIDs = append(shardIDs, id)
+    }
+  }
+  return shardIDs, nil
+}
+
+// ReadShards starts a GetRecords loop for each of the shards, each in its
+// own goroutine, and merges their records onto a single channel. Each loop
+// reads from the stream's starting position. Without tail a loop ends when
+// it has caught up with its shard, with tail it keeps polling every sleep
+// until ctx is done. When a shard is closed by a split or merge its children
+// are read from the start, a merged child once all its parents being read
+// here have closed. The records channel is closed, and errors from the loops
+// delivered, once every loop has ended.
+func (s *KinesisStream) ReadShards(ctx context.Context, shardIDs []string, tail bool, sleep time.Duration) (<-chan *ShardRecord, <-chan error) {
+  l := &shardLineage{
+    ctx: ctx, tail: tail, sleep: sleep,
+    records: make(chan *ShardRecord),
+    errs: make(chan error, len(shardIDs)),
+    reading: make(map[string]bool),
+    closed: make(map[string]bool),
+  }
+
+  for _, id := range shardIDs {
+    l.reading[id] = true
+  }
+  for _, id := range shardIDs {
+    l.start(s.ForShard(id))
+  }
+
+  // Children started along the way can fail too, so the errors are only
+  // sent once every loop has ended, after the records.
+  go func() {
+    l.wg.Wait()
+    close(l.records)
+    for _, err := range l.failures {
+      l.errs <- err
+    }
+    close(l.errs)
+  }()
+
+  return l.records, l.errs
+}
+
+// Tracks the shards being read by ReadShards so the children of
+// closed shards are read once, and after their parents.
+type shardLineage struct {
+  ctx     context.Context
+  tail    bool
+  sleep   time.Duration
+  records chan *ShardRecord
+  errs    chan error
+  wg      sync.WaitGroup
+
+  mu       sync.Mutex
+  reading  map[string]bool
+  closed   map[string]bool
+  failures []error
+}
+
+func (l *shardLineage) start(reader *KinesisStream) {
+  l.wg.Add(1)
+  go func() {
+    defer l.wg.Done()
+    err := reader.readShard(l.ctx, l.tail, l.sleep, l.records)
+    if err == nil && reader.ShardClosed {
+      err = l.followChildren(reader)
+    }
+    if err != nil {
+      l.error(fmt.Errorf("%s: %s", reader.ShardID, err))
+    }
+  }()
+}
+
+// Start reading the children of a closed shard whose parents have all been read.
+func (l *shardLineage) followChildren(parent *KinesisStream) error {
+  children, err := parent.GetChildShards()
+  if err != nil {
+    return err
+  }
+
+  l.mu.Lock()
+  defer l.mu.Unlock()
+  l.closed[parent.ShardID] = true
+  for _, child := range children {
+    id := *child.ShardID
+    if l.reading[id] || !l.parentsClosed(child) {
+      continue
+    }
+    l.reading[id] = true
+    reader := parent.ForShard(id)
+    reader.SetStartingPosition("TRIM_HORIZON", "", time.Time{})
+    l.start(reader)
+  }
+  return nil
+}
+
+func (l *shardLineage) parentsClosed(child *kinesis.Shard) bool {
+  for _, parent := range []*string{child.ParentShardID, child.AdjacentParentShardID} {
+    if parent != nil && l.reading[*parent] && !l.closed[*parent] {
+      return false
+    }
+  }
+  return true
+}
+
+func (l *shardLineage) error(err error) {
+  l.mu.Lock()
+  defer l.mu.Unlock()
+  l.failures = append(l.failures, err)
+}
+
+func (s *KinesisStream) readShard(ctx context.Context, tail bool, sleep time.Duration, records chan<- *ShardRecord) error {
+  s.ReadReset()
+  for {
+    output, err := s.GetRecords()
+    if err != nil {
+      return err
+    }
+
+    for _, record := range output.Records {
+      select {
+        case records <- &ShardRecord{ShardID: s.ShardID, Record: record}:
+        case <-ctx.Done(): return nil
+      }
+    }
+
+    if s.ShardClosed {
+      return nil
+    }
+
+    if *output.MillisBehindLatest <= 0 {
+      if !tail {
+        return nil
+      }
+      select {
+        case <-time.After(sleep):
+        case <-ctx.Done(): return nil
+      }
+    }
+  }
+}
+
+// orderRecords puts records read with ReadAllShards in arrival order.
+// When tailing there is no end to wait for, so it orders what arrives
+// every couple of polls.
+func orderRecords(records <-chan *ShardRecord, tail bool, sleep time.Duration) <-chan *ShardRecord {
+  window := time.Duration(0)
+  if tail {
+    window = 2 * sleep
+  }
+  return orderByArrival(records, window)
+}
+
+// orderByArrival sorts records by their ApproximateArrivalTimestamp.
+// Records are collected for window and then sent on in order. A zero
+// window collects everything until in is closed.
+func orderByArrival(in <-chan *ShardRecord, window time.Duration) <-chan *ShardRecord {
+  out := make(chan *ShardRecord)
+  go func() {
+    defer close(out)
+    var pending []*ShardRecord
+    flush := func() {
+      sort.Stable(byArrival(pending))
+      for _, r := range pending {
+        out <- r
+      }
+      pending = nil
+    }
+
+    var tick <-chan time.Time
+    if window > 0 {
+      ticker := time.NewTicker(window)
+      defer ticker.Stop()
+      tick = ticker.C
+    }
+    for {
+      select {
+        case r, ok := <-in:
+          if !ok {
+            flush()
+            return
+          }
+          pending = append(pending, r)
+        case <-tick:
+          flush()
+      }
+    }
+  }()
+  return out
+}
+
+type byArrival []*ShardRecord
+
+func (b byArrival) Len() int      { return len(b) }
+func (b byArrival) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b byArrival) Less(i, j int) bool {
+  ti, tj := b[i].Record.ApproximateArrivalTimestamp, b[j].Record.ApproximateArrivalTimestamp
+  if ti == nil || tj == nil {
+    return false
+  }
+  return ti.Before(*tj)
+}
+
+// Write records as they arrive from ReadShards, then print any errors.
+// Errors go to stderr so they don't get mixed up with jsonl or csv output.
+// Each record written is marked in the checkpoint, if there is one. Once
+// a record on a shard fails to write, no more are marked on that shard,
+// so the next read starts again from it. Returns the last error.
+func writeShardRecords(out *RecordWriter, records <-chan *ShardRecord, errs <-chan error, verbose bool, checkpoint *Checkpoint) (err error) {
+  out.TagShards = true
+  failed := make(map[string]bool)
+  for record := range records {
+    if verbose && !out.Structured() {
+      fmt.Fprintf(out.w, "%s partition: %s sequence number: %s\n", record.ShardID, *record.Record.PartitionKey, *record.Record.SequenceNumber)
+    }
+    if e := out.Write(record.ShardID, record.Record); e != nil {
+      fmt.Fprintf(os.Stderr, "Error - %s.\n", e)
+      failed[record.ShardID], err = true, e
+    }
+    if checkpoint != nil && !failed[record.ShardID] {
+      checkpoint.Mark(record.ShardID, *record.Record.SequenceNumber)
+      if e := checkpoint.SaveEvery(checkpointSaveInterval); e != nil {
+        fmt.Fprintf(os.Stderr, "Error - %s.\n", e)
+        err = e
+      }
+    }
+  }
+  if checkpoint != nil {
+    if e := checkpoint.Save(); e != nil {
+      fmt.Fprintf(os.Stderr, "Error - %s.\n", e)
+      err = e
+    }
+  }
+  for e := range errs {
+    fmt.Fprintf(os.Stderr, "Error - %s.\n", e)
+    err = e
+  }
+  return err
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+  "bufio"
+  "encoding/json"
+  "fmt"
+  "github.com/aws/aws-sdk-go/aws"
+  "github.com/aws/aws-sdk-go/aws/awserr"
+  "github.com/aws/aws-sdk-go/service/kinesis"
+  "io"
+  "os"
+  "path/filepath"
+  "reflect"
+  "strings"
+  "sync"
+)
+
+// A stream tagged spur:protected=true is protected wherever it's used from.
+const protectedTag = "spur:protected"
+
+var (
+  // --yes, take every confirmation as given.
+  assumeYes bool
+
+  // --dry-run, print the calls that would change streams instead of making them.
+  dryRun bool
+
+  // Confirmations are read from here, unless stdin is the script being run.
+  confirmInput  = bufio.NewReader(os.Stdin)
+  scriptOnStdin bool
+)
+
+// Protects says whether the stream is protected, by name or glob in the
+// config, or by its tag.
+func (c *SpurConfig) Protects(s *KinesisStream) (bool, error) {
+  for _, pattern := range c.Protected {
+    if matched, _ := filepath.Match(pattern, s.Name); matched {
+      return true, nil
+    }
+  }
+  tags, err := s.Tags()
+  if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == "ResourceNotFoundException" {
+    return false, nil
+  }
+  return tags[protectedTag] == "true", err
+}
+
+// checkDelete refuses to delete a protected stream, and has the name of
+// any other typed in to confirm deleting it, unless yes.
+func checkDelete(w io.Writer, s *KinesisStream, yes bool) error {
+  protected, err := spurConfig.Protects(s)
+  if err != nil {
+    return err
+  }
+  if protected {
+    return fmt.Errorf("%s is protected, take it out of protected in .spur.yaml or untag %s to delete it", s.Name, protectedTag)
+  }
+  if yes {
+    return nil
+  }
+  return confirm(w, s, "Delete")
+}
+
+// checkChange has the name of a protected stream typed in before its
+// shards are changed or its retention cut.
+func checkChange(w io.Writer, s *KinesisStream, what string) error {
+  protected, err := spurConfig.Protects(s)
+  if err != nil || !protected {
+    return err
+  }
+  return confirm(w, s, what+" protected stream")
+}
+
+// Ask for the stream's name before going on, unless there's no need.
+func confirm(w io.Writer, s *KinesisStream, what string) error {
+  if assumeYes || dryRun {
+    return nil
+  }
+  if scriptOnStdin {
+    return fmt.Errorf("Can't confirm on stdin while running it as a script, %s is left as it was, use --yes to go on", s.Name)
+  }
+  fmt.Fprintf(w, "%s %s? Type the stream name to confirm: ", what, s.Name)
+  line, err := confirmInput.ReadString('\n')
+  if strings.TrimSpace(line) != s.Name {
+    if err != nil && err != io.EOF {
+      return err
+    }
+    return fmt.Errorf("Not confirmed, %s is left as it was", s.Name)
+  }
+  return nil
+}
+
+// Make the Kinesis service, one that only pretends to change streams on
+// --dry-run, and otherwise logs the changes to the audit log.
+func newKinesisService(config *aws.Config) KinesisAPI {
+  svc := kinesis.New(config)
+  switch {
+    case dryRun:
+      return NewDryRunKinesis(svc, os.Stdout)
+    case auditLogFile != "":
+      return NewAuditKinesis(svc, &AuditLog{File: auditLogFile}, config)
+  }
+  return svc
+}
+
+// DryRunKinesis makes the calls that read streams, and writes the calls
+// that would change them, as the JSON they'd send, instead of making them.
+// Streams it pretends to create are described as ACTIVE with no shards.
+type DryRunKinesis struct {
+  KinesisAPI
+  w       io.Writer
+  mu      sync.Mutex
+  created map[string]bool
+}
+
+func NewDryRunKinesis(svc KinesisAPI, w io.Writer) *DryRunKinesis {
+  return &DryRunKinesis{KinesisAPI: svc, w: w, created: make(map[string]bool)}
+}
+
+func isDryRun(svc KinesisAPI) bool {
+  _, dry := svc.(*DryRunKinesis)
+  return dry
+}
+
+// Write the call as it would go to Kinesis.
+func (d *DryRunKinesis) call(operation string, input interface{}) {
+  data, _ := json.Marshal(toWire(reflect.ValueOf(input)))
+  d.mu.Lock()
+  defer d.mu.Unlock()
+  fmt.Fprintf(d.w, "Dry run: %s %s\n", operation, data)
+}
+
+func (d *DryRunKinesis) CreateStream(input *kinesis.CreateStreamInput) (*kinesis.CreateStreamOutput, error) {
+  d.call("CreateStream", input)
+  d.mu.Lock()
+  d.created[stringValue(input.StreamName)] = true
+  d.mu.Unlock()
+  return &kinesis.CreateStreamOutput{}, nil
+}
+
+func (d *DryRunKinesis) DescribeStream(input *kinesis.DescribeStreamInput) (*kinesis.DescribeStreamOutput, error) {
+  d.mu.Lock()
+  created := d.created[stringValue(input.StreamName)]
+  d.mu.Unlock()
+  if !created {
+    return d.KinesisAPI.DescribeStream(input)
+  }
+  return &kinesis.DescribeStreamOutput{StreamDescription: &kinesis.StreamDescription{
+    StreamName: input.StreamName, StreamStatus: aws.String("ACTIVE"), RetentionPeriodHours: aws.Long(24),
+    EncryptionType: aws.String("NONE"), HasMoreShards: aws.Boolean(false)}}, nil
+}
+
+func (d *DryRunKinesis) ListTagsForStream(input *kinesis.ListTagsForStreamInput) (*kinesis.ListTagsForStreamOutput, error) {
+  d.mu.Lock()
+  created := d.created[stringValue(input.StreamName)]
+  d.mu.Unlock()
+  if !created {
+    return d.KinesisAPI.ListTagsForStream(input)
+  }
+  return &kinesis.ListTagsForStreamOutput{HasMoreTags: aws.Boolean(false)}, nil
+}
+
+func (d *DryRunKinesis) DeleteStream(input *kinesis.DeleteStreamInput) (*kinesis.DeleteStreamOutput, error) {
+  d.call("DeleteStream", input)
+  return &kinesis.DeleteStreamOutput{}, nil
+}
+
+func (d *DryRunKinesis) PutRecord(input *kinesis.PutRecordInput) (*kinesis.PutRecordOutput, error) {
+  d.call("PutRecord", input)
+  return &kinesis.PutRecordOutput{ShardID: aws.String("dry-run"), SequenceNumber: aws.String("0")}, nil
+}
+
+func (d *DryRunKinesis) PutRecords(input *kinesis.PutRecordsInput) (*kinesis.PutRecordsOutput, error) {
+  d.call("PutRecords", input)
+  output := &kinesis.PutRecordsOutput{FailedRecordCount: aws.Long(0)}
+  for range input.Records {
+    output.Records = append(output.Records, &kinesis.PutRecordsResultEntry{ShardID: aws.String("dry-run"), SequenceNumber: aws.String("0")})
+  }
+  return output, nil
+}
+
+func (d *DryRunKinesis) SplitShard(input *kinesis.SplitShardInput) (*kinesis.SplitShardOutput, error) {
+  d.call("SplitShard", input)
+  return &kinesis.SplitShardOutput{}, nil
+}
+
+func (d *DryRunKinesis) MergeShards(input *kinesis.MergeShardsInput) (*kinesis.MergeShardsOutput, error) {
+  d.call("MergeShards", input)
+  return &kinesis.MergeShardsOutput{}, nil
+}
+
+func (d *DryRunKinesis) UpdateShardCount(input *kinesis.UpdateShardCountInput) (*kinesis.UpdateShardCountOutput, error) {
+  d.call("UpdateShardCount", input)
+  return &kinesis.UpdateShardCountOutput{StreamName: input.StreamName, TargetShardCount: input.TargetShardCount}, nil
+}
+
+func (d *DryRunKinesis) IncreaseStreamRetentionPeriod(input *kinesis.IncreaseStreamRetentionPeriodInput) (*kinesis.IncreaseStreamRetentionPeriodOutput, error) {
+  d.call("IncreaseStreamRetentionPeriod", input)
+  return &kinesis.IncreaseStreamRetentionPeriodOutput{}, nil
+}
+
+func (d *DryRunKinesis) DecreaseStreamRetentionPeriod(input *kinesis.DecreaseStreamRetentionPeriodInput) (*kinesis.DecreaseStreamRetentionPeriodOutput, error) {
+  d.call("DecreaseStreamRetentionPeriod", input)
+  return &kinesis.DecreaseStreamRetentionPeriodOutput{}, nil
+}
+
+func (d *DryRunKinesis) AddTagsToStream(input *kinesis.AddTagsToStreamInput) (*kinesis.AddTagsToStreamOutput, error) {
+  d.call("AddTagsToStream", input)
+  return &kinesis.AddTagsToStreamOutput{}, nil
+}
+
+func (d *DryRunKinesis) RemoveTagsFromStream(input *kinesis.RemoveTagsFromStreamInput) (*kinesis.RemoveTagsFromStreamOutput, error) {
+  d.call("RemoveTagsFromStream", input)
+  return &kinesis.RemoveTagsFromStreamOutput{}, nil
+}
+
+func (d *DryRunKinesis) StartStreamEncryption(input *kinesis.StartStreamEncryptionInput) (*kinesis.StartStreamEncryptionOutput, error) {
+  d.call("StartStreamEncryption", input)
+  return &kinesis.StartStreamEncryptionOutput{}, nil
+}
+
+func (d *DryRunKinesis) StopStreamEncryption(input *kinesis.StopStreamEncryptionInput) (*kinesis.StopStreamEncryptionOutput, error) {
+  d.call("StopStreamEncryption", input)
+  return &kinesis.StopStreamEncryptionOutput{}, nil
+}
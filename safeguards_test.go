@@ -0,0 +1,110 @@
+package main
+
+import (
+  "bufio"
+  "bytes"
+  "io/ioutil"
+  "os"
+  "strings"
+  "testing"
+  "github.com/aws/aws-sdk-go/aws"
+  "github.com/aws/aws-sdk-go/service/kinesis"
+  . "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSafeguards(t *testing.T) {
+
+  Convey("Given a protected list and a fake Kinesis", t, func() {
+    svc, s := newFakeStream("clicks", 2)
+    svc.CreateStream(&kinesis.CreateStreamInput{StreamName: aws.String("prod-orders"), ShardCount: aws.Long(1)})
+    svc.CreateStream(&kinesis.CreateStreamInput{StreamName: aws.String("tagged"), ShardCount: aws.Long(1)})
+    svc.AddTagsToStream(&kinesis.AddTagsToStreamInput{StreamName: aws.String("tagged"), Tags: map[string]*string{protectedTag: aws.String("true")}})
+    g := streamGroupFor(svc, s)
+    savedConfig, savedInput := spurConfig, confirmInput
+    defer func() { spurConfig, confirmInput = savedConfig, savedInput }()
+    spurConfig = &SpurConfig{Protected: []string{"prod-*"}}
+    answer := func(line string) { confirmInput = bufio.NewReader(strings.NewReader(line)) }
+
+    Convey("Streams should be protected by glob or by tag", func() {
+      for name, protected := range map[string]bool{"clicks": false, "prod-orders": true, "tagged": true} {
+        p, err := spurConfig.Protects(NewStreamWithService(svc, name, "", "", ""))
+        So(err, ShouldBeNil)
+        So(p, ShouldEqual, protected)
+      }
+    })
+
+    Convey("Deleting should take the stream's name typed in", func() {
+      out := captureStdout(func() {
+        answer("clocks\n")
+        So(DoICommand("delete clicks", g), ShouldNotBeNil)
+        answer("clicks\n")
+        So(DoICommand("delete clicks", g), ShouldBeNil)
+      })
+      So(out, ShouldContainSubstring, "Delete clicks? Type the stream name to confirm: ")
+      _, err := g.GetStream("clicks")
+      So(err, ShouldNotBeNil)
+    })
+
+    Convey("Protected streams shouldn't be deleted, even with --yes", func() {
+      captureStdout(func() {
+        So(DoICommand("delete prod-orders --yes", g), ShouldNotBeNil)
+        So(DoICommand("delete tagged --yes", g), ShouldNotBeNil)
+      })
+      for _, name := range []string{"prod-orders", "tagged"} {
+        _, err := g.GetStream(name)
+        So(err, ShouldBeNil)
+      }
+    })
+
+    Convey("Resharding a protected stream should need confirming", func() {
+      captureStdout(func() {
+        So(DoICommand("use prod-orders", g), ShouldBeNil)
+        answer("\n")
+        So(DoICommand("shards split shardId-000000000000 --even", g), ShouldNotBeNil)
+      })
+      shards, _ := g.Streams["prod-orders"].GetOpenShards()
+      So(shards, ShouldHaveLength, 1)
+    })
+
+    Convey("Nothing should be confirmed from a script read on stdin", func() {
+      script, _ := ioutil.TempFile("", "spur-script")
+      defer os.Remove(script.Name())
+      script.WriteString("delete clicks\nclicks\n")
+      script.Seek(0, 0)
+      savedStdin := os.Stdin
+      os.Stdin = script
+      defer func() { os.Stdin = savedStdin }()
+      answer("clicks\n")
+      captureStdout(func() {
+        So(RunIScriptFile("-", g, true), ShouldNotBeNil)
+      })
+      So(g.Streams["clicks"], ShouldNotBeNil)
+      So(scriptOnStdin, ShouldBeFalse)
+    })
+  })
+
+  Convey("Given a dry run on a fake Kinesis", t, func() {
+    svc, s := newFakeStream("clicks", 2)
+    before := svc.Changes()
+    var calls bytes.Buffer
+    dry := NewDryRunKinesis(svc, &calls)
+    s.Service = dry
+    g := streamGroupFor(dry, s)
+
+    Convey("Creating, deleting, resharding and retention changes should only be printed", func() {
+      captureStdout(func() {
+        So(DoICommand("create orders --shards 3 --wait", g), ShouldBeNil)
+        So(DoICommand("use clicks", g), ShouldBeNil)
+        So(DoICommand("shards split shardId-000000000000 --even", g), ShouldBeNil)
+        So(DoICommand("update --retention 48h --wait", g), ShouldBeNil)
+        So(DoICommand("delete clicks --yes --wait", g), ShouldBeNil)
+      })
+      So(calls.String(), ShouldContainSubstring, `Dry run: CreateStream {"ShardCount":3,"StreamName":"orders"}`)
+      So(calls.String(), ShouldContainSubstring, `Dry run: SplitShard {"NewStartingHashKey":`)
+      So(calls.String(), ShouldContainSubstring, `Dry run: IncreaseStreamRetentionPeriod {"RetentionPeriodHours":48,"StreamName":"clicks"}`)
+      So(calls.String(), ShouldContainSubstring, `Dry run: DeleteStream {"StreamName":"clicks"}`)
+      So(svc.Changes(), ShouldEqual, before)
+      So(g.Streams["clicks"], ShouldNotBeNil)
+    })
+  })
+}
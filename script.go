@@ -0,0 +1,88 @@
+package main
+
+import (
+  "bufio"
+  "fmt"
+  "io"
+  "os"
+  "strings"
+)
+
+// How deep source can nest, in case a file sources itself.
+const maxSourceDepth = 10
+
+var sourceDepth int
+
+// scriptError is a script command that failed, already reported with
+// where it happened, so a sourcing script passes it on as is.
+type scriptError struct{ error }
+
+// RunIScript runs interactive commands read from r, one per line or
+// separated by ;. Blank lines and everything after a # are skipped, exit
+// or quit end the script early. Each failure is reported with where it
+// happened. It returns the first error, after running the rest of the
+// commands unless stopOnError is set.
+func RunIScript(r io.Reader, source string, g *KinesisStreamGroup, stopOnError bool) (first error) {
+  if sourceDepth >= maxSourceDepth {
+    return fmt.Errorf("sourced more than %d deep", maxSourceDepth)
+  }
+  sourceDepth++
+  defer func() { sourceDepth-- }()
+
+  scanner := bufio.NewScanner(r)
+  for n := 1; scanner.Scan(); n++ {
+    line := scanner.Text()
+    if i := strings.Index(line, "#"); i >= 0 {
+      line = line[:i]
+    }
+    for _, command := range strings.Split(line, ";") {
+      if strings.TrimSpace(command) == "" {
+        continue
+      }
+      if verbose || iVerbose {
+        fmt.Printf("%s:%d> %s\n", source, n, strings.TrimSpace(command))
+      }
+      err := runICommand(command, g)
+      if err == io.EOF {
+        return first
+      }
+      if _, reported := err.(scriptError); err != nil && !reported {
+        err = scriptError{fmt.Errorf("%s:%d: %s: %s", source, n, strings.TrimSpace(command), err)}
+        fmt.Fprintf(os.Stderr, "Error - %s.\n", err)
+      }
+      if err != nil {
+        if first == nil {
+          first = err
+        }
+        if stopOnError {
+          return first
+        }
+      }
+    }
+  }
+  if err := scanner.Err(); err != nil && first == nil {
+    first = err
+  }
+  return first
+}
+
+// Run the commands in a file, - is stdin. Nothing can be confirmed on
+// stdin while it's the script.
+func RunIScriptFile(name string, g *KinesisStreamGroup, stopOnError bool) error {
+  if name == "-" {
+    saved := scriptOnStdin
+    scriptOnStdin = true
+    defer func() { scriptOnStdin = saved }()
+    return RunIScript(os.Stdin, "stdin", g, stopOnError)
+  }
+  f, err := os.Open(name)
+  if err != nil {
+    return err
+  }
+  defer f.Close()
+  return RunIScript(f, name, g, stopOnError)
+}
+
+func doSource(g *KinesisStreamGroup) error {
+  return RunIScriptFile(interSourceFile, g, interSourceStop)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "strings"
+  "testing"
+  . "github.com/smartystreets/goconvey/convey"
+)
+
+func TestScript(t *testing.T) {
+
+  Convey("Given an interactive session on a fake Kinesis", t, func() {
+    svc, s := newFakeStream("clicks", 1)
+    g, _ := NewStreamGroupWithService(svc, "us-west-1")
+    g.Streams["clicks"] = s
+    g.CurrentStream = s
+    interJobs = NewJobs()
+    defer interJobs.KillAll()
+    changes := svc.Changes()
+
+    Convey("Commands separated by lines and ; should run in order", func() {
+      script := "# put some records\niterate 2 one; iterate 3 two\n\nread all  # and check\n"
+      var err error
+      out := captureStdout(func() {
+        err = RunIScript(strings.NewReader(script), "test", g, false)
+      })
+      So(err, ShouldBeNil)
+      So(strings.Count(out, "one: "), ShouldEqual, 2)
+      So(strings.Count(out, "two: "), ShouldEqual, 3)
+    })
+
+    Convey("A failing command should be reported with its line, and the rest run", func() {
+      var err error
+      captureStdout(func() {
+        err = RunIScript(strings.NewReader("iterate 1 first\nuse nosuch\niterate 1 last\n"), "test", g, false)
+      })
+      So(err, ShouldNotBeNil)
+      So(err.Error(), ShouldStartWith, "test:2: use nosuch")
+      So(svc.Changes()-changes, ShouldEqual, 2)
+    })
+
+    Convey("With stopOnError the script should end at the first failure", func() {
+      var err error
+      captureStdout(func() {
+        err = RunIScript(strings.NewReader("bogus command; iterate 1 never\n"), "test", g, true)
+      })
+      So(err, ShouldNotBeNil)
+      So(svc.Changes()-changes, ShouldEqual, 0)
+    })
+
+    Convey("Quit should end the script without an error", func() {
+      var err error
+      captureStdout(func() {
+        err = RunIScript(strings.NewReader("quit\nbogus\n"), "test", g, true)
+      })
+      So(err, ShouldBeNil)
+    })
+
+    Convey("Source should run a file, and a file sourcing itself should stop", func() {
+      dir, _ := ioutil.TempDir("", "spur-script")
+      defer os.RemoveAll(dir)
+      name := filepath.Join(dir, "loop.spur")
+      ioutil.WriteFile(name, []byte("iterate 1 looped\nsource --stop-on-error "+name+"\n"), 0644)
+      var err error
+      captureStdout(func() {
+        err = RunIScript(strings.NewReader("source --stop-on-error "+name), "test", g, true)
+      })
+      So(err, ShouldNotBeNil)
+      So(err.Error(), ShouldContainSubstring, "sourced more than")
+      So(svc.Changes()-changes, ShouldEqual, maxSourceDepth-1)
+    })
+  })
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+  "encoding/base64"
+  "encoding/json"
+  "fmt"
+  "github.com/aws/aws-sdk-go/aws/awserr"
+  "io/ioutil"
+  "log"
+  "net/http"
+  "os"
+  "path/filepath"
+  "reflect"
+  "strings"
+  "time"
+)
+
+// The Kinesis JSON API target prefix, as in X-Amz-Target: Kinesis_20131202.PutRecord.
+const kinesisTargetPrefix = "Kinesis_20131202."
+
+// The operations spur serve answers, each a FakeKinesis method.
+var serveOperations = []string{
+  "CreateStream", "DeleteStream", "DescribeStream", "ListStreams",
+  "PutRecord", "PutRecords", "GetShardIterator", "GetRecords",
+  "SplitShard", "MergeShards", "UpdateShardCount",
+  "IncreaseStreamRetentionPeriod", "DecreaseStreamRetentionPeriod",
+  "AddTagsToStream", "RemoveTagsFromStream", "ListTagsForStream",
+  "StartStreamEncryption", "StopStreamEncryption",
+}
+
+// KinesisServer answers the Kinesis JSON HTTP API from a FakeKinesis,
+// keeping its streams in DataDir (if set) between runs.
+type KinesisServer struct {
+  Service      *FakeKinesis
+  DataDir      string
+  SaveInterval time.Duration
+  Verbose      bool
+  saved        int64
+}
+
+func NewKinesisServer(dataDir string) (*KinesisServer, error) {
+  k := &KinesisServer{Service: NewFakeKinesis(), DataDir: dataDir, SaveInterval: 5 * time.Second}
+  if dataDir == "" {
+    return k, nil
+  }
+  if err := os.MkdirAll(dataDir, 0755); err != nil {
+    return nil, err
+  }
+  f, err := os.Open(k.dataFile())
+  if os.IsNotExist(err) {
+    return k, nil
+  }
+  if err != nil {
+    return nil, err
+  }
+  defer f.Close()
+  if err = k.Service.Load(f); err != nil {
+    return nil, fmt.Errorf("Couldn't load %s: %s", k.dataFile(), err)
+  }
+  k.saved = k.Service.Changes()
+  return k, nil
+}
+
+func (k *KinesisServer) dataFile() string {
+  return filepath.Join(k.DataDir, "streams.json")
+}
+
+// Maintain trims records past their retention and saves the streams if they've
+// changed, every SaveInterval until done is closed, then saves once more.
+func (k *KinesisServer) Maintain(done <-chan struct{}) {
+  ticker := time.NewTicker(k.SaveInterval)
+  defer ticker.Stop()
+  for {
+    select {
+      case <-ticker.C:
+        if n := k.Service.Trim(time.Now()); n > 0 && k.Verbose {
+          log.Printf("Trimmed %d records past retention.", n)
+        }
+        if err := k.Save(); err != nil {
+          log.Printf("Couldn't save streams: %s", err)
+        }
+      case <-done:
+        if err := k.Save(); err != nil {
+          log.Printf("Couldn't save streams: %s", err)
+        }
+        return
+    }
+  }
+}
+
+// Save writes the streams to DataDir if anything has changed since the last save.
+func (k *KinesisServer) Save() error {
+  changes := k.Service.Changes()
+  if k.DataDir == "" || changes == k.saved {
+    return nil
+  }
+  tmp := k.dataFile() + ".tmp"
+  f, err := os.Create(tmp)
+  if err != nil {
+    return err
+  }
+  err = k.Service.Save(f)
+  if closeErr := f.Close(); err == nil {
+    err = closeErr
+  }
+  if err == nil {
+    err = os.Rename(tmp, k.dataFile())
+  }
+  if err == nil {
+    k.saved = changes
+  }
+  return err
+}
+
+func (k *KinesisServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+  if r.Method != "POST" {
+    writeServeError(w, http.StatusMethodNotAllowed, "UnknownOperationException", "Only POST is supported")
+    return
+  }
+  target := r.Header.Get("X-Amz-Target")
+  op := strings.TrimPrefix(target, kinesisTargetPrefix)
+  if op == target || !k.serves(op) {
+    writeServeError(w, http.StatusBadRequest, "UnknownOperationException", "Unknown operation "+target)
+    return
+  }
+  method := reflect.ValueOf(k.Service).MethodByName(op)
+
+  body, err := ioutil.ReadAll(r.Body)
+  if err != nil {
+    writeServeError(w, http.StatusBadRequest, "SerializationException", err.Error())
+    return
+  }
+  var request interface{}
+  if len(body) > 0 {
+    err = json.Unmarshal(body, &request)
+  }
+  input := reflect.New(method.Type().In(0).Elem())
+  if err == nil {
+    err = fromWire(request, input.Elem())
+  }
+  if err != nil {
+    writeServeError(w, http.StatusBadRequest, "SerializationException", err.Error())
+    return
+  }
+
+  results := method.Call([]reflect.Value{input})
+  if err, _ := results[1].Interface().(error); err != nil {
+    code, message := "InternalFailure", err.Error()
+    if awsErr, ok := err.(awserr.Error); ok {
+      code, message = awsErr.Code(), awsErr.Message()
+    }
+    if k.Verbose {
+      log.Printf("%s: %s %s", op, code, message)
+    }
+    writeServeError(w, http.StatusBadRequest, code, message)
+    return
+  }
+  if k.Verbose {
+    log.Printf("%s: ok", op)
+  }
+  writeServeResponse(w, http.StatusOK, toWire(results[0]))
+}
+
+func (k *KinesisServer) serves(op string) bool {
+  for _, name := range serveOperations {
+    if name == op {
+      return true
+    }
+  }
+  return false
+}
+
+func writeServeResponse(w http.ResponseWriter, status int, response interface{}) {
+  w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+  w.WriteHeader(status)
+  json.NewEncoder(w).Encode(response)
+}
+
+func writeServeError(w http.ResponseWriter, status int, code, message string) {
+  writeServeResponse(w, status, map[string]string{"__type": code, "message": message})
+}
+
+// The wire name of an SDK field: ShardID goes out as ShardId.
+func wireName(field string) string {
+  if strings.HasSuffix(field, "ID") {
+    return strings.TrimSuffix(field, "ID") + "Id"
+  }
+  return field
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// Convert an SDK value to its wire form: structs become objects keyed
+// by wire name without their nil fields, times are epoch seconds and
+// []byte is base64.
+func toWire(v reflect.Value) interface{} {
+  switch v.Kind() {
+    case reflect.Ptr, reflect.Interface:
+      if v.IsNil() {
+        return nil
+      }
+      return toWire(v.Elem())
+    case reflect.Struct:
+      if v.Type() == timeType {
+        t := v.Interface().(time.Time)
+        return float64(t.UnixNano()) / float64(time.Second)
+      }
+      out := make(map[string]interface{})
+      for i := 0; i < v.NumField(); i++ {
+        field := v.Type().Field(i)
+        if field.PkgPath != "" {
+          continue
+        }
+        if value := toWire(v.Field(i)); value != nil {
+          out[wireName(field.Name)] = value
+        }
+      }
+      return out
+    case reflect.Slice:
+      if v.IsNil() {
+        return nil
+      }
+      if v.Type().Elem().Kind() == reflect.Uint8 {
+        return base64.StdEncoding.EncodeToString(v.Bytes())
+      }
+      out := make([]interface{}, v.Len())
+      for i := range out {
+        out[i] = toWire(v.Index(i))
+      }
+      return out
+    case reflect.Map:
+      if v.IsNil() {
+        return nil
+      }
+      out := make(map[string]interface{})
+      for _, key := range v.MapKeys() {
+        out[key.String()] = toWire(v.MapIndex(key))
+      }
+      return out
+  }
+  return v.Interface()
+}
+
+// Fill in an SDK value from its decoded JSON wire form, the reverse of toWire.
+func fromWire(in interface{}, v reflect.Value) error {
+  if in == nil {
+    return nil
+  }
+  switch v.Kind() {
+    case reflect.Ptr:
+      v.Set(reflect.New(v.Type().Elem()))
+      return fromWire(in, v.Elem())
+    case reflect.Struct:
+      if v.Type() == timeType {
+        seconds, ok := in.(float64)
+        if !ok {
+          return fmt.Errorf("Expected epoch seconds, got %v", in)
+        }
+        v.Set(reflect.ValueOf(time.Unix(0, int64(seconds*float64(time.Second)))))
+        return nil
+      }
+      object, ok := in.(map[string]interface{})
+      if !ok {
+        return fmt.Errorf("Expected an object for %s, got %v", v.Type().Name(), in)
+      }
+      for i := 0; i < v.NumField(); i++ {
+        field := v.Type().Field(i)
+        if field.PkgPath != "" {
+          continue
+        }
+        if err := fromWire(object[wireName(field.Name)], v.Field(i)); err != nil {
+          return fmt.Errorf("%s: %s", wireName(field.Name), err)
+        }
+      }
+      return nil
+    case reflect.Slice:
+      if v.Type().Elem().Kind() == reflect.Uint8 {
+        s, ok := in.(string)
+        if !ok {
+          return fmt.Errorf("Expected base64, got %v", in)
+        }
+        data, err := base64.StdEncoding.DecodeString(s)
+        if err == nil {
+          v.SetBytes(data)
+        }
+        return err
+      }
+      list, ok := in.([]interface{})
+      if !ok {
+        return fmt.Errorf("Expected a list, got %v", in)
+      }
+      v.Set(reflect.MakeSlice(v.Type(), len(list), len(list)))
+      for i, item := range list {
+        if err := fromWire(item, v.Index(i)); err != nil {
+          return err
+        }
+      }
+      return nil
+    case reflect.Map:
+      object, ok := in.(map[string]interface{})
+      if !ok {
+        return fmt.Errorf("Expected an object, got %v", in)
+      }
+      v.Set(reflect.MakeMap(v.Type()))
+      for key, item := range object {
+        value := reflect.New(v.Type().Elem()).Elem()
+        if err := fromWire(item, value); err != nil {
+          return err
+        }
+        v.SetMapIndex(reflect.ValueOf(key), value)
+      }
+      return nil
+    case reflect.String:
+      s, ok := in.(string)
+      if !ok {
+        return fmt.Errorf("Expected a string, got %v", in)
+      }
+      v.SetString(s)
+      return nil
+    case reflect.Int, reflect.Int64:
+      n, ok := in.(float64)
+      if !ok {
+        return fmt.Errorf("Expected a number, got %v", in)
+      }
+      v.SetInt(int64(n))
+      return nil
+    case reflect.Float64:
+      n, ok := in.(float64)
+      if !ok {
+        return fmt.Errorf("Expected a number, got %v", in)
+      }
+      v.SetFloat(n)
+      return nil
+    case reflect.Bool:
+      b, ok := in.(bool)
+      if !ok {
+        return fmt.Errorf("Expected true or false, got %v", in)
+      }
+      v.SetBool(b)
+      return nil
+  }
+  return fmt.Errorf("Can't decode into %s", v.Type())
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+  "context"
+  "encoding/json"
+  "io/ioutil"
+  "net/http"
+  "net/http/httptest"
+  "os"
+  "strings"
+  "testing"
+  "time"
+  "github.com/aws/aws-sdk-go/aws"
+  "github.com/aws/aws-sdk-go/service/kinesis"
+  . "github.com/smartystreets/goconvey/convey"
+)
+
+// Call op on a spur serve endpoint, returning the status and decoded response.
+func callServe(url, op, body string) (int, map[string]interface{}) {
+  request, _ := http.NewRequest("POST", url, strings.NewReader(body))
+  request.Header.Set("X-Amz-Target", kinesisTargetPrefix+op)
+  request.Header.Set("Content-Type", "application/x-amz-json-1.1")
+  response, err := http.DefaultClient.Do(request)
+  So(err, ShouldBeNil)
+  defer response.Body.Close()
+  out := make(map[string]interface{})
+  json.NewDecoder(response.Body).Decode(&out)
+  return response.StatusCode, out
+}
+
+func TestServe(t *testing.T) {
+
+  Convey("Given spur serve with a data directory", t, func() {
+    dir, _ := ioutil.TempDir("", "spur-serve")
+    defer os.RemoveAll(dir)
+    server, err := NewKinesisServer(dir)
+    So(err, ShouldBeNil)
+    http := httptest.NewServer(server)
+    defer http.Close()
+
+    status, _ := callServe(http.URL, "CreateStream", `{"StreamName": "clicks", "ShardCount": 2}`)
+    So(status, ShouldEqual, 200)
+
+    Convey("Records put over HTTP should be read back in the wire format", func() {
+      status, put := callServe(http.URL, "PutRecord", `{"StreamName": "clicks", "PartitionKey": "a", "Data": "aGVsbG8="}`)
+      So(status, ShouldEqual, 200)
+      So(put["ShardId"], ShouldNotBeEmpty)
+      So(put["SequenceNumber"], ShouldNotBeEmpty)
+
+      _, it := callServe(http.URL, "GetShardIterator",
+        `{"StreamName": "clicks", "ShardId": "`+put["ShardId"].(string)+`", "ShardIteratorType": "TRIM_HORIZON"}`)
+      _, out := callServe(http.URL, "GetRecords", `{"ShardIterator": "`+it["ShardIterator"].(string)+`"}`)
+      records := out["Records"].([]interface{})
+      So(records, ShouldHaveLength, 1)
+      record := records[0].(map[string]interface{})
+      So(record["Data"], ShouldEqual, "aGVsbG8=")
+      So(record["PartitionKey"], ShouldEqual, "a")
+      So(record["ApproximateArrivalTimestamp"], ShouldHaveSameTypeAs, float64(0))
+      So(out["NextShardIterator"], ShouldNotBeEmpty)
+
+      _, described := callServe(http.URL, "DescribeStream", `{"StreamName": "clicks"}`)
+      description := described["StreamDescription"].(map[string]interface{})
+      So(description["StreamStatus"], ShouldEqual, "ACTIVE")
+      So(description["Shards"], ShouldHaveLength, 2)
+      So(description["Shards"].([]interface{})[0].(map[string]interface{})["ShardId"], ShouldEqual, "shardId-000000000000")
+    })
+
+    Convey("Errors should come back as Kinesis error types", func() {
+      status, out := callServe(http.URL, "DescribeStream", `{"StreamName": "nope"}`)
+      So(status, ShouldEqual, 400)
+      So(out["__type"], ShouldEqual, "ResourceNotFoundException")
+
+      _, out = callServe(http.URL, "EnableEnhancedMonitoring", `{"StreamName": "clicks"}`)
+      So(out["__type"], ShouldEqual, "UnknownOperationException")
+
+      _, out = callServe(http.URL, "PutRecord", `{"StreamName": "clicks", "PartitionKey": "a", "Data": 7}`)
+      So(out["__type"], ShouldEqual, "SerializationException")
+    })
+
+    Convey("Streams and records should still be there after a restart", func() {
+      callServe(http.URL, "PutRecord", `{"StreamName": "clicks", "PartitionKey": "a", "Data": "aGVsbG8="}`)
+      So(server.Save(), ShouldBeNil)
+
+      restarted, err := NewKinesisServer(dir)
+      So(err, ShouldBeNil)
+      s := NewStreamWithService(restarted.Service, "clicks", "PARTITION", "TRIM_HORIZON", "")
+      records, _, err := s.ReadAllShards(context.Background(), false, time.Millisecond)
+      So(err, ShouldBeNil)
+      count := 0
+      for range records {
+        count++
+      }
+      So(count, ShouldEqual, 1)
+    })
+  })
+
+  Convey("Given a fake stream with records", t, func() {
+    svc, s := newFakeStream("clicks", 1)
+    s.PutLogLine("old")
+    s.PutLogLine("new")
+
+    Convey("Trimming past retention should drop old records without breaking iterators", func() {
+      s.ShardIteratorType = "TRIM_HORIZON"
+      s.ReadReset()
+      output, err := s.GetRecords()
+      So(err, ShouldBeNil)
+      So(output.Records, ShouldHaveLength, 2)
+      s.PutLogLine("newer")
+
+      So(svc.Trim(time.Now().Add(25*time.Hour)), ShouldEqual, 3)
+      output, err = s.GetRecords()
+      So(err, ShouldBeNil)
+      So(output.Records, ShouldBeEmpty)
+      s.PutLogLine("newest")
+      output, _ = s.GetRecords()
+      So(output.Records, ShouldHaveLength, 1)
+      So(string(output.Records[0].Data), ShouldEqual, "newest")
+    })
+
+    Convey("Splitting a shard should close it and open two children", func() {
+      _, err := svc.SplitShard(&kinesis.SplitShardInput{StreamName: aws.String("clicks"),
+        ShardToSplit: aws.String("shardId-000000000000"), NewStartingHashKey: aws.String("170141183460469231731687303715884105728")})
+      So(err, ShouldBeNil)
+      open, err := s.GetOpenShards()
+      So(err, ShouldBeNil)
+      So(open, ShouldHaveLength, 2)
+      So(*open[0].ParentShardID, ShouldEqual, "shardId-000000000000")
+
+      Convey("And merging the children should leave one open shard", func() {
+        _, err := svc.MergeShards(&kinesis.MergeShardsInput{StreamName: aws.String("clicks"),
+          ShardToMerge: open[1].ShardID, AdjacentShardToMerge: open[0].ShardID})
+        So(err, ShouldBeNil)
+        merged, _ := s.GetOpenShards()
+        So(merged, ShouldHaveLength, 1)
+        So(*merged[0].HashKeyRange.StartingHashKey, ShouldEqual, "0")
+        So(*merged[0].AdjacentParentShardID, ShouldEqual, *open[0].ShardID)
+      })
+    })
+  })
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+  "crypto/md5"
+  "fmt"
+  "github.com/aws/aws-sdk-go/aws"
+  "github.com/aws/aws-sdk-go/service/kinesis"
+  "io"
+  "math/big"
+  "strings"
+)
+
+// The largest hash key, hash keys are 128 bit unsigned integers.
+var maxHashKey = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 128), big.NewInt(1))
+
+// partitionHashKey is how Kinesis maps a partition key to a hash key,
+// the MD5 of the key as a 128 bit unsigned integer.
+func partitionHashKey(partitionKey string) *big.Int {
+  sum := md5.Sum([]byte(partitionKey))
+  return new(big.Int).SetBytes(sum[:])
+}
+
+// shardHashRange is the hash key range of a shard, nil if it hasn't one.
+func shardHashRange(shard *kinesis.Shard) (start, end *big.Int) {
+  if shard.HashKeyRange == nil {
+    return nil, nil
+  }
+  start, ok := new(big.Int).SetString(stringValue(shard.HashKeyRange.StartingHashKey), 10)
+  if !ok {
+    return nil, nil
+  }
+  end, ok = new(big.Int).SetString(stringValue(shard.HashKeyRange.EndingHashKey), 10)
+  if !ok {
+    return nil, nil
+  }
+  return start, end
+}
+
+func shardOpen(shard *kinesis.Shard) bool {
+  return shard.SequenceNumberRange == nil || shard.SequenceNumberRange.EndingSequenceNumber == nil
+}
+
+// ShardForKey works out, the way Kinesis does, which open shard a
+// partition key lands on, along with the key's hash key.
+func (s *KinesisStream) ShardForKey(partitionKey string) (*kinesis.Shard, *big.Int, error) {
+  hashKey := partitionHashKey(partitionKey)
+  shards, err := s.GetOpenShards()
+  if err != nil {
+    return nil, hashKey, err
+  }
+  for _, shard := range shards {
+    start, end := shardHashRange(shard)
+    if start != nil && hashKey.Cmp(start) >= 0 && hashKey.Cmp(end) <= 0 {
+      return shard, hashKey, nil
+    }
+  }
+  return nil, hashKey, fmt.Errorf("No open shard in %s has hash key %s", s.Name, hashKey)
+}
+
+// The share of all hash keys in the range, as a percentage.
+func hashRangeShare(start, end *big.Int) float64 {
+  width := new(big.Int).Add(new(big.Int).Sub(end, start), big.NewInt(1))
+  share, _ := new(big.Float).Quo(new(big.Float).SetInt(width), new(big.Float).SetInt(new(big.Int).Add(maxHashKey, big.NewInt(1)))).Float64()
+  return share * 100
+}
+
+// Write a description of each shard: whether it's open, its hash key and
+// sequence number ranges, and the shards it came from.
+func writeShards(w io.Writer, shards []*kinesis.Shard) {
+  open := 0
+  for _, shard := range shards {
+    state := "closed"
+    if shardOpen(shard) {
+      state = "open"
+      open++
+    }
+    fmt.Fprintf(w, "%s (%s)\n", stringValue(shard.ShardID), state)
+    if start, end := shardHashRange(shard); start != nil {
+      fmt.Fprintf(w, "%20s %s - %s (%.1f%%)\n", "Hash Keys:", start, end, hashRangeShare(start, end))
+    }
+    if r := shard.SequenceNumberRange; r != nil {
+      if r.EndingSequenceNumber != nil {
+        fmt.Fprintf(w, "%20s %s - %s\n", "Sequence Numbers:", stringValue(r.StartingSequenceNumber), *r.EndingSequenceNumber)
+      } else {
+        fmt.Fprintf(w, "%20s %s onwards\n", "Sequence Numbers:", stringValue(r.StartingSequenceNumber))
+      }
+    }
+    parents := []string{}
+    for _, parent := range []*string{shard.ParentShardID, shard.AdjacentParentShardID} {
+      if parent != nil {
+        parents = append(parents, *parent)
+      }
+    }
+    if len(parents) > 0 {
+      fmt.Fprintf(w, "%20s %s\n", "Parents:", strings.Join(parents, ", "))
+    }
+  }
+  fmt.Fprintf(w, "%d shards, %d open.\n", len(shards), open)
+}
+
+// Write which shard a partition key lands on.
+func writeWhichShard(w io.Writer, s *KinesisStream, partitionKey string) error {
+  shard, hashKey, err := s.ShardForKey(partitionKey)
+  if err != nil {
+    return err
+  }
+  fmt.Fprintf(w, "\"%s\" has hash key %s, on %s.\n", partitionKey, hashKey, *shard.ShardID)
+  return nil
+}
+
+// SplitShard splits a shard in two at a hash key, or when at is nil in
+// the middle of its hash key range.
+func (s *KinesisStream) SplitShard(shardID string, at *big.Int) error {
+  if at == nil {
+    shards, err := s.GetShards()
+    if err != nil {
+      return err
+    }
+    for _, shard := range shards {
+      if *shard.ShardID == shardID {
+        if start, end := shardHashRange(shard); start != nil {
+          at = new(big.Int).Add(start, new(big.Int).Rsh(new(big.Int).Sub(end, start), 1))
+          at.Add(at, big.NewInt(1))
+        }
+      }
+    }
+    if at == nil {
+      return fmt.Errorf("No shard %s in %s", shardID, s.Name)
+    }
+  }
+  _, err := s.Service.SplitShard(&kinesis.SplitShardInput{StreamName: aws.String(s.Name),
+    ShardToSplit: aws.String(shardID), NewStartingHashKey: aws.String(at.String())})
+  return err
+}
+
+// MergeShards merges two shards with adjacent hash key ranges.
+func (s *KinesisStream) MergeShards(shardID, adjacentShardID string) error {
+  _, err := s.Service.MergeShards(&kinesis.MergeShardsInput{StreamName: aws.String(s.Name),
+    ShardToMerge: aws.String(shardID), AdjacentShardToMerge: aws.String(adjacentShardID)})
+  return err
+}
+
+// UpdateShardCount rescales the stream to count shards of equal size.
+func (s *KinesisStream) UpdateShardCount(count int64) error {
+  _, err := s.Service.UpdateShardCount(&kinesis.UpdateShardCountInput{StreamName: aws.String(s.Name),
+    TargetShardCount: aws.Long(count), ScalingType: aws.String("UNIFORM_SCALING")})
+  return err
+}
+
+// Reshard makes a change to the stream's shards, waits for the stream to
+// be ACTIVE again, and writes the open shards before and after. A
+// protected stream's name has to be typed in first.
+func Reshard(w io.Writer, s *KinesisStream, change func() error) error {
+  if err := checkChange(w, s, "Reshard"); err != nil {
+    return err
+  }
+  before, err := s.GetOpenShards()
+  if err != nil {
+    return err
+  }
+  fmt.Fprintln(w, "Before:")
+  writeShards(w, before)
+  if err = change(); err != nil || isDryRun(s.Service) {
+    return err
+  }
+  fmt.Fprintf(w, "Waiting for %s to be ACTIVE.\n", s.Name)
+  if err = s.WaitForStatus("ACTIVE", activeTimeout, nil); err != nil {
+    return err
+  }
+  after, err := s.GetOpenShards()
+  if err != nil {
+    return err
+  }
+  fmt.Fprintln(w, "After:")
+  writeShards(w, after)
+  return nil
+}
+
+// Split a shard at the hash key given, or evenly.
+func splitShard(w io.Writer, s *KinesisStream, shardID, at string, even bool) error {
+  if at != "" && even {
+    return fmt.Errorf("Split --at a hash key or --even, not both")
+  }
+  var key *big.Int
+  if at != "" {
+    var err error
+    if key, err = parseHashKey(at); err != nil {
+      return err
+    }
+  }
+  return Reshard(w, s, func() error { return s.SplitShard(shardID, key) })
+}
+
+// Parse a hash key given on the command line.
+func parseHashKey(value string) (*big.Int, error) {
+  key, ok := new(big.Int).SetString(value, 10)
+  if !ok || key.Sign() < 0 || key.Cmp(maxHashKey) > 0 {
+    return nil, fmt.Errorf("A hash key is a number from 0 to %s, not %s", maxHashKey, value)
+  }
+  return key, nil
+}
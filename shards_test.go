@@ -0,0 +1,103 @@
+package main
+
+import (
+  "fmt"
+  "io/ioutil"
+  "testing"
+  "time"
+  "github.com/aws/aws-sdk-go/aws"
+  "github.com/aws/aws-sdk-go/service/kinesis"
+  . "github.com/smartystreets/goconvey/convey"
+)
+
+func TestShards(t *testing.T) {
+
+  Convey("Given a stream with one of its two shards split", t, func() {
+    svc, s := newFakeStream("clicks", 2)
+    _, err := svc.SplitShard(&kinesis.SplitShardInput{StreamName: aws.String("clicks"),
+      ShardToSplit: aws.String("shardId-000000000000"), NewStartingHashKey: aws.String("85070591730234615865843651857942052864")})
+    So(err, ShouldBeNil)
+
+    Convey("Shards should show their state, ranges and parents", func() {
+      out := captureStdout(func() {
+        So(DoICommand("shards", streamGroupFor(svc, s)), ShouldBeNil)
+      })
+      So(out, ShouldContainSubstring, "shardId-000000000000 (closed)")
+      So(out, ShouldContainSubstring, "shardId-000000000001 (open)")
+      So(out, ShouldContainSubstring, "(25.0%)")
+      So(out, ShouldContainSubstring, "Parents: shardId-000000000000")
+      So(out, ShouldContainSubstring, "4 shards, 3 open.")
+    })
+
+    Convey("Keys should be looked up on the shard Kinesis puts them on", func() {
+      for i := 0; i < 20; i++ {
+        key := fmt.Sprintf("customer-%d", i)
+        put, err := svc.PutRecord(&kinesis.PutRecordInput{StreamName: aws.String("clicks"), PartitionKey: aws.String(key), Data: []byte("x")})
+        So(err, ShouldBeNil)
+        shard, _, err := s.ShardForKey(key)
+        So(err, ShouldBeNil)
+        So(*shard.ShardID, ShouldEqual, *put.ShardID)
+      }
+      out := captureStdout(func() {
+        So(DoICommand("which-shard customer-1", streamGroupFor(svc, s)), ShouldBeNil)
+      })
+      So(out, ShouldStartWith, `"customer-1" has hash key `)
+    })
+  })
+}
+
+func TestReshard(t *testing.T) {
+
+  Convey("Given a stream with two shards that takes a while to update", t, func() {
+    svc, s := newFakeStream("clicks", 2)
+    svc.StateDelay = 30 * time.Millisecond
+    saved := statusPollInterval
+    statusPollInterval = 5 * time.Millisecond
+    defer func() { statusPollInterval = saved }()
+    g := streamGroupFor(svc, s)
+
+    Convey("Splitting evenly should wait for ACTIVE and show the shards before and after", func() {
+      out := captureStdout(func() {
+        So(DoICommand("shards split shardId-000000000000 --even", g), ShouldBeNil)
+      })
+      So(out, ShouldContainSubstring, "Before:\nshardId-000000000000 (open)")
+      So(out, ShouldContainSubstring, "2 shards, 2 open.\nWaiting for clicks to be ACTIVE.\nAfter:")
+      So(out, ShouldContainSubstring, "shardId-000000000002 (open)\n          Hash Keys: 0 - 85070591730234615865843651857942052863 (25.0%)")
+      So(out, ShouldContainSubstring, "3 shards, 3 open.\n")
+      d, _ := s.GetAWSDescription()
+      So(*d.StreamStatus, ShouldEqual, "ACTIVE")
+
+      Convey("And merging the halves back should leave two shards", func() {
+        captureStdout(func() {
+          So(DoICommand("shards merge shardId-000000000002 shardId-000000000003", g), ShouldBeNil)
+        })
+        open, _ := s.GetOpenShards()
+        So(open, ShouldHaveLength, 2)
+        So(*open[1].AdjacentParentShardID, ShouldEqual, "shardId-000000000003")
+      })
+    })
+
+    Convey("Splitting at a hash key outside the shard should fail", func() {
+      So(splitShard(ioutil.Discard, s, "shardId-000000000001", "5", false), ShouldNotBeNil)
+      So(splitShard(ioutil.Discard, s, "shardId-000000000001", "nonsense", false), ShouldNotBeNil)
+    })
+
+    Convey("Scaling should leave evenly sized shards with the old ones as parents", func() {
+      So(Reshard(ioutil.Discard, s, func() error { return s.UpdateShardCount(3) }), ShouldBeNil)
+      open, _ := s.GetOpenShards()
+      So(open, ShouldHaveLength, 3)
+      So(*open[0].ParentShardID, ShouldEqual, "shardId-000000000000")
+      So(*open[1].ParentShardID, ShouldEqual, "shardId-000000000000")
+      So(*open[1].AdjacentParentShardID, ShouldEqual, "shardId-000000000001")
+      So(s.UpdateShardCount(7), ShouldNotBeNil)
+    })
+  })
+}
+
+// A group on the service with s as its current stream.
+func streamGroupFor(svc KinesisAPI, s *KinesisStream) *KinesisStreamGroup {
+  g, _ := NewStreamGroupWithService(svc, "us-west-1")
+  g.Streams[s.Name] = s
+  g.CurrentStream = s
+  return g
+}
@@ -4,6 +4,8 @@ package main
 
 import (
   "bufio"
+  "context"
+  "errors"
   "fmt"
   "github.com/aws/aws-sdk-go/aws"
   "github.com/aws/aws-sdk-go/aws/awsutil"
@@ -11,8 +13,12 @@ import (
   "gopkg.in/alecthomas/kingpin.v2"
   "io"
   "log"
+  "net"
+  "net/http"
   "os"
+  "os/signal"
   "path/filepath"
+  "strconv"
   "strings"
   "time"
 )
@@ -21,15 +27,30 @@ var (
   app                                *kingpin.Application
   verbose                            bool
   region, stream, partition, shardID string
+  profile, endpointURL               string
+  awsConfig                          *aws.Config
+  targetName                         string
+  spurConfig                         = &SpurConfig{}
+  partitionStrategy                  string
   iType                              string
   iteratorType                       = &iType
+  iteratorTypes                      = []string{"TRIM_HORIZON", "LATEST", "AT_SEQUENCE_NUMBER", "AFTER_SEQUENCE_NUMBER", "AT_TIMESTAMP"}
 
   // Prompt for Commands
   interactive *kingpin.CmdClause
+  scriptFile   string
+  scriptCommands []string
+  stopOnError  bool
+  historySize  int
+  transcriptFile string
 
   // Generate data.
-  gen    *kingpin.CmdClause
-  genLog bool
+  gen           *kingpin.CmdClause
+  genLog         bool
+  envelopeFormat = new(string)
+  timeFormat     string
+  batchSize     int
+  flushInterval time.Duration
 
   genFile *kingpin.CmdClause
   file    *os.File
@@ -40,11 +61,85 @@ var (
 
   genPrompt *kingpin.CmdClause
 
+  genLoad      *kingpin.CmdClause
+  loadRate     string
+  loadDuration time.Duration
+  loadSize     string
+  loadWorkers  int
+  loadTemplate string
+
   // Read data.
   read           *kingpin.CmdClause
   showEmptyReads bool
   tail           bool
   sleepMilli     int
+  fromSeq        string
+  afterSeq       string
+  since          string
+  allShards      bool
+  orderedRead    bool
+  checkpointName string
+  outputFormat   *string
+  dataEncoding   *string
+  unwrapRecords  bool
+
+  // Manage read checkpoints.
+  checkpoints       *kingpin.CmdClause
+  checkpointsList   *kingpin.CmdClause
+  checkpointsShow   *kingpin.CmdClause
+  checkpointsReset  *kingpin.CmdClause
+  checkpointShardID string
+
+  // Inventory streams.
+  list            *kingpin.CmdClause
+  listQuery       ListQuery
+  listOutput      *string
+  listSort        *string
+  listReverse     bool
+
+  // Create and change streams.
+  create          *kingpin.CmdClause
+  update          *kingpin.CmdClause
+  createShards    int64
+  createName      string
+  retention       string
+  tags            []string
+  untags          []string
+  encryption      string
+  waitActive      bool
+  wait            *kingpin.CmdClause
+  waitStream      string
+  waitFor         *string
+  waitTimeout     time.Duration
+
+  // Explore and reshard.
+  shards          *kingpin.CmdClause
+  shardsList      *kingpin.CmdClause
+  shardsSplit     *kingpin.CmdClause
+  shardsMerge     *kingpin.CmdClause
+  shardsScale     *kingpin.CmdClause
+  reshardShardID  string
+  reshardAdjacent string
+  splitAt         string
+  splitEven       bool
+  scaleCount      int64
+  whichShard      *kingpin.CmdClause
+  whichShardKey   string
+
+  // Query the audit log.
+  auditLogFile    string
+  audit           *kingpin.CmdClause
+  auditStream     string
+  auditSince      string
+  auditUntil      string
+  auditJSONL      bool
+
+  // Run a local Kinesis.
+  serve           *kingpin.CmdClause
+  servePort       int
+  serveHost       string
+  serveDataDir    string
+  serveStateDelay time.Duration
 
   streamGroup *KinesisStreamGroup
 )
@@ -52,23 +147,41 @@ var (
 func init() {
   app = kingpin.New("spur", "A command-line AWS Kinesis application.")
   app.Flag("verbose", "Describe what is happening, as it happens.").Short('v').BoolVar(&verbose)
-
-  app.Flag("region", "Find the kinsesis stream in this AWS region.").Default("us-west-1").StringVar(&region)
-  app.Flag("stream", "Use this Kinesis stream name").Default("JDR_TestStream_1").StringVar(&stream)
-  app.Flag("partition", "Identify as this Kinesis stream partition").Default("PARTITION").StringVar(&partition)
-  app.Flag("shard-id", "The Shard to read on the kinesis stream.").Default("shardId-000000000001").StringVar(&shardID)
-  // TODO: Accommodate the other two ShardIterator types (two flags?, Arg, Flag?)
+  app.Flag("dry-run", "Print the calls that would create, delete, reshard or change streams, as JSON, instead of making them.").BoolVar(&dryRun)
+  app.Flag("yes", "Don't ask to confirm deleting or changing streams. Protected streams still can't be deleted.").Short('y').BoolVar(&assumeYes)
+  app.Flag("audit-log", "Append the calls that create, delete, reshard or change streams to this file, as JSON lines. Empty for none.").Default(auditFile()).StringVar(&auditLogFile)
+
+  app.Flag("region", "Find the kinsesis stream in this AWS region. Defaults to $AWS_REGION, then the profile's region, then us-west-1.").StringVar(&region)
+  app.Flag("profile", "Use this profile from ~/.aws/config and ~/.aws/credentials. Defaults to $AWS_PROFILE.").StringVar(&profile)
+  app.Flag("endpoint-url", "Send Kinesis requests here instead of AWS, e.g. http://localhost:4567 for spur serve. Defaults to $AWS_ENDPOINT_URL.").StringVar(&endpointURL)
+  app.Flag("target", "Use this target from ~/.spur.yaml or ./.spur.yaml for the settings not given as flags. Defaults to the file's default target.").Short('T').StringVar(&targetName)
+  app.Flag("stream", "Use this Kinesis stream name (default "+defaultStream+").").StringVar(&stream)
+  app.Flag("partition", "Identify as this Kinesis stream partition (default "+defaultPartition+").").StringVar(&partition)
+  app.Flag("partition-strategy", "How to pick the partition key for each line written: "+
+    strings.Join(partitionStrategies, ", ")+". Anything that can't find a key uses --partition (default static).").StringVar(&partitionStrategy)
+  app.Flag("shard-id", "The Shard to read on the kinesis stream (default "+defaultShardID+").").StringVar(&shardID)
   // ShardIteratorType
-  // - "AT_SEQUENCE_NUMBER" start reading at a particular sequence numner
-  // - "AFTER_SEQUENCE_NUMBER" start reading right after the position indicated by the sequence number.
+  // - "AT_SEQUENCE_NUMBER" start reading at a particular sequence numner (--from-seq).
+  // - "AFTER_SEQUENCE_NUMBER" start reading right after the position indicated by the sequence number (--after-seq).
+  // - "AT_TIMESTAMP" start reading at the first record at or after a time (--since).
   // - "TIME_HORIZON" start reading at the last untrimmed record (oldest).
   // - "LATEST"  start reading just after hte most recent record in the shard.
-  app.Flag("iterator-type", "Where to start reading the stream.").Default("LATEST").EnumVar(&iteratorType, "TRIM_HORIZON", "LATEST")
+  app.Flag("iterator-type", "Where to start reading the stream (default "+defaultIteratorType+").").EnumVar(&iteratorType, iteratorTypes...)
+
+  interactive = app.Command("interactive", "Prompt for commands, or run them from a file or the command line.")
+  interactive.Flag("file", "Run the interactive commands in a file, - for stdin, then exit.").Short('f').StringVar(&scriptFile)
+  interactive.Flag("command", "Run interactive commands, separated by ;, then exit. Can be repeated.").Short('c').StringsVar(&scriptCommands)
+  interactive.Flag("stop-on-error", "Stop a script at the first command that fails.").BoolVar(&stopOnError)
+  interactive.Flag("history-size", "Most commands to keep in ~/.spur_history, 0 to keep none.").Default(strconv.Itoa(defaultHistorySize)).IntVar(&historySize)
+  interactive.Flag("transcript", "Append the session, each command and its output with the time, to a file.").StringVar(&transcriptFile)
 
-  interactive = app.Command("interactive", "Prompt for commands.")
+  gen = app.Command("gen", "Put data into the Kinesis stream. File and iterate put records in batches, prompt a record at a time.")
+  gen.Flag("log", "Generate a log style prefix for each message including the current time. Default on, use --no-log to send the lines raw.").Default("true").BoolVar(&genLog)
+  gen.Flag("envelope", "Wrap each line as raw (as is), log (time prefix) or json (with timestamp, hostname, pid, source file and line). Overrides --log.").EnumVar(&envelopeFormat, envelopeFormats...)
+  gen.Flag("time-format", "Time format for the log and json envelopes: RFC1123Z, RFC3339, RFC3339Nano, unix, unixms or a Go time layout.").Default("RFC1123Z").StringVar(&timeFormat)
 
-  gen = app.Command("gen", "Put data into the Kinesis stream. This is inefficiently done a record at a time, no batching.")
-  gen.Flag("log", "Generate a log style prefix for each message including the current time. Default on, use --no-log to turn it off.").BoolVar(&genLog)
+  gen.Flag("batch-size", "Most records to put in one PutRecords call, up to 500.").Default("500").IntVar(&batchSize)
+  gen.Flag("flush-interval", "Put a partial batch once it has waited this long.").Default("1s").DurationVar(&flushInterval)
 
   genFile = gen.Command("file", "Put data into the Kinesis stream from a file or stdin.")
   genFile.Arg("file-name", "Name of file for reading newline separeted records, each record is sent to the Kinesis stream.").OpenFileVar(&file, os.O_RDONLY, 0666)
@@ -79,14 +192,97 @@ func init() {
 
   genPrompt = gen.Command("prompt", "Prompt for strings to send to the kinesis stream.")
 
+  genLoad = gen.Command("load", "Put records at a steady rate and report the throughput and put latency. Payloads are sent without an envelope.")
+  genLoad.Flag("rate", "Records to put, per second (2000/s), minute (/m) or hour (/h). 0 is as fast as possible.").Default("100/s").StringVar(&loadRate)
+  genLoad.Flag("duration", "How long to keep putting records, <ctrl-c> stops early.").Default("1m").DurationVar(&loadDuration)
+  genLoad.Flag("size", "Size of each random payload, e.g. 512B, 1KB.").Default("1KB").StringVar(&loadSize)
+  genLoad.Flag("workers", "Number of goroutines putting records at once.").Default("4").IntVar(&loadWorkers)
+  genLoad.Flag("template", "Make payloads with this Go template instead of at random, using {{.Seq}}, {{.Worker}}, {{.Time}}, {{.Unix}} and {{random 10}}.").StringVar(&loadTemplate)
+
   read = app.Command("read", "Read from a kinesis stream.")
   read.Flag("tail", "Continue waiting for records to read from the stream, will set latest unless -all specificed").Short('t').BoolVar(&tail)
   read.Flag("sleep", "Delay in milliseconds for sleep between polls in tail mode.").Default("500").IntVar(&sleepMilli)
   read.Flag("log-empty-reads", "Print out the empty reads and delay stats. This will happen with verbose as well.").BoolVar(&showEmptyReads)
+  read.Flag("all-shards", "Read every open shard at once, each record is tagged with its shard ID.").Short('a').BoolVar(&allShards)
+  read.Flag("ordered", "With --all-shards, order the records by their approximate arrival time.").BoolVar(&orderedRead)
+  outputFormat = read.Flag("output", "Write records as text (just the data), jsonl or csv (with the record metadata). Default text.").Short('o').Enum(outputFormats...)
+  dataEncoding = read.Flag("data-encoding", "Write the record data in jsonl and csv output as a raw string or base64.").Default("raw").Enum(dataEncodings...)
+  read.Flag("unwrap", "Take the log or json envelope off each record, leaving the line as written.").BoolVar(&unwrapRecords)
+  read.Flag("checkpoint", "Resume from, and record, the last sequence number read on each shard in this named checkpoint.").StringVar(&checkpointName)
+  read.Flag("from-seq", "Start reading at this sequence number (AT_SEQUENCE_NUMBER).").StringVar(&fromSeq)
+  read.Flag("after-seq", "Start reading just after this sequence number (AFTER_SEQUENCE_NUMBER).").StringVar(&afterSeq)
+  read.Flag("since", "Start reading at this time, e.g. 2006-01-02T15:04Z, or this long ago, e.g. 30m (AT_TIMESTAMP).").StringVar(&since)
+
+  checkpoints = app.Command("checkpoints", "Manage the checkpoints kept by read --checkpoint.")
+  checkpointsList = checkpoints.Command("list", "List the saved checkpoints.")
+  checkpointsShow = checkpoints.Command("show", "Show the sequence numbers saved in a checkpoint.")
+  checkpointsShow.Arg("name", "Name of the checkpoint.").Required().StringVar(&checkpointName)
+  checkpointsReset = checkpoints.Command("reset", "Forget a checkpoint so the next read starts from the starting position again.")
+  checkpointsReset.Arg("name", "Name of the checkpoint.").Required().StringVar(&checkpointName)
+  checkpointsReset.Flag("shard-id", "Only forget the position on this shard.").StringVar(&checkpointShardID)
+
+  list = app.Command("list", "List the streams with their status, shards, retention, encryption and tags.")
+  list.Flag("prefix", "Only list the streams whose names start with this.").StringVar(&listQuery.Prefix)
+  list.Flag("status", "Only list the streams with this status, e.g. ACTIVE.").StringVar(&listQuery.Status)
+  listOutput = list.Flag("output", "List the streams as a table, json or yaml.").Short('o').Default("table").Enum(listOutputFormats...)
+  listSort = list.Flag("sort", "Sort the streams on name, shards, retention, encryption, status or tags.").Default("name").Enum(listSortColumns...)
+  list.Flag("reverse", "Sort the streams the other way.").BoolVar(&listReverse)
+
+  create = app.Command("create", "Create the stream, then set its retention, tags and encryption.")
+  create.Arg("stream", "Stream to create, instead of --stream.").StringVar(&createName)
+  create.Flag("shards", "Number of shards.").Default("2").Int64Var(&createShards)
+  create.Flag("retention", "How long to keep records, in hours (168h) or days (7d). Default 24h.").StringVar(&retention)
+  create.Flag("tag", "Tag the stream, key=value. Can be repeated.").StringsVar(&tags)
+  create.Flag("encrypt", "Encrypt the stream with a KMS key, kms:<key ID, ARN or alias>.").StringVar(&encryption)
+  create.Flag("wait", "Wait for the stream to be ACTIVE.").BoolVar(&waitActive)
+  update = app.Command("update", "Change the retention, tags or encryption of the stream.")
+  update.Flag("retention", "How long to keep records, in hours (168h) or days (7d).").StringVar(&retention)
+  update.Flag("tag", "Add or change a tag, key=value. Can be repeated.").StringsVar(&tags)
+  update.Flag("untag", "Remove a tag by its key. Can be repeated.").StringsVar(&untags)
+  update.Flag("encrypt", "Encrypt the stream with a KMS key, kms:<key ID, ARN or alias>, or none to stop.").StringVar(&encryption)
+  update.Flag("wait", "Wait for the stream to be ACTIVE again.").BoolVar(&waitActive)
+
+  wait = app.Command("wait", "Wait for the stream to be ACTIVE or DELETED, showing each status it moves through. Exits non-zero if it doesn't get there in time.")
+  wait.Arg("stream", "Stream to wait for, instead of --stream.").StringVar(&waitStream)
+  waitFor = wait.Flag("for", "Status to wait for <"+strings.Join(waitStatuses, "|")+">.").Default("ACTIVE").Enum(waitStatuses...)
+  wait.Flag("timeout", "How long to wait before giving up.").Default(activeTimeout.String()).DurationVar(&waitTimeout)
+
+  shards = app.Command("shards", "List or reshard the shards of the stream.")
+  shardsList = shards.Command("list", "List the shards of the stream, with their hash key and sequence number ranges and parents.").Default()
+  shardsSplit = shards.Command("split", "Split a shard in two, wait for the stream to be ACTIVE and show the shards before and after.")
+  shardsSplit.Arg("shard", "Shard to split.").Required().StringVar(&reshardShardID)
+  shardsSplit.Flag("at", "Hash key the second shard starts at.").StringVar(&splitAt)
+  shardsSplit.Flag("even", "Split the hash key range in half, the default.").BoolVar(&splitEven)
+  shardsMerge = shards.Command("merge", "Merge two shards with adjacent hash key ranges, wait for the stream to be ACTIVE and show the shards before and after.")
+  shardsMerge.Arg("shard", "Shard to merge.").Required().StringVar(&reshardShardID)
+  shardsMerge.Arg("adjacent-shard", "Shard next to it to merge with.").Required().StringVar(&reshardAdjacent)
+  shardsScale = shards.Command("scale", "Change the number of shards, evenly sized, wait for the stream to be ACTIVE and show the shards before and after.")
+  shardsScale.Arg("count", "Number of shards, from half to double the open shards.").Required().Int64Var(&scaleCount)
+  whichShard = app.Command("which-shard", "Work out which open shard of the stream a partition key lands on.")
+  whichShard.Arg("partition-key", "Partition key to look up.").Required().StringVar(&whichShardKey)
+
+  audit = app.Command("audit", "Show the calls that created, deleted, resharded or changed streams, from the audit log.")
+  audit.Arg("stream", "Only show calls on this stream.").StringVar(&auditStream)
+  audit.Flag("since", "Only show calls from this time on, e.g. 2006-01-02T15:04Z, or from this long ago, e.g. 24h.").StringVar(&auditSince)
+  audit.Flag("until", "Only show calls before this time, or before this long ago.").StringVar(&auditUntil)
+  audit.Flag("jsonl", "Show the entries as they are in the log, one JSON object a line.").BoolVar(&auditJSONL)
+
+  serve = app.Command("serve", "Run a local Kinesis that answers the Kinesis JSON API over HTTP, for development and tests without AWS.")
+  serve.Flag("host", "Listen on this address. It has no authentication, so only localhost by default, 0.0.0.0 for every interface.").Default("127.0.0.1").StringVar(&serveHost)
+  serve.Flag("port", "Listen on this port.").Default("4567").IntVar(&servePort)
+  serve.Flag("data-dir", "Keep the streams and their records in this directory between runs. Without it they're only in memory.").StringVar(&serveDataDir)
+  serve.Flag("state-delay", "How long streams stay CREATING, UPDATING or DELETING.").Default("0s").DurationVar(&serveStateDelay)
 
   kingpin.CommandLine.Help = `A command-line AWS Kinesis application.
-  Spur reads from the environment or ~/.aws/credentials for AWS credentials in the usual way. Unfortunately
-  it doesn't read out the ~/.aws/configuration file for other informaiton (e.g. region).
+  Named targets in ~/.spur.yaml (or ./.spur.yaml for a project) bundle the region, stream, partition
+  strategy, read position and output format, pick one with -T <target>.
+  Spur finds AWS credentials in the environment or ~/.aws/credentials in the usual way. With --profile
+  (or $AWS_PROFILE) it uses that profile from ~/.aws/credentials and ~/.aws/config, including its region
+  and any role_arn to assume, through source_profile chains of roles. --endpoint-url points spur at
+  another Kinesis, such as spur serve.
+  Streams listed as protected in .spur.yaml, or tagged spur:protected=true, can't be deleted, and
+  their names must be typed in to reshard them or cut their retention. Every call that changes a stream
+  is logged to ~/.spur/audit.log, see spur audit.
   `
 }
 
@@ -95,18 +291,33 @@ func main() {
   // Parse the command line to fool with flags and get the command we'll execeute.
   command := kingpin.MustParse(app.Parse(os.Args[1:]))
 
-  // Flag messiness.
-  shardIteratorType := *iteratorType
+  // Settings not on the command line come from the target, then the defaults.
+  var err error
+  spurConfig, err = LoadSpurConfig(spurConfigFiles()...)
+  if err != nil {
+    log.Fatal(err)
+  }
+  target, err := spurConfig.Target(targetName)
+  if err != nil {
+    log.Fatal(err)
+  }
+  resolveSettings(target)
 
-  if tail {
-    // read = true;
-    shardIteratorType = "LATEST"
+  // Flag messiness, only reading cares where it starts.
+  reads := command == read.FullCommand() || command == interactive.FullCommand()
+  shardIteratorType, startingSequenceNumber, startingTime := *iteratorType, "", time.Time{}
+  if reads {
+    shardIteratorType, startingSequenceNumber, startingTime, err = startingPosition(*iteratorType)
+    if err != nil {
+      log.Fatal(err)
+    }
   }
 
   // The AWS library doesn't read configuariton information
   // out of .aws/config, just the credentials from .aws/credentials.
-  if aws.DefaultConfig.Region == "" {
-    aws.DefaultConfig.Region = region
+  awsConfig, err = LoadAWSConfig(AWSOptions{Profile: profile, Region: region, EndpointURL: endpointURL})
+  if err != nil {
+    log.Fatal(err)
   }
 
   // Say Hello
@@ -114,35 +325,174 @@ func main() {
     // fmt.Println(aws.DefaultConfig.Credentials.Get())
     fmt.Println("\nOpening up kinesies stream:", stream)
     fmt.Println("To partition:", partition)
-    fmt.Println("In region:", region)
+    fmt.Println("With partition strategy:", partitionStrategy)
+    fmt.Println("In region:", awsConfig.Region)
+    if awsConfig.Endpoint != "" {
+      fmt.Println("At endpoint:", awsConfig.Endpoint)
+    }
   }
 
   // List of commands as parsed matched against functions to execute the commands.
   commandMap := map[string]func(*KinesisStream){
     // interactive.FullCommand(): doInteractive,
-    genFile.FullCommand():     doPutFile,
-    genItr.FullCommand():      doIterate,
-    genPrompt.FullCommand():   doPrompt,
-    read.FullCommand():        doRead,
+    genFile.FullCommand():          doPutFile,
+    genItr.FullCommand():           doIterate,
+    genPrompt.FullCommand():        doPrompt,
+    genLoad.FullCommand():          doLoad,
+    read.FullCommand():             doRead,
+    checkpointsList.FullCommand():  doListCheckpoints,
+    checkpointsShow.FullCommand():  doShowCheckpoint,
+    checkpointsReset.FullCommand(): doResetCheckpoint,
+    list.FullCommand():             doList,
+    create.FullCommand():           doCreate,
+    update.FullCommand():           doUpdate,
+    wait.FullCommand():             doWait,
+    shardsList.FullCommand():       doShards,
+    shardsSplit.FullCommand():      doSplitShard,
+    shardsMerge.FullCommand():      doMergeShards,
+    shardsScale.FullCommand():      doScaleShards,
+    whichShard.FullCommand():       doWhichShard,
+    audit.FullCommand():            doAudit,
+    serve.FullCommand():            doServe,
   }
 
   // Set up Kinesis.
-  kinesisStream := NewStream(aws.DefaultConfig, stream, partition, shardIteratorType, shardID)
+  kinesisStream := NewStream(awsConfig, stream, partition, shardIteratorType, shardID)
+  if reads {
+    err = kinesisStream.SetStartingPosition(shardIteratorType, startingSequenceNumber, startingTime)
+    if err != nil {
+      log.Fatal(err)
+    }
+  }
+  kinesisStream.Partitioner, err = NewPartitionStrategy(partitionStrategy, partition, kinesisStream)
+  if err != nil {
+    log.Fatal(err)
+  }
+  // The envelope flags belong to gen, other commands keep the log envelope.
+  if strings.HasPrefix(command, gen.FullCommand()+" ") {
+    kinesisStream.Envelope, err = NewEnvelope(genEnvelopeFormat(), timeFormat)
+    if err != nil {
+      log.Fatal(err)
+    }
+  }
+  if read.FullCommand() == command && checkpointName != "" {
+    kinesisStream.Checkpoint, err = LoadCheckpoint(checkpointName)
+    if err == nil {
+      err = kinesisStream.Checkpoint.Use(kinesisStream.Name)
+    }
+    if err != nil {
+      log.Fatal(err)
+    }
+  }
 
   // Execute the command.
   if interactive.FullCommand() == command {
-    streamGroup, err := NewStreamGroup(aws.DefaultConfig)
-    streamGroup.CurrentStream = kinesisStream
+    streamGroup, err := NewStreamGroup(awsConfig)
+    if err != nil {
+      log.Fatal(err)
+    }
+    err = streamGroup.SetDefaults(StreamSettings{Partition: partition, PartitionStrategy: partitionStrategy,
+      ShardID: shardID, IteratorType: *iteratorType, Output: *outputFormat})
     if err != nil {
       log.Fatal(err)
     }
-    doInteractive(streamGroup)
+    // The stream from the command line stands in for the group's, so settings stick to it.
+    kinesisStream.Output = *outputFormat
+    if _, ok := streamGroup.Streams[kinesisStream.Name]; ok {
+      streamGroup.Streams[kinesisStream.Name] = kinesisStream
+    }
+    streamGroup.CurrentStream = kinesisStream
+    if transcriptFile != "" {
+      if interTranscript, err = OpenTranscript(transcriptFile); err != nil {
+        log.Fatal(err)
+      }
+    }
+    if scriptFile != "" || len(scriptCommands) > 0 {
+      err = doScript(streamGroup)
+    } else {
+      doInteractive(streamGroup)
+    }
+    interTranscript.Close()
+    if err != nil {
+      os.Exit(1)
+    }
   } else {
     commandMap[command](kinesisStream)
   }
 
 }
 
+// Fill in the settings the command line left empty from the target,
+// then from the defaults.
+func resolveSettings(t *Target) {
+  if t != nil {
+    setDefault(&profile, t.Profile)
+    setDefault(&region, t.Region)
+    setDefault(&endpointURL, t.Endpoint)
+    setDefault(&stream, t.Stream)
+    setDefault(&partition, t.Partition)
+    setDefault(&partitionStrategy, t.PartitionStrategy)
+    setDefault(&shardID, t.ShardID)
+    // A read position on the command line replaces the target's.
+    if *iteratorType == "" && fromSeq == "" && afterSeq == "" && since == "" {
+      since = t.Since
+      setDefault(iteratorType, t.IteratorType)
+    }
+    setDefault(outputFormat, t.Output)
+  }
+  setDefault(&stream, defaultStream)
+  setDefault(&partition, defaultPartition)
+  setDefault(&partitionStrategy, "static")
+  setDefault(&shardID, defaultShardID)
+  setDefault(iteratorType, defaultIteratorType)
+  setDefault(outputFormat, "text")
+}
+
+// --envelope wins, otherwise --log (on by default) or --no-log pick log or raw.
+func genEnvelopeFormat() string {
+  if *envelopeFormat != "" {
+    return *envelopeFormat
+  }
+  if genLog {
+    return "log"
+  }
+  return "raw"
+}
+
+// Work out where reading starts. --from-seq, --after-seq and --since
+// pick the iterator type themselves, only one of them can be used.
+// Otherwise --tail starts at LATEST, or we use --iterator-type.
+func startingPosition(iteratorType string) (string, string, time.Time, error) {
+  var timestamp time.Time
+  sequenceNumber := ""
+  positions := 0
+
+  if fromSeq != "" {
+    iteratorType, sequenceNumber = "AT_SEQUENCE_NUMBER", fromSeq
+    positions++
+  }
+  if afterSeq != "" {
+    iteratorType, sequenceNumber = "AFTER_SEQUENCE_NUMBER", afterSeq
+    positions++
+  }
+  if since != "" {
+    t, err := parseTimestamp(since)
+    if err != nil {
+      return "", "", timestamp, err
+    }
+    iteratorType, timestamp = "AT_TIMESTAMP", t
+    positions++
+  }
+
+  if positions > 1 {
+    return "", "", timestamp, errors.New("Only one of --from-seq, --after-seq or --since can be used.")
+  }
+  if positions == 0 && tail {
+    iteratorType = "LATEST"
+  }
+  return iteratorType, sequenceNumber, timestamp, nil
+}
+
 func doPutFile(s *KinesisStream) {
 
   if file == nil {
@@ -159,22 +509,23 @@ func doPutFile(s *KinesisStream) {
     defer file.Close()
   }
 
+  source := &LineSource{File: file.Name()}
+  if file == os.Stdin {
+    source.File = "stdin"
+  }
+
+  p := newGenProducer(s)
   scanner := bufio.NewScanner(file)
-  i := 0
   for scanner.Scan() {
-    resp, err := s.PutLogLine(scanner.Text())
-    if err != nil {
-      printAWSError(err)
-    }
-    if err = scanner.Err(); err != nil {
-      log.Fatal(err)
+    source.Line++
+    if err := p.PutLine(scanner.Text(), source); err != nil {
+      break
     }
-    if verbose {
-      fmt.Printf("Put line %d\n", i)
-      fmt.Printf("Resp: %s\n", resp)
-    }
-    i++
   }
+  if err := scanner.Err(); err != nil {
+    log.Fatal(err)
+  }
+  closeGenProducer(p)
 }
 
 // Generate data by iterating a test string out to the stream.
@@ -185,18 +536,71 @@ func doIterate(s *KinesisStream) {
     fmt.Printf("Using the string: %s\n", testString)
   }
 
+  p := newGenProducer(s)
   for i := 0; i < numberOfIterations; i++ {
     line := fmt.Sprintf("%s %d", testString, i)
-    resp, err := s.PutLogLine(line)
-    if err != nil {
-      log.Fatal(err)
+    if err := p.PutLogLine(line); err != nil {
+      break
     }
+  }
+  closeGenProducer(p)
+}
 
-    if verbose {
-      if i%100 == 0 {
-        fmt.Printf("%d iteration %s\n", i, resp)
-      }
-    }
+// Put a steady load on the stream and report how it went.
+func doLoad(s *KinesisStream) {
+  rate, err := parseRate(loadRate)
+  if err != nil {
+    log.Fatal(err)
+  }
+  size, err := parseSize(loadSize)
+  if err != nil {
+    log.Fatal(err)
+  }
+  opts := LoadOptions{Rate: rate, Duration: loadDuration, Size: size, Workers: loadWorkers, Template: loadTemplate}
+  if verbose {
+    fmt.Printf("Putting %.0f records/s of %s for %s with %d workers.\n", rate, fmtBytes(float64(size)), loadDuration, loadWorkers)
+    opts.Progress = func(r *LoadReport) { fmt.Println(r) }
+  }
+
+  // Stop early, with a report, on <ctrl-c>.
+  ctx, cancel := context.WithCancel(context.Background())
+  interrupt := make(chan os.Signal, 1)
+  signal.Notify(interrupt, os.Interrupt)
+  go func() {
+    <-interrupt
+    cancel()
+  }()
+
+  report, err := s.RunLoad(ctx, opts)
+  signal.Stop(interrupt)
+  cancel()
+  if err != nil {
+    log.Fatal(err)
+  }
+  fmt.Print(report.Description())
+  if report.Records == 0 && report.Errors > 0 {
+    os.Exit(1)
+  }
+}
+
+// Batch up gen writes according to the flags.
+func newGenProducer(s *KinesisStream) *Producer {
+  p := s.NewProducer(flushInterval)
+  p.BatchRecords = batchSize
+  p.Verbose = verbose
+  return p
+}
+
+// Send what's left and report, a failure to put everything is fatal.
+func closeGenProducer(p *Producer) {
+  err := p.Close()
+  sent, failed := p.Stats()
+  if verbose || err != nil {
+    fmt.Printf("Put %d records, %d failed.\n", sent, failed)
+  }
+  if err != nil {
+    printAWSError(err)
+    os.Exit(1)
   }
 }
 
@@ -225,14 +629,29 @@ func doPrompt(s *KinesisStream) {
 // Read string and print them fromt he stream.
 func doRead(s *KinesisStream) {
 
+  if allShards {
+    doReadAllShards(s)
+    return
+  }
+
   if verbose {
     fmt.Println("\nReading from shard: ", s.ShardID)
     fmt.Println("With iterator type:", s.ShardIteratorType)
+    if p := s.startingPositionDescription(); p != "" {
+      fmt.Print(p)
+    }
+    if s.Checkpoint != nil {
+      if seq, ok := s.Checkpoint.SequenceNumber(s.ShardID); ok {
+        fmt.Println("Resuming from checkpoint", s.Checkpoint.Name, "after:", seq)
+      }
+    }
   }
 
   var msecBehind int64 = 0
   var lastDelay int64 = 0
   emptyReads := 0
+  out := NewRecordWriter(os.Stdout, s.Name, *outputFormat, *dataEncoding)
+  out.Unwrap = unwrapRecords
 
   s.ReadReset()
   for moreData := true; moreData; {
@@ -278,14 +697,180 @@ func doRead(s *KinesisStream) {
     }
 
     for i, record := range output.Records {
-      if verbose {
+      if verbose && !out.Structured() {
         fmt.Println("Data record: ", i+1)
         fmt.Println("Partition: ", *record.PartitionKey)
         fmt.Println("SequenceNumber: ", *record.SequenceNumber)
         fmt.Printf("Data: ")
       }
-      fmt.Println(string(record.Data))
+      if err = out.Write(s.ShardID, record); err != nil {
+        log.Fatal(err)
+      }
     }
+    if err = s.CheckpointRecords(output.Records); err != nil {
+      log.Fatal(err)
+    }
+
+    // The shard was closed by a split or merge, carry on with its children.
+    if s.ShardClosed {
+      others, err := s.FollowChildShards()
+      if err != nil {
+        printAWSError(err)
+        log.Fatal(err)
+      }
+      if len(others) > 0 {
+        if verbose {
+          fmt.Println("Shard", s.ShardID, "was split, now reading:", strings.Join(others, ", "))
+        }
+        records, errs := s.ReadShards(context.Background(), others, tail, time.Duration(sleepMilli)*time.Millisecond)
+        if err = writeShardRecords(out, records, errs, verbose, s.Checkpoint); err != nil {
+          os.Exit(1)
+        }
+        return
+      }
+      moreData = !s.ShardClosed
+      if verbose && moreData {
+        fmt.Println("Shard was closed, now reading from shard:", s.ShardID)
+      }
+    }
+  }
+}
+
+// Read every open shard of the stream, merging the records.
+func doReadAllShards(s *KinesisStream) {
+
+  if verbose {
+    fmt.Println("\nReading from all open shards with iterator type:", s.ShardIteratorType)
+    if p := s.startingPositionDescription(); p != "" {
+      fmt.Print(p)
+    }
+  }
+
+  sleep := time.Duration(sleepMilli) * time.Millisecond
+  records, errs, err := s.ReadAllShards(context.Background(), tail, sleep)
+  if err != nil {
+    printAWSError(err)
+    log.Fatal(err)
+  }
+
+  if orderedRead {
+    records = orderRecords(records, tail, sleep)
+  }
+
+  out := NewRecordWriter(os.Stdout, s.Name, *outputFormat, *dataEncoding)
+  out.Unwrap = unwrapRecords
+  if err = writeShardRecords(out, records, errs, verbose, s.Checkpoint); err != nil {
+    os.Exit(1)
+  }
+}
+
+func doListCheckpoints(s *KinesisStream) {
+  list, err := ListCheckpoints()
+  if err != nil {
+    log.Fatal(err)
+  }
+  if len(list) == 0 && verbose {
+    fmt.Println("There are no checkpoints in", checkpointDir)
+  }
+  for _, c := range list {
+    fmt.Println(c)
+  }
+}
+
+func doShowCheckpoint(s *KinesisStream) {
+  c, err := LoadCheckpoint(checkpointName)
+  if err != nil {
+    log.Fatal(err)
+  }
+  fmt.Print(c.Description())
+}
+
+func doResetCheckpoint(s *KinesisStream) {
+  c, err := LoadCheckpoint(checkpointName)
+  if err == nil {
+    err = c.Reset(checkpointShardID)
+  }
+  if err != nil {
+    log.Fatal(err)
+  }
+  if verbose {
+    fmt.Println("Reset checkpoint:", checkpointName)
+  }
+}
+
+func doList(s *KinesisStream) {
+  g := &KinesisStreamGroup{Streams: make(map[string]*KinesisStream), Service: s.Service, Region: awsConfig.Region}
+  if err := listStreams(os.Stdout, g, listQuery, *listSort, listReverse, *listOutput); err != nil {
+    log.Fatal(err)
+  }
+}
+
+func doCreate(s *KinesisStream) {
+  config, err := NewStreamConfig(retention, tags, nil, encryption)
+  if err != nil {
+    log.Fatal(err)
+  }
+  // No need to list the streams for a group of one.
+  g := &KinesisStreamGroup{Streams: make(map[string]*KinesisStream), Service: s.Service, Region: awsConfig.Region}
+  name := s.Name
+  if createName != "" {
+    name = createName
+  }
+  if _, err = createStream(os.Stdout, g, name, createShards, config, waitActive); err != nil {
+    log.Fatal(err)
+  }
+}
+
+func doUpdate(s *KinesisStream) {
+  config, err := NewStreamConfig(retention, tags, untags, encryption)
+  if err == nil {
+    err = updateStream(os.Stdout, s, config, waitActive)
+  }
+  if err != nil {
+    log.Fatal(err)
+  }
+}
+
+func doWait(s *KinesisStream) {
+  if waitStream != "" {
+    s = NewStreamWithService(s.Service, waitStream, "", "", "")
+  }
+  if err := waitForStream(os.Stdout, s, *waitFor, waitTimeout); err != nil {
+    log.Fatal(err)
+  }
+}
+
+func doShards(s *KinesisStream) {
+  shards, err := s.GetShards()
+  if err != nil {
+    log.Fatal(err)
+  }
+  writeShards(os.Stdout, shards)
+}
+
+func doSplitShard(s *KinesisStream) {
+  if err := splitShard(os.Stdout, s, reshardShardID, splitAt, splitEven); err != nil {
+    log.Fatal(err)
+  }
+}
+
+func doMergeShards(s *KinesisStream) {
+  err := Reshard(os.Stdout, s, func() error { return s.MergeShards(reshardShardID, reshardAdjacent) })
+  if err != nil {
+    log.Fatal(err)
+  }
+}
+
+func doScaleShards(s *KinesisStream) {
+  err := Reshard(os.Stdout, s, func() error { return s.UpdateShardCount(scaleCount) })
+  if err != nil {
+    log.Fatal(err)
+  }
+}
+
+func doWhichShard(s *KinesisStream) {
+  if err := writeWhichShard(os.Stdout, s, whichShardKey); err != nil {
+    log.Fatal(err)
   }
 }
 
@@ -293,9 +878,101 @@ func doInteractive(g *KinesisStreamGroup) {
 
   // why can't I declare this inline in the promptLoop call?
   xICommand := func(line string) (err error) {return DoICommand(line, g)}
+  readline.Completer = newInterCompleter(g).Complete
   prompt := g.CurrentStream.Name + "(" + g.Region + ") >"
-  err := promptLoop(prompt, xICommand)
+  history, err := LoadHistory(historyFile(), historySize)
+  if err != nil {
+    fmt.Printf("Error - reading history: %s.\n", err)
+  }
+  err = promptLoop(prompt, history, xICommand)
   if err != nil {fmt.Printf("Error - %s.\n", err)}
+  interJobs.KillAll()
 }
 
+// Run the commands from -c and then -f, rather than prompting for them.
+// The error is the first command that failed, already reported.
+func doScript(g *KinesisStreamGroup) (err error) {
+  defer interJobs.KillAll()
+  if len(scriptCommands) > 0 {
+    err = RunIScript(strings.NewReader(strings.Join(scriptCommands, "\n")), "-c", g, stopOnError)
+    if err != nil && stopOnError {
+      return err
+    }
+  }
+  if scriptFile != "" {
+    if _, serr := os.Stat(scriptFile); scriptFile != "-" && serr != nil {
+      fmt.Fprintf(os.Stderr, "Error - %s.\n", serr)
+      return serr
+    }
+    ferr := RunIScriptFile(scriptFile, g, stopOnError)
+    if err == nil {
+      err = ferr
+    }
+  }
+  return err
+}
+
+
+
+// Show the audit log entries for a stream and time range.
+func doAudit(s *KinesisStream) {
+  if auditLogFile == "" {
+    log.Fatal("There's no audit log to show with --audit-log empty.")
+  }
+  var q AuditQuery
+  var err error
+  q.Stream = auditStream
+  if auditSince != "" {
+    if q.Since, err = parseTimestamp(auditSince); err != nil {
+      log.Fatal(err)
+    }
+  }
+  if auditUntil != "" {
+    if q.Until, err = parseTimestamp(auditUntil); err != nil {
+      log.Fatal(err)
+    }
+  }
+  entries, err := (&AuditLog{File: auditLogFile}).Query(q)
+  writeAudit(os.Stdout, entries, auditJSONL)
+  if err != nil {
+    log.Fatal(err)
+  }
+}
 
+// Serve the Kinesis API until interrupted, saving the streams on the way out.
+func doServe(s *KinesisStream) {
+  server, err := NewKinesisServer(serveDataDir)
+  if err != nil {
+    log.Fatal(err)
+  }
+  server.Service.StateDelay = serveStateDelay
+  server.Service.Region = awsConfig.Region
+  server.Verbose = verbose
+
+  done := make(chan struct{})
+  maintained := make(chan struct{})
+  go func() {
+    server.Maintain(done)
+    close(maintained)
+  }()
+
+  address := net.JoinHostPort(serveHost, strconv.Itoa(servePort))
+  listener, err := net.Listen("tcp", address)
+  if err != nil {
+    log.Fatal(err)
+  }
+  go http.Serve(listener, server)
+  fmt.Printf("Serving Kinesis on http://%s", address)
+  if serveDataDir != "" {
+    fmt.Printf(", keeping streams in %s", serveDataDir)
+  }
+  fmt.Println(". <ctrl-c> to stop.")
+
+  interrupt := make(chan os.Signal, 1)
+  signal.Notify(interrupt, os.Interrupt)
+  <-interrupt
+  signal.Stop(interrupt)
+  listener.Close()
+  close(done)
+  <-maintained
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+  "fmt"
+  "gopkg.in/yaml.v2"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "sort"
+)
+
+// Settings used when neither the command line nor a target gives one.
+const (
+  defaultStream       = "JDR_TestStream_1"
+  defaultPartition    = "PARTITION"
+  defaultShardID      = "shardId-000000000001"
+  defaultIteratorType = "LATEST"
+)
+
+// SpurConfig is read from ~/.spur.yaml, then ./.spur.yaml for the project,
+// whose targets replace those of the same name. For example:
+//
+//   default: dev
+//   targets:
+//     prod-clicks:
+//       profile: prod
+//       region: us-east-1
+//       stream: clicks
+//       partition-strategy: hash
+//       iterator-type: TRIM_HORIZON
+//       output: jsonl
+//     dev:
+//       endpoint: http://localhost:4567
+//       stream: clicks
+//   protected: [clicks, "prod-*"]
+//
+// Protected streams, by name or glob, can't be deleted, and their names
+// must be typed in to reshard them or cut their retention. Both files'
+// protected streams count.
+type SpurConfig struct {
+  Default   string             `yaml:"default"`
+  Targets   map[string]*Target `yaml:"targets"`
+  Protected []string           `yaml:"protected"`
+}
+
+// Target bundles where a stream is and how to read and write it.
+// Flags given on the command line win over the target's settings.
+type Target struct {
+  Profile           string `yaml:"profile"`
+  Region            string `yaml:"region"`
+  Endpoint          string `yaml:"endpoint"`
+  Stream            string `yaml:"stream"`
+  Partition         string `yaml:"partition"`
+  PartitionStrategy string `yaml:"partition-strategy"`
+  ShardID           string `yaml:"shard-id"`
+  IteratorType      string `yaml:"iterator-type"`
+  Since             string `yaml:"since"`
+  Output            string `yaml:"output"`
+}
+
+func spurConfigFiles() []string {
+  home, _ := os.UserHomeDir()
+  return []string{filepath.Join(home, ".spur.yaml"), ".spur.yaml"}
+}
+
+func LoadSpurConfig(files ...string) (*SpurConfig, error) {
+  config := &SpurConfig{Targets: make(map[string]*Target)}
+  for _, file := range files {
+    data, err := ioutil.ReadFile(file)
+    if os.IsNotExist(err) {
+      continue
+    }
+    if err != nil {
+      return nil, err
+    }
+    layer := &SpurConfig{}
+    if err = yaml.UnmarshalStrict(data, layer); err != nil {
+      return nil, fmt.Errorf("%s: %s", file, err)
+    }
+    for name, target := range layer.Targets {
+      if err = target.validate(); err != nil {
+        return nil, fmt.Errorf("%s: target %s: %s", file, name, err)
+      }
+      config.Targets[name] = target
+    }
+    if layer.Default != "" {
+      config.Default = layer.Default
+    }
+    for _, pattern := range layer.Protected {
+      if _, err = filepath.Match(pattern, ""); err != nil {
+        return nil, fmt.Errorf("%s: protected %s: %s", file, pattern, err)
+      }
+    }
+    config.Protected = append(config.Protected, layer.Protected...)
+  }
+  if config.Default != "" && config.Targets[config.Default] == nil {
+    return nil, fmt.Errorf("The default target %s isn't defined.", config.Default)
+  }
+  return config, nil
+}
+
+func (t *Target) validate() error {
+  if t == nil {
+    return fmt.Errorf("has no settings")
+  }
+  if t.IteratorType != "" && !contains(iteratorTypes, t.IteratorType) {
+    return fmt.Errorf("iterator-type must be one of %v, not %s", iteratorTypes, t.IteratorType)
+  }
+  if t.Output != "" && !contains(outputFormats, t.Output) {
+    return fmt.Errorf("output must be one of %v, not %s", outputFormats, t.Output)
+  }
+  return nil
+}
+
+// Target looks up a target by name, or the default target if name is empty.
+// With no name and no default there is no target, and no error.
+func (c *SpurConfig) Target(name string) (*Target, error) {
+  if name == "" {
+    name = c.Default
+  }
+  if name == "" {
+    return nil, nil
+  }
+  t := c.Targets[name]
+  if t == nil {
+    return nil, fmt.Errorf("No target named %s in %v.", name, spurConfigFiles())
+  }
+  return t, nil
+}
+
+// The names of the targets, in order.
+func (c *SpurConfig) Names() (names []string) {
+  for name := range c.Targets {
+    names = append(names, name)
+  }
+  sort.Strings(names)
+  return names
+}
+
+// Set a setting that hasn't already been set.
+func setDefault(setting *string, value string) {
+  if *setting == "" {
+    *setting = value
+  }
+}
+
+func contains(list []string, s string) bool {
+  for _, item := range list {
+    if item == s {
+      return true
+    }
+  }
+  return false
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "testing"
+  "time"
+  "github.com/aws/aws-sdk-go/aws"
+  "github.com/aws/aws-sdk-go/service/kinesis"
+  . "github.com/smartystreets/goconvey/convey"
+)
+
+const testHomeSpurConfig = `
+default: dev
+targets:
+  dev:
+    stream: dev-clicks
+    partition-strategy: round-robin
+  prod-clicks:
+    region: us-east-1
+    stream: clicks
+    iterator-type: TRIM_HORIZON
+    output: jsonl
+protected: [clicks]
+`
+
+const testProjectSpurConfig = `
+targets:
+  dev:
+    stream: project-clicks
+    partition-strategy: hash
+    since: 30m
+protected: ["prod-*"]
+`
+
+func TestSpurConfig(t *testing.T) {
+
+  Convey("Given home and project spur config files", t, func() {
+    dir, _ := ioutil.TempDir("", "spur-config")
+    defer os.RemoveAll(dir)
+    home, project := filepath.Join(dir, "home.yaml"), filepath.Join(dir, "project.yaml")
+    ioutil.WriteFile(home, []byte(testHomeSpurConfig), 0600)
+    ioutil.WriteFile(project, []byte(testProjectSpurConfig), 0600)
+
+    config, err := LoadSpurConfig(home, project, filepath.Join(dir, "missing.yaml"))
+    So(err, ShouldBeNil)
+
+    Convey("Project targets should replace home targets of the same name", func() {
+      So(config.Names(), ShouldResemble, []string{"dev", "prod-clicks"})
+      t, err := config.Target("")
+      So(err, ShouldBeNil)
+      So(t.Stream, ShouldEqual, "project-clicks")
+      t, err = config.Target("prod-clicks")
+      So(err, ShouldBeNil)
+      So(t.Region, ShouldEqual, "us-east-1")
+      _, err = config.Target("nope")
+      So(err, ShouldNotBeNil)
+    })
+
+    Convey("Both files' protected streams should count", func() {
+      So(config.Protected, ShouldResemble, []string{"clicks", "prod-*"})
+    })
+
+    Convey("Bad settings should be reported", func() {
+      ioutil.WriteFile(project, []byte("targets:\n  bad:\n    output: xml\n"), 0600)
+      _, err := LoadSpurConfig(home, project)
+      So(err, ShouldNotBeNil)
+      ioutil.WriteFile(project, []byte("targets:\n  bad:\n    strem: typo\n"), 0600)
+      _, err = LoadSpurConfig(home, project)
+      So(err, ShouldNotBeNil)
+      ioutil.WriteFile(project, []byte("protected: [\"prod-[\"]\n"), 0600)
+      _, err = LoadSpurConfig(home, project)
+      So(err, ShouldNotBeNil)
+    })
+
+    Convey("Flags should win over the target, and the target over the defaults", func() {
+      defer func() {
+        stream, partition, partitionStrategy, shardID, region, since = "", "", "", "", "", ""
+        *iteratorType, *outputFormat = "", ""
+      }()
+      stream = "from-flag"
+      t, _ := config.Target("prod-clicks")
+      resolveSettings(t)
+      So(stream, ShouldEqual, "from-flag")
+      So(region, ShouldEqual, "us-east-1")
+      So(*iteratorType, ShouldEqual, "TRIM_HORIZON")
+      So(*outputFormat, ShouldEqual, "jsonl")
+      So(partition, ShouldEqual, defaultPartition)
+      So(partitionStrategy, ShouldEqual, "static")
+      So(shardID, ShouldEqual, defaultShardID)
+    })
+  })
+
+  Convey("Given a target for a stream on a fake Kinesis", t, func() {
+    svc := NewFakeKinesis()
+    for _, name := range []string{"a", "clicks"} {
+      svc.CreateStream(&kinesis.CreateStreamInput{StreamName: aws.String(name), ShardCount: aws.Long(2)})
+    }
+    g, _ := NewStreamGroupWithService(svc, "us-west-1")
+    g.CurrentStream = g.Streams["a"]
+    saved := spurConfig
+    defer func() { spurConfig = saved }()
+    spurConfig = &SpurConfig{Targets: map[string]*Target{
+      "hot": &Target{Stream: "clicks", PartitionStrategy: "round-robin", IteratorType: "TRIM_HORIZON"},
+      "recent": &Target{Stream: "clicks", Since: "30m"},
+      "broken": &Target{Stream: "clicks", PartitionStrategy: "round-robin", Since: "not a time"},
+    }}
+
+    Convey("Using the target should make its stream current, set up as the target says", func() {
+      captureStdout(func() {
+        So(DoICommand("use hot", g), ShouldBeNil)
+      })
+      So(g.CurrentStream.Name, ShouldEqual, "clicks")
+      So(g.CurrentStream.ShardIteratorType, ShouldEqual, "TRIM_HORIZON")
+      So(g.CurrentStream.Partitioner.String(), ShouldEqual, "round-robin")
+    })
+
+    Convey("A target's since should start reading at that time", func() {
+      captureStdout(func() {
+        So(DoICommand("use recent", g), ShouldBeNil)
+      })
+      So(g.CurrentStream.ShardIteratorType, ShouldEqual, "AT_TIMESTAMP")
+      So(time.Since(g.CurrentStream.StartingTimestamp), ShouldAlmostEqual, 30*time.Minute, time.Minute)
+    })
+
+    Convey("A target that can't be used should leave the session as it was", func() {
+      strategy := g.Streams["clicks"].Setting("partition-strategy")
+      captureStdout(func() {
+        So(DoICommand("use broken", g), ShouldNotBeNil)
+      })
+      So(g.CurrentStream.Name, ShouldEqual, "a")
+      So(g.Streams["clicks"].Setting("partition-strategy"), ShouldEqual, strategy)
+    })
+
+    Convey("Stream names should still work", func() {
+      captureStdout(func() {
+        So(DoICommand("use clicks", g), ShouldBeNil)
+      })
+      So(g.CurrentStream.Name, ShouldEqual, "clicks")
+    })
+  })
+}
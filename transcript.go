@@ -0,0 +1,143 @@
+package main
+
+import (
+  "bytes"
+  "errors"
+  "fmt"
+  "os"
+  "sync"
+  "time"
+)
+
+// Layout of the times in a transcript.
+const transcriptTimeFormat = "2006-01-02T15:04:05.000Z07:00"
+
+// How long stdout and stderr have to be quiet before a command is recorded,
+// so the output of the one before comes ahead of it.
+var transcriptFlushWait = 10 * time.Millisecond
+
+// Transcript records an interactive session in a file: every command and
+// every line of output, each with the time it happened. Output lines start
+// with a space, or a ! for stderr. It takes over stdout and stderr until
+// it's closed.
+type Transcript struct {
+  file    *os.File
+  mu      sync.Mutex
+  outputs []*transcribed
+}
+
+// An output the transcript takes over, piped through to where it went before.
+type transcribed struct {
+  target  **os.File
+  orig    *os.File
+  r, w    *os.File
+  kind    string
+  flushed chan struct{}
+  done    chan struct{}
+}
+
+// OpenTranscript appends the session to the named file.
+func OpenTranscript(name string) (*Transcript, error) {
+  file, err := os.OpenFile(name, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+  if err != nil {
+    return nil, err
+  }
+  t := &Transcript{file: file}
+  host, _ := os.Hostname()
+  t.record("#", fmt.Sprintf("spur session started by %s on %s", os.Getenv("USER"), host))
+  for _, output := range []struct {
+    target **os.File
+    kind   string
+  }{{&os.Stdout, " "}, {&os.Stderr, "!"}} {
+    r, w, err := os.Pipe()
+    if err != nil {
+      t.Close()
+      return nil, err
+    }
+    o := &transcribed{target: output.target, orig: *output.target, r: r, w: w, kind: output.kind,
+      flushed: make(chan struct{}), done: make(chan struct{})}
+    *o.target = w
+    t.outputs = append(t.outputs, o)
+    go t.copy(o)
+  }
+  return t, nil
+}
+
+// Command records a command line, after the output that came before it.
+func (t *Transcript) Command(line string) {
+  if t == nil {
+    return
+  }
+  waiting := []*transcribed{}
+  for _, o := range t.outputs {
+    if o.r.SetReadDeadline(time.Now().Add(transcriptFlushWait)) == nil {
+      waiting = append(waiting, o)
+    }
+  }
+  for _, o := range waiting {
+    select {
+      case <-o.flushed:
+      case <-o.done:
+    }
+  }
+  t.record(">", line)
+}
+
+// Close gives stdout and stderr back, once the output so far is recorded.
+func (t *Transcript) Close() error {
+  if t == nil {
+    return nil
+  }
+  for _, o := range t.outputs {
+    *o.target = o.orig
+    o.w.Close()
+    <-o.done
+  }
+  t.record("#", "spur session ended")
+  return t.file.Close()
+}
+
+// Copy an output to where it went before and, a line at a time, to the
+// transcript. A read deadline set by Command is met once nothing more has
+// come for transcriptFlushWait.
+func (t *Transcript) copy(o *transcribed) {
+  defer close(o.done)
+  defer o.r.Close()
+  var out []byte
+  read := false
+  buf := make([]byte, 4096)
+  for {
+    n, err := o.r.Read(buf)
+    for _, b := range buf[:n] {
+      out = append(out, b)
+      if b == '\n' {
+        t.record(o.kind, string(bytes.TrimSuffix(out, []byte("\n"))))
+        out = out[:0]
+      }
+    }
+    o.orig.Write(buf[:n])
+    read = read || n > 0
+    if errors.Is(err, os.ErrDeadlineExceeded) {
+      if read {
+        read = false
+        o.r.SetReadDeadline(time.Now().Add(transcriptFlushWait))
+      } else {
+        o.r.SetReadDeadline(time.Time{})
+        o.flushed <- struct{}{}
+      }
+      continue
+    }
+    if err != nil {
+      break
+    }
+  }
+  if len(out) > 0 {
+    t.record(o.kind, string(out))
+  }
+}
+
+func (t *Transcript) record(kind, line string) {
+  t.mu.Lock()
+  defer t.mu.Unlock()
+  fmt.Fprintf(t.file, "%s %s %s\n", time.Now().Format(transcriptTimeFormat), kind, line)
+}
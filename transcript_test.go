@@ -0,0 +1,80 @@
+package main
+
+import (
+  "fmt"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "strings"
+  "testing"
+  . "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTranscript(t *testing.T) {
+
+  Convey("Given a transcript of an interactive session", t, func() {
+    dir, _ := ioutil.TempDir("", "spur-transcript")
+    defer os.RemoveAll(dir)
+    file := filepath.Join(dir, "transcript")
+    svc, s := newFakeStream("clicks", 1)
+    g, _ := NewStreamGroupWithService(svc, "us-west-1")
+    g.Streams["clicks"] = s
+    g.CurrentStream = s
+    interJobs = NewJobs()
+    defer interJobs.KillAll()
+
+    Convey("Commands and their output should be recorded in order, and still printed", func() {
+      out := captureStdout(func() {
+        var err error
+        interTranscript, err = OpenTranscript(file)
+        So(err, ShouldBeNil)
+        DoICommand("iterate 2 hello", g)
+        DoICommand("read all", g)
+        DoICommand("bogus", g)
+        So(interTranscript.Close(), ShouldBeNil)
+        interTranscript = nil
+      })
+      So(strings.Count(out, "hello: "), ShouldEqual, 2)
+      So(out, ShouldNotContainSubstring, "read all")
+
+      data, _ := ioutil.ReadFile(file)
+      lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+      So(lines[0], ShouldContainSubstring, " # spur session started")
+      So(lines[len(lines)-1], ShouldContainSubstring, " # spur session ended")
+      transcript := string(data)
+      iterate, read, bogus := strings.Index(transcript, "> iterate 2 hello"), strings.Index(transcript, "> read all"), strings.Index(transcript, "> bogus")
+      So(iterate, ShouldBeGreaterThan, 0)
+      So(read, ShouldBeGreaterThan, iterate)
+      So(bogus, ShouldBeGreaterThan, read)
+      So(strings.Index(transcript, "hello: "), ShouldBeBetween, read, bogus)
+      So(transcript[bogus:], ShouldContainSubstring, "Command error")
+    })
+
+    Convey("Any output should reach the terminal as it is, and stderr be recorded too", func() {
+      stderr := os.Stderr
+      r, w, _ := os.Pipe()
+      os.Stderr = w
+      out := captureStdout(func() {
+        var err error
+        interTranscript, err = OpenTranscript(file)
+        So(err, ShouldBeNil)
+        fmt.Println("odd\x1erecord")
+        fmt.Fprintln(os.Stderr, "oops")
+        DoICommand("list", g)
+        So(interTranscript.Close(), ShouldBeNil)
+        interTranscript = nil
+      })
+      os.Stderr = stderr
+      w.Close()
+      errOut, _ := ioutil.ReadAll(r)
+      So(out, ShouldContainSubstring, "odd\x1erecord\n")
+      So(string(errOut), ShouldEqual, "oops\n")
+
+      data, _ := ioutil.ReadFile(file)
+      transcript := string(data)
+      So(transcript, ShouldContainSubstring, "odd\x1erecord\n")
+      So(transcript, ShouldContainSubstring, " ! oops\n")
+      So(strings.Index(transcript, "> list"), ShouldBeGreaterThan, strings.Index(transcript, " ! oops"))
+    })
+  })
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+  "fmt"
+  "io"
+  "github.com/aws/aws-sdk-go/aws/awserr"
+  "time"
+)
+
+// How often to check a stream's status while waiting on it.
+var statusPollInterval = time.Second
+
+// How long to wait for a stream to be ACTIVE again after changing it.
+const activeTimeout = 5 * time.Minute
+
+// The status of a stream that's gone, Kinesis just doesn't find it.
+const statusDeleted = "DELETED"
+
+// The statuses wait can wait for.
+var waitStatuses = []string{"ACTIVE", statusDeleted}
+
+// StatusEvent is a stream seen in a new status while waiting on it.
+type StatusEvent struct {
+  Time   time.Time
+  Stream string
+  Status string
+}
+
+func (e StatusEvent) String() string {
+  return fmt.Sprintf("%s %s is %s.", e.Time.Format(time.RFC3339), e.Stream, e.Status)
+}
+
+// Status of the stream, DELETED once it's gone.
+func (s *KinesisStream) Status() (string, error) {
+  sd, err := s.GetAWSDescription()
+  if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == "ResourceNotFoundException" {
+    return statusDeleted, nil
+  }
+  if err != nil {
+    return "", err
+  }
+  return *sd.StreamStatus, nil
+}
+
+// WaitForStatus waits until the stream has the status, for as long as
+// the timeout. Report, when not nil, is told the status it starts in and
+// each it moves through. It gives up early if the stream can't get there.
+// On a dry run nothing changes, so there's nothing to wait for.
+func (s *KinesisStream) WaitForStatus(status string, timeout time.Duration, report func(StatusEvent)) error {
+  if isDryRun(s.Service) {
+    return nil
+  }
+  deadline := time.Now().Add(timeout)
+  last := ""
+  for {
+    current, err := s.Status()
+    if err != nil {
+      return err
+    }
+    if current != last && report != nil {
+      report(StatusEvent{Time: time.Now(), Stream: s.Name, Status: current})
+    }
+    last = current
+    switch {
+      case current == status:
+        return nil
+      case current == statusDeleted, status != statusDeleted && current == "DELETING":
+        return fmt.Errorf("%s is %s, it won't be %s", s.Name, current, status)
+      case time.Now().After(deadline):
+        return fmt.Errorf("%s is still %s after %s", s.Name, current, timeout)
+    }
+    time.Sleep(statusPollInterval)
+  }
+}
+
+// Wait for a stream, writing each status it moves through.
+func waitForStream(w io.Writer, s *KinesisStream, status string, timeout time.Duration) error {
+  return s.WaitForStatus(status, timeout, func(e StatusEvent) { fmt.Fprintln(w, e) })
+}
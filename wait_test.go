@@ -0,0 +1,55 @@
+package main
+
+import (
+  "strings"
+  "testing"
+  "time"
+  . "github.com/smartystreets/goconvey/convey"
+)
+
+func TestWait(t *testing.T) {
+
+  Convey("Given a fake Kinesis that takes a while to change streams", t, func() {
+    svc, s := newFakeStream("clicks", 1)
+    g := streamGroupFor(svc, s)
+    saved := statusPollInterval
+    statusPollInterval = 5 * time.Millisecond
+    defer func() { statusPollInterval = saved }()
+    svc.StateDelay = 30 * time.Millisecond
+
+    Convey("Create --wait should block until ACTIVE, showing each status", func() {
+      out := captureStdout(func() {
+        So(DoICommand("create orders --shards 1 --wait", g), ShouldBeNil)
+      })
+      creating, active := strings.Index(out, "orders is CREATING."), strings.Index(out, "orders is ACTIVE.")
+      So(creating, ShouldBeGreaterThan, 0)
+      So(active, ShouldBeGreaterThan, creating)
+    })
+
+    Convey("Delete --wait should block until the stream is gone", func() {
+      out := captureStdout(func() {
+        So(DoICommand("delete clicks --wait --yes", g), ShouldBeNil)
+      })
+      So(out, ShouldContainSubstring, "clicks is DELETING.")
+      So(out, ShouldContainSubstring, "clicks is DELETED.")
+    })
+
+    Convey("Wait should give up after the timeout", func() {
+      captureStdout(func() {
+        So(DoICommand("create orders --shards 1", g), ShouldBeNil)
+        err := DoICommand("wait orders --timeout 10ms", g)
+        So(err, ShouldNotBeNil)
+        So(err.Error(), ShouldContainSubstring, "still CREATING")
+      })
+    })
+
+    Convey("Waiting for a stream that's gone to be ACTIVE should fail straight away", func() {
+      started := time.Now()
+      captureStdout(func() {
+        So(DoICommand("wait nosuch --for ACTIVE", g), ShouldNotBeNil)
+        So(DoICommand("wait nosuch --for DELETED", g), ShouldBeNil)
+      })
+      So(time.Since(started), ShouldBeLessThan, time.Second)
+    })
+  })
+}